@@ -1,32 +1,400 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/BurntSushi/toml"
+	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+	"gopkg.in/yaml.v3"
 )
 
 // Task represents a single todo item
 type Task struct {
-	ID       int      `json:"id"`
-	Task     string   `json:"task"`
-	Checked  bool     `json:"checked"`
-	Context  string   `json:"context"`
-	Priority string   `json:"priority,omitempty"` // low, medium, high
+	ID              int      `json:"id"`
+	Task            string   `json:"task"`
+	Checked         bool     `json:"checked"`
+	Context         string   `json:"context"`
+	Priority        string   `json:"priority,omitempty"` // low, medium, high
+	Tags            []string `json:"tags,omitempty"`
+	DueDate         string   `json:"due_date,omitempty"`         // YYYY-MM-DD, optionally followed by HH:MM
+	CreatedAt       string   `json:"created_at,omitempty"`       // RFC 3339, set once when added
+	CompletedAt     string   `json:"completed_at,omitempty"`     // RFC 3339, set when checked off
+	Category        string   `json:"category,omitempty"`         // one of the configured categories
+	Estimate        int      `json:"estimate,omitempty"`         // effort in points; 0 = unestimated
+	Attachments     []string `json:"attachments,omitempty"`      // paths of files opened with the OS default app
+	Notes           string   `json:"notes,omitempty"`            // free-form, multi-line
+	ParentID        int      `json:"parent_id,omitempty"`        // set on subtasks; one level of nesting
+	Collapsed       bool     `json:"collapsed,omitempty"`        // subtasks hidden under this parent
+	Schedule        string   `json:"schedule,omitempty"`         // daily, weekdays, weekly or monthly: a fresh copy appears each period; "after weekly" and so on: the next copy once this one is done
+	Generated       string   `json:"generated,omitempty"`        // start of the last period a copy was made for
+	Source          int      `json:"source,omitempty"`           // id of the scheduled task this is a copy of
+	Today           bool     `json:"today,omitempty"`            // picked for today; cleared when the day rolls over
+	Ref             string   `json:"ref,omitempty"`              // git branch or commit the task is linked to
+	Percent         int      `json:"percent,omitempty"`          // partial progress, 0-100; reaching 100 checks the task
+	StartDate       string   `json:"start_date,omitempty"`       // YYYY-MM-DD; with DueDate as the end, the task spans those days
+	CompletionCount int      `json:"completion_count,omitempty"` // times a scheduled task (or a copy of it) was completed; "after" schedules carry it to the next copy
+	Template        bool     `json:"template,omitempty"`         // a canned task: left out of counts and stats, copied into a real task with I
+	Code            string   `json:"code,omitempty"`             // short code such as WRK-12 from the context's code prefix; the numeric id stays the key
+}
+
+// Settings holds user preferences stored alongside the tasks in config.json
+type Settings struct {
+	Glyphs              Glyphs                  `json:"glyphs"`
+	ErrorTimeout        int                     `json:"error_timeout,omitempty"`   // seconds; 0 = default, -1 = until next key
+	KanbanSort          string                  `json:"kanban_sort,omitempty"`     // priority (default), weight (priority, then the biggest estimate), manual
+	KanbanCompact       bool                    `json:"kanban_compact,omitempty"`  // kanban columns show counts instead of cards; c toggles it on the board
+	KanbanEmphasis      bool                    `json:"kanban_emphasis,omitempty"` // mark kanban cards by priority: a heavy bar and bold text for high, a light bar for medium
+	WIPLimits           map[string]int          `json:"wip_limits,omitempty"`      // open tasks allowed per kanban column
+	StaleDays           int                     `json:"stale_days,omitempty"`      // dim open tasks older than this; 0 = off
+	DueTime             string                  `json:"due_time,omitempty"`        // HH:MM deadline for date-only tasks; default end of day
+	WeekStart           string                  `json:"week_start,omitempty"`      // monday (default), sunday
+	SyncPullCmd         string                  `json:"sync_pull_cmd,omitempty"`   // shell command run in the config dir before loading
+	SyncPushCmd         string                  `json:"sync_push_cmd,omitempty"`   // shell command run in the config dir after saving
+	OnComplete          string                  `json:"on_complete,omitempty"`     // strike (default), bottom, hide, or collapse into a "✓ N completed" line that _ expands
+	Templates           []string                `json:"template_contexts,omitempty"`
+	SortOnLoad          bool                    `json:"sort_on_load,omitempty"`      // reorder tasks by sort_keys (default status, priority, due) at startup
+	ClipboardCmd        string                  `json:"clipboard_cmd,omitempty"`     // e.g. "wl-copy"; reads the text on stdin
+	PasteCmd            string                  `json:"paste_cmd,omitempty"`         // e.g. "wl-paste"; prints the text on stdout
+	CompleteBell        bool                    `json:"complete_bell,omitempty"`     // ring the terminal bell when a task is completed
+	DailyGoals          map[string]int          `json:"daily_goals,omitempty"`       // tasks to complete per day, by context
+	Someday             string                  `json:"someday_context,omitempty"`   // parking list kept out of navigation and stats; default "Someday"
+	DoneContext         string                  `json:"done_context,omitempty"`      // where & sweeps completed tasks, kept out of navigation and stats; default "Done"
+	ContextWrap         *bool                   `json:"context_wrap,omitempty"`      // wrap around when cycling contexts; default true
+	TaskWrap            *bool                   `json:"task_wrap,omitempty"`         // wrap around from the last task to the first and back; default true
+	ShowIDs             bool                    `json:"show_ids,omitempty"`          // prefix tasks with their short code, or numeric ID without one
+	ContextSort         string                  `json:"context_sort,omitempty"`      // alpha (default), activity, overdue
+	Categories          map[string]string       `json:"categories,omitempty"`        // category name to label color
+	AddFiltered         string                  `json:"add_filtered,omitempty"`      // apply (default) or clear active filters when adding
+	MaxWidth            int                     `json:"max_width,omitempty"`         // cap and center content on wide terminals; 0 = off
+	RelativeDue         bool                    `json:"relative_dates,omitempty"`    // show due dates as "tomorrow", "in 3d", "2d ago"
+	Archived            []string                `json:"archived_contexts,omitempty"` // contexts hidden from navigation and stats until restored
+	StatsWeight         string                  `json:"stats_weight,omitempty"`      // count (default), priority, estimate
+	EmptyTips           []string                `json:"empty_tips,omitempty"`        // shown in turn on empty contexts instead of the default hint
+	SortKeys            []string                `json:"sort_keys,omitempty"`         // list order, e.g. ["priority","due","title"]; empty = manual order
+	Theme               Theme                   `json:"theme"`
+	ContextDefaults     map[string]TaskDefaults `json:"context_defaults,omitempty"`   // applied to tasks added in the context
+	EnterAction         string                  `json:"enter_action,omitempty"`       // details (default), toggle, edit
+	IdleMinutes         int                     `json:"idle_minutes,omitempty"`       // lock or quit after this long without a key press; 0 = never
+	IdleAction          string                  `json:"idle_action,omitempty"`        // lock (default) or quit, saving first
+	LockPassphrase      string                  `json:"lock_passphrase,omitempty"`    // required to unlock when set
+	RecentLimit         int                     `json:"recent_limit,omitempty"`       // tasks in the recently added view; default 20
+	RecentHours         int                     `json:"recent_hours,omitempty"`       // only tasks added within this many hours; 0 = any age
+	Inbox               string                  `json:"inbox_context,omitempty"`      // where --capture adds tasks and inbox_rules apply
+	AddFromSearch       string                  `json:"add_from_search,omitempty"`    // where a task added from search, recent or today goes: ask (default) for a context, inbox, or current
+	InboxRules          []InboxRule             `json:"inbox_rules,omitempty"`        // route new inbox tasks by keyword
+	AddPosition         string                  `json:"add_position,omitempty"`       // bottom (default) or top of the context
+	TitleProgress       string                  `json:"title_progress,omitempty"`     // completion in the header: off (default), context, overall
+	RenameCollision     string                  `json:"rename_collision,omitempty"`   // renaming onto an existing context: ask (default), merge, refuse
+	ContextCase         string                  `json:"context_case,omitempty"`       // context names differing only in case: merge (default; adding switches to the existing one, renaming follows rename_collision), refuse, or exact to keep both
+	SubtaskCompletion   string                  `json:"subtask_completion,omitempty"` // completing a parent: cascade (default, completes its subtasks), require (all done first), independent
+	TaskReminders       bool                    `json:"task_reminders,omitempty"`     // notify in the app when an open task reaches its deadline while tuido runs
+	SnoozeMinutes       int                     `json:"snooze_minutes,omitempty"`     // how far s pushes back a task reminder; default 10
+	DueReminder         *bool                   `json:"due_reminder,omitempty"`       // summarize overdue and due-today tasks on launch; default true
+	SearchTitleWidth    int                     `json:"search_title_width,omitempty"` // cut task titles in search results to this many cells, ending in "..."; 0 = whole titles
+	TagLimit            int                     `json:"tag_limit,omitempty"`          // tags shown inline before "+N"; 0 = all
+	PlannedOn           string                  `json:"planned_on,omitempty"`         // day the today flags were set for
+	StatsSort           string                  `json:"stats_sort,omitempty"`         // stats context order: list (default), completion (lowest first), open (most first)
+	ViewSort            map[string][]string     `json:"view_sort,omitempty"`          // sort keys per view (normal, kanban, search); overrides sort_keys and kanban_sort
+	ViewComplete        map[string]string       `json:"view_complete,omitempty"`      // what toggling does per view (normal, search, focus, kanban): check (default) ticks the task off where it is, done also files it in done_context, off makes the view read-only
+	ConfirmClearDue     bool                    `json:"confirm_clear_due,omitempty"`  // ask before U clears a due date
+	ToggleKeys          []string                `json:"toggle_keys,omitempty"`        // keys that complete a task, e.g. ["ctrl+x"]; default [" "] (space). Not enter, which confirms dialogs: set enter_action to toggle instead
+	CollapsedContexts   []string                `json:"collapsed_contexts,omitempty"` // parent contexts whose subcontexts are skipped when cycling
+	Duplicates          string                  `json:"duplicates,omitempty"`         // adding a task already open in its context: allow (default), warn, block
+	Hooks               map[string]string       `json:"hooks,omitempty"`              // shell command per event: task-added, task-completed, task-deleted; see hookEnv
+	GroupByPriority     bool                    `json:"group_by_priority,omitempty"`  // list tasks under High/Medium/Low/None/Done headings
+	TaskLimit           int                     `json:"task_limit,omitempty"`         // soft cap on open tasks per context, flagged in the header; 0 = none
+	TaskLimits          map[string]int          `json:"task_limits,omitempty"`        // per-context soft caps, overriding task_limit
+	ContextHours        []ContextHours          `json:"context_hours,omitempty"`      // open the context scheduled for the time of day
+	AuditLog            bool                    `json:"audit_log,omitempty"`          // append every task change to audit.log next to config.json
+	EmptiedContext      string                  `json:"emptied_context,omitempty"`    // after deleting a context's last task: stay (default) or next, the next context with tasks
+	TagLineColors       map[string]string       `json:"tag_line_colors,omitempty"`    // tag (or tag key) to the color of the whole task line; the first mapped tag wins
+	RepeatReopen        string                  `json:"repeat_reopen,omitempty"`      // reopening a task whose "after" schedule made its next copy: remove (default) drops the copy if untouched and takes the schedule back, keep leaves the copy with it
+	PriorityMarks       PriorityMarks           `json:"priority_marks"`
+	QuickAdd            QuickAdd                `json:"quick_add"`
+	FocusNotes          bool                    `json:"focus_notes,omitempty"`           // show the task's notes in focus mode
+	ShowCompletionCount bool                    `json:"show_completion_count,omitempty"` // show "done N×" after repeating tasks
+	EstimateRollup      bool                    `json:"estimate_rollup,omitempty"`       // a parent's estimate shows its own points plus its subtasks
+	SearchAgain         string                  `json:"search_again,omitempty"`          // / in search results: replace (default) starts over, refine searches within them
+	EmptySearch         string                  `json:"empty_search,omitempty"`          // searching for nothing: back (default) just closes the dialog, clear lifts the due, category and notes/attachments filters, all lists every task
+	CollapseSpaces      bool                    `json:"collapse_spaces,omitempty"`       // squeeze runs of spaces in added and edited task text down to one
+	Capitalize          bool                    `json:"capitalize,omitempty"`            // start added and edited task text with a capital letter
+	SparklineDays       int                     `json:"sparkline_days,omitempty"`        // days of completions charted per context in stats, 7 by default; -1 hides the chart
+	Scratchpads         map[string]string       `json:"scratchpads,omitempty"`           // context to its free-form note, shown above its tasks
+	FoldedScratchpads   []string                `json:"folded_scratchpads,omitempty"`    // contexts whose scratchpad shows as one line
+	CodePrefixes        map[string]string       `json:"code_prefixes,omitempty"`         // context to the prefix of its tasks' short codes, e.g. "WRK" for WRK-1, WRK-2; subcontexts share their parent's
+	CodeCounters        map[string]int          `json:"code_counters,omitempty"`         // last number handed out per code prefix
+	ReviewDays          int                     `json:"review_days,omitempty"`           // days between reviews before a banner suggests one; 0 = off
+	LastReview          string                  `json:"last_review,omitempty"`           // RFC 3339, when the list was last marked reviewed
+	Snippets            map[string]string       `json:"snippets,omitempty"`              // trigger to text for the add and edit box, e.g. ";fu": "Follow up with "; expands once typed, or on tab
+	HeatmapWeeks        int                     `json:"heatmap_weeks,omitempty"`         // weeks of completions in the stats heatmap, 12 by default; -1 hides it
+	StatsInclude        []string                `json:"stats_include,omitempty"`         // lists stats count besides active tasks: templates, someday, done, archived; none by default
+	Marks               map[string]int          `json:"marks,omitempty"`                 // letter to the ID of the task marked with it; see ; and '
+	TutorialStep        int                     `json:"tutorial_step,omitempty"`         // first-run tutorial step to show; 0 once it is finished or skipped
+}
+
+// Config is the on-disk layout of config.json
+type Config struct {
+	Tasks  []Task `json:"tasks"`
+	NextID int    `json:"next_id"`
+	Settings
+}
+
+// Glyphs holds the markers used to draw task state
+type Glyphs struct {
+	Preset     string `json:"preset,omitempty"` // unicode (default), ascii
+	Unchecked  string `json:"unchecked,omitempty"`
+	Checked    string `json:"checked,omitempty"`
+	Bullet     string `json:"bullet,omitempty"`     // open task in kanban
+	Done       string `json:"done,omitempty"`       // completed task in kanban
+	Notes      string `json:"notes,omitempty"`      // after tasks with notes
+	Attachment string `json:"attachment,omitempty"` // after tasks with attachments
+	Cursor     string `json:"cursor,omitempty"`     // before the selected task when the theme's selection shows a cursor
+}
+
+// glyphPresets are the built-in glyph sets selectable via "preset"
+var glyphPresets = map[string]Glyphs{
+	"unicode": {Unchecked: "[ ]", Checked: "[✓]", Bullet: "•", Done: "✓", Notes: "📝", Attachment: "📎", Cursor: "▸"},
+	"ascii":   {Unchecked: "[ ]", Checked: "[x]", Bullet: "-", Done: "x", Notes: "(n)", Attachment: "(@)", Cursor: ">"},
+}
+
+// resolve fills any unset glyph from the chosen preset
+func (g Glyphs) resolve() Glyphs {
+	preset, ok := glyphPresets[g.Preset]
+	if !ok {
+		preset = glyphPresets["unicode"]
+	}
+	if g.Unchecked == "" {
+		g.Unchecked = preset.Unchecked
+	}
+	if g.Checked == "" {
+		g.Checked = preset.Checked
+	}
+	if g.Bullet == "" {
+		g.Bullet = preset.Bullet
+	}
+	if g.Done == "" {
+		g.Done = preset.Done
+	}
+	if g.Notes == "" {
+		g.Notes = preset.Notes
+	}
+	if g.Attachment == "" {
+		g.Attachment = preset.Attachment
+	}
+	if g.Cursor == "" {
+		g.Cursor = preset.Cursor
+	}
+	return g
+}
+
+// QuickAdd holds the prefixes of the words typed into a new task that fill
+// in its fields instead, e.g. "Call Bob #phone !high @Work due:tomorrow".
+// "off" turns a prefix off.
+type QuickAdd struct {
+	Tag      string `json:"tag,omitempty"`      // default #
+	Priority string `json:"priority,omitempty"` // default !; high, medium, low or their first letter
+	Context  string `json:"context,omitempty"`  // default @
+	Due      string `json:"due,omitempty"`      // default due:; YYYY-MM-DD, today or tomorrow
+}
+
+// resolve fills any unset prefix with its default
+func (q QuickAdd) resolve() QuickAdd {
+	for _, p := range []struct {
+		prefix *string
+		def    string
+	}{{&q.Tag, "#"}, {&q.Priority, "!"}, {&q.Context, "@"}, {&q.Due, "due:"}} {
+		if *p.prefix == "" {
+			*p.prefix = p.def
+		}
+	}
+	return q
+}
+
+// quickAddPriorities maps the priority words accepted after the priority prefix
+var quickAddPriorities = map[string]string{
+	"high": "high", "h": "high",
+	"medium": "medium", "med": "medium", "m": "medium",
+	"low": "low", "l": "low",
+}
+
+// parse takes the quick-add words out of the first line of text
+// and returns what is left along with the fields they set. Words that
+// don't parse, e.g. an unknown priority, stay in the text.
+func (q QuickAdd) parse(text string, now time.Time) (rest string, fields Task) {
+	first, more, multiline := strings.Cut(text, "\n")
+	var kept []string
+	for _, word := range strings.Fields(first) {
+		if value, ok := quickAddValue(word, q.Due); ok {
+			switch strings.ToLower(value) {
+			case "today":
+				fields.DueDate = now.Format(dueDateLayout)
+				continue
+			case "tomorrow":
+				fields.DueDate = now.AddDate(0, 0, 1).Format(dueDateLayout)
+				continue
+			}
+			if due, ok := parseDueInput(value); ok && due != "" {
+				fields.DueDate = due
+				continue
+			}
+		} else if value, ok := quickAddValue(word, q.Tag); ok {
+			fields.Tags = append(fields.Tags, value)
+			continue
+		} else if value, ok := quickAddValue(word, q.Priority); ok {
+			if priority, ok := quickAddPriorities[strings.ToLower(value)]; ok {
+				fields.Priority = priority
+				continue
+			}
+		} else if value, ok := quickAddValue(word, q.Context); ok {
+			fields.Context = value
+			continue
+		}
+		kept = append(kept, word)
+	}
+
+	// A task made only of quick-add words keeps its text
+	if len(kept) == 0 {
+		return text, Task{}
+	}
+	rest = strings.Join(kept, " ")
+	if multiline {
+		rest += "\n" + more
+	}
+	return rest, fields
+}
+
+// quickAddValue returns what follows prefix in word, if word starts with it
+func quickAddValue(word, prefix string) (string, bool) {
+	if prefix == "off" || !strings.HasPrefix(word, prefix) || len(word) == len(prefix) {
+		return "", false
+	}
+	return word[len(prefix):], true
+}
+
+// PriorityMarks holds the indicators drawn before tasks with a priority
+type PriorityMarks struct {
+	Preset string `json:"preset,omitempty"` // marks (default), numbers, block, text
+	High   string `json:"high,omitempty"`
+	Medium string `json:"medium,omitempty"`
+	Low    string `json:"low,omitempty"`
+}
+
+// priorityMarkPresets are the built-in indicators selectable via "preset"
+var priorityMarkPresets = map[string]PriorityMarks{
+	"marks":   {High: "!!!", Medium: "!!", Low: "!"},
+	"numbers": {High: "P1", Medium: "P2", Low: "P3"},
+	"block":   {High: "█", Medium: "█", Low: "█"},
+	"text":    {High: "high", Medium: "med", Low: "low"},
+}
+
+// resolve fills any unset indicator from the chosen preset
+func (p PriorityMarks) resolve() PriorityMarks {
+	preset, ok := priorityMarkPresets[p.Preset]
+	if !ok {
+		preset = priorityMarkPresets["marks"]
+	}
+	if p.High == "" {
+		p.High = preset.High
+	}
+	if p.Medium == "" {
+		p.Medium = preset.Medium
+	}
+	if p.Low == "" {
+		p.Low = preset.Low
+	}
+	return p
+}
+
+// render returns the colored indicator for priority followed by a space,
+// or "" when there is none
+func (p PriorityMarks) render(priority string) string {
+	switch priority {
+	case "high":
+		return highPriorityStyle.Render(p.High) + " "
+	case "medium":
+		return mediumPriorityStyle.Render(p.Medium) + " "
+	case "low":
+		return lowPriorityStyle.Render(p.Low) + " "
+	}
+	return ""
+}
+
+// TaskDefaults are the fields given to every new task added in a context
+type TaskDefaults struct {
+	Tags     []string `json:"tags,omitempty"`
+	Priority string   `json:"priority,omitempty"`
+	DueIn    *int     `json:"due_in_days,omitempty"` // due this many days after creation; 0 = today
+}
+
+// InboxRule routes a task added to the inbox whose text contains Keyword,
+// ignoring case. The first matching rule wins.
+type InboxRule struct {
+	Keyword  string   `json:"keyword"`
+	Context  string   `json:"context,omitempty"`
 	Tags     []string `json:"tags,omitempty"`
-	DueDate  string   `json:"due_date,omitempty"` // YYYY-MM-DD format
+	Priority string   `json:"priority,omitempty"`
+}
+
+// ContextHours makes Context the default context from From until To, both
+// "HH:MM". A range may run past midnight, e.g. 18:00 to 02:00.
+type ContextHours struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Context string `json:"context"`
+}
+
+// Theme holds color overrides for the built-in styles
+type Theme struct {
+	SelectionBg string `json:"selection_bg,omitempty"` // background of the selected row; default #313244
+	SelectionFg string `json:"selection_fg,omitempty"` // text color of the selected row; default #EE6FF8
+	Background  string `json:"background,omitempty"`   // auto (default) detects the terminal background; light or dark forces one
+	Selection   string `json:"selection,omitempty"`    // how the selected task stands out: background (default), cursor (the cursor glyph in front) or both
+}
+
+// apply overrides the package styles with any configured colors
+func (t Theme) apply() {
+	switch t.Background {
+	case "light":
+		lipgloss.SetHasDarkBackground(false)
+	case "dark":
+		lipgloss.SetHasDarkBackground(true)
+	}
+	if t.SelectionBg != "" {
+		selectedTaskStyle = selectedTaskStyle.Background(lipgloss.Color(t.SelectionBg))
+	}
+	if t.SelectionFg != "" {
+		selectedTaskStyle = selectedTaskStyle.Foreground(lipgloss.Color(t.SelectionFg))
+		cursorStyle = cursorStyle.Foreground(lipgloss.Color(t.SelectionFg))
+	}
 }
 
 // ViewMode represents the current view
@@ -40,8 +408,23 @@ const (
 	InputView
 	DateInputView
 	RemoveTagView
+	URLPickerView
+	CategoryPickerView
+	ArchivePickerView
+	DetailView
+	TextAreaView
+	KeysView
 )
 
+// isDialog reports whether the view is a dialog opened on top of the list
+func (v ViewMode) isDialog() bool {
+	switch v {
+	case InputView, DateInputView, RemoveTagView, URLPickerView, CategoryPickerView, ArchivePickerView, TextAreaView:
+		return true
+	}
+	return false
+}
+
 // InputMode represents different input dialogs
 type InputMode int
 
@@ -50,9 +433,26 @@ const (
 	EditTaskInput
 	AddContextInput
 	RenameContextInput
+	MergeConfirmInput
 	AddTagInput
 	SearchInput
 	DeleteConfirmInput
+	SpawnTemplateInput
+	PromoteInput
+	EstimateInput
+	PercentInput
+	ScheduleInput
+	AttachInput
+	RefInput
+	NotesInput
+	ScratchpadInput
+	DiscardConfirmInput
+	ClearDueConfirmInput
+	BatchScopeInput
+	BatchDueInput
+	AddSubtaskInput
+	AddTargetInput
+	CloneContextInput
 )
 
 // Model represents the application state
@@ -68,27 +468,92 @@ type Model struct {
 	viewMode        ViewMode
 	inputMode       InputMode
 	searchResults   []Task
+	searchQuery     string
+	searchRefines   []string // earlier queries the current one narrows, oldest first
+	searchAgain     bool     // the search dialog was opened from the results
+	recentView      bool
+	todayView       bool
+	focusMode       bool // only the selected task, full screen
+	kanbanColumn    int  // selected column of the kanban board
+	kanbanRow       int  // selected card in that column
+	sortDescending  bool // sort keys applied in reverse
 	prevContext     string
+	lastContext     string // context before the current one, for LastContext
 	prevIndex       int
 	movingMode      bool
 	movingTaskIndex int
+	captureMode     bool
+	captureMulti    bool
+	dueOnly         bool
+	extrasOnly      bool // list only tasks with notes or attachments
+	categoryFilter  string
+	tipIndex        int
+	contextChosen   bool
+	dueReminder     string
+	reviewReminder  string
+	notifyTask      int               // ID of the task whose reminder is showing; 0 = none
+	remindAt        map[int]time.Time // snoozed reminders, by task ID
+	reminded        map[int]bool      // reminders dismissed or followed this session
+	remindSince     time.Time         // deadlines before this were covered by the launch summary
+	replayTutorial  bool   // --tutorial: start the first-run tutorial again
+	exportWrap      int    // --wrap: column Markdown exports wrap at; 0 leaves lines whole
+	exportGroup     string // --group-by: "tag" heads Markdown exports by tag instead of context
+	exportOnly      string // --only-open or --only-done: "open" or "done" to export just those tasks
+	filing          bool   // x was pressed; the next digit picks the context to move the task to
+	marking         string // "set" after ; or "jump" after ', until the mark letter is pressed
+	showCompleted   bool   // with on_complete collapse, list the completed tasks instead of the summary line
+	contextLocked   bool
+	dirty           bool
+	loading         bool
+	locked          bool
+	lastKeyAt       time.Time
+	lockInput       textinput.Model
+	spinner         spinner.Model
+	sideReturn      string // context to go back to from the someday or done list
 	
 	// Input handling
 	textInput       textinput.Model
+	textArea        textarea.Model
 	dateInputs      []textinput.Model
 	dateInputIndex  int
+	dateCalendar    bool
+	dateRangeStart  string // set while picking the end of a date range
 	removeTagIndex  int
 	removeTagChecks []bool
+	tagOrder        []string // the task's tags as reordered in the tag dialog, applied on enter
+	urlChoices      []string
+	urlIndex        int
+	categoryIndex   int
+	archiveIndex    int
+	detailReturn    ViewMode
+	detailTask      int // ID of the task the details view shows
+	detailOffset    int // first line shown when the details don't fit
+	keysQuery       string
+	keysOffset      int
+	mergeTarget     string
+	addTarget       string // context asked for when adding from search results; cleared once the add dialog closes
+	followUp        bool   // the add dialog follows a ctrl+n completion and shares its undo step
+	addParent       Task   // parent of the subtask addTask is adding, if any
+	batchScope      string
 	inputPrompt     string
 	
 	// UI state
 	windowWidth     int
 	windowHeight    int
 	errorMessage    string
+	errorSetAt      time.Time
+	statusMessage   string
+	statusID        int
+	messages        []message // recent status and error messages, oldest first
+	showMessages    bool
 	
+	// Hook events waiting to run after the current key
+	pendingHooks    []hookEvent
+
 	// History for undo
-	history         [][]Task
+	history         []undoState
 	maxHistory      int
+	historyEvicted  bool
 	
 	// Keybindings
 	keyMap          KeyMap
@@ -96,6 +561,13 @@ type Model struct {
 	
 	// Config
 	configPath      string
+	configFile      string // where saveConfig writes; its extension picks the format
+	configFormat    string // format asked for with --format, converting on the next save
+	convertedFrom   string // file in the old format, set aside once the converted one is saved
+	configError     string // why the config file could not be read; saving is off until it parses
+	settings        Settings
+	glyphs          Glyphs
+	priorityMarks   PriorityMarks
 }
 
 // KeyMap defines key bindings
@@ -105,28 +577,112 @@ type KeyMap struct {
 	Left           key.Binding
 	Right          key.Binding
 	Toggle         key.Binding
+	FollowUp       key.Binding
+	ToggleAll      key.Binding
 	Add            key.Binding
 	Edit           key.Binding
+	AddSubtask     key.Binding
+	Fold           key.Binding
+	FoldCompleted  key.Binding
 	Delete         key.Binding
 	Search         key.Binding
 	AddContext     key.Binding
 	RenameContext  key.Binding
 	DeleteContext  key.Binding
 	TogglePriority key.Binding
+	LowerPriority  key.Binding
+	SetPriority    key.Binding
 	AddTag         key.Binding
 	RemoveTag      key.Binding
 	SetDueDate     key.Binding
 	ClearDueDate   key.Binding
+	BatchDueDate   key.Binding
 	KanbanView     key.Binding
 	StatsView      key.Binding
 	Undo           key.Binding
 	Move           key.Binding
+	OpenURL        key.Binding
+	Attach         key.Binding
+	Details        key.Binding
+	Notes          key.Binding
+	Scratchpad     key.Binding
+	FoldScratchpad key.Binding
+	MultiLine      key.Binding
+	Expand         key.Binding
+	Commit         key.Binding
+	Copy           key.Binding
+	CopyBranch     key.Binding
+	SetRef         key.Binding
+	Paste          key.Binding
+	DueFilter      key.Binding
+	ExtrasFilter   key.Binding
+	NextDue        key.Binding
+	NextTagged     key.Binding
+	MarkReviewed   key.Binding
+	LastContext    key.Binding
+	FileTo         key.Binding
+	SetMark        key.Binding
+	JumpMark       key.Binding
+	SetCategory    key.Binding
+	SetEstimate    key.Binding
+	SetPercent     key.Binding
+	SetSchedule    key.Binding
+	CategoryFilter key.Binding
+	Someday        key.Binding
+	DoneList       key.Binding
+	SweepDone      key.Binding
+	LockContext    key.Binding
+	FoldContext    key.Binding
+	Recent         key.Binding
+	FlagToday      key.Binding
+	TodayView      key.Binding
+	ShowIDs        key.Binding
+	Focus          key.Binding
+	GroupPriority  key.Binding
+	SortDirection  key.Binding
+	RelativeDates  key.Binding
+	Park           key.Binding
+	MarkTemplate   key.Binding
+	TaskTemplate   key.Binding
+	Archive        key.Binding
+	Restore        key.Binding
+	SpawnTemplate  key.Binding
+	CloneContext   key.Binding
+	Report         key.Binding
+	ExportContext  key.Binding
+	Keys           key.Binding
+	Messages       key.Binding
+	EditConfig     key.Binding
 	Quit           key.Binding
+	QuitNoSave     key.Binding
 	Back           key.Binding
 	Enter          key.Binding
+	Calendar       key.Binding
+	DateRange      key.Binding
+	SelectAll      key.Binding
+	TagUp          key.Binding
+	TagDown        key.Binding
+	StatsOrder     key.Binding
+	KanbanCompact  key.Binding
+	Snooze         key.Binding
 	Nav            key.Binding
 }
 
+// toggleBinding builds the complete/reopen binding from toggle_keys
+func toggleBinding(keys []string) key.Binding {
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = k
+		if k == " " {
+			labels[i] = "space"
+		}
+	}
+	return key.NewBinding(
+		key.WithKeys(keys...),
+		key.WithHelp(strings.Join(labels, "/"), "toggle"),
+	)
+}
+
 // DefaultKeyMap returns default key bindings
 func DefaultKeyMap() KeyMap {
 	return KeyMap{
@@ -150,10 +706,30 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys(" "),
 			key.WithHelp("space", "toggle"),
 		),
+		FollowUp: key.NewBinding(
+			key.WithKeys("ctrl+n"),
+			key.WithHelp("ctrl+n", "done + follow-up"),
+		),
+		ToggleAll: key.NewBinding(
+			key.WithKeys("A"),
+			key.WithHelp("A", "toggle all"),
+		),
 		Add: key.NewBinding(
 			key.WithKeys("a"),
 			key.WithHelp("a", "add task"),
 		),
+		AddSubtask: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "add subtask"),
+		),
+		Fold: key.NewBinding(
+			key.WithKeys("-"),
+			key.WithHelp("-", "fold subtasks"),
+		),
+		FoldCompleted: key.NewBinding(
+			key.WithKeys("_"),
+			key.WithHelp("_", "fold completed"),
+		),
 		Edit: key.NewBinding(
 			key.WithKeys("e"),
 			key.WithHelp("e", "edit"),
@@ -182,13 +758,21 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("p"),
 			key.WithHelp("p", "priority"),
 		),
+		LowerPriority: key.NewBinding(
+			key.WithKeys("ctrl+p"),
+			key.WithHelp("ctrl+p", "priority down"),
+		),
+		SetPriority: key.NewBinding(
+			key.WithKeys("1", "2", "3", "0"),
+			key.WithHelp("1/2/3/0", "high/medium/low/no priority"),
+		),
 		AddTag: key.NewBinding(
 			key.WithKeys("t"),
 			key.WithHelp("t", "add tag"),
 		),
 		RemoveTag: key.NewBinding(
 			key.WithKeys("T"),
-			key.WithHelp("T", "remove tag"),
+			key.WithHelp("T", "remove/reorder tags"),
 		),
 		SetDueDate: key.NewBinding(
 			key.WithKeys("u"),
@@ -198,6 +782,10 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("U"),
 			key.WithHelp("U", "clear due"),
 		),
+		BatchDueDate: key.NewBinding(
+			key.WithKeys("G"),
+			key.WithHelp("G", "due date for tag/context"),
+		),
 		KanbanView: key.NewBinding(
 			key.WithKeys("v"),
 			key.WithHelp("v", "kanban"),
@@ -214,10 +802,222 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("m"),
 			key.WithHelp("m", "move"),
 		),
+		OpenURL: key.NewBinding(
+			key.WithKeys("o"),
+			key.WithHelp("o", "open link"),
+		),
+		Attach: key.NewBinding(
+			key.WithKeys("F"),
+			key.WithHelp("F", "attach file"),
+		),
+		Details: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "details"),
+		),
+		Focus: key.NewBinding(
+			key.WithKeys("ctrl+f"),
+			key.WithHelp("ctrl+f", "focus mode"),
+		),
+		Notes: key.NewBinding(
+			key.WithKeys("N"),
+			key.WithHelp("N", "notes"),
+		),
+		Scratchpad: key.NewBinding(
+			key.WithKeys("="),
+			key.WithHelp("=", "context scratchpad"),
+		),
+		FoldScratchpad: key.NewBinding(
+			key.WithKeys("^"),
+			key.WithHelp("^", "fold scratchpad"),
+		),
+		MultiLine: key.NewBinding(
+			key.WithKeys("alt+enter"),
+			key.WithHelp("alt+enter", "multi-line"),
+		),
+		Expand: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "expand snippet"),
+		),
+		Commit: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "save"),
+		),
+		Copy: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "copy"),
+		),
+		CopyBranch: key.NewBinding(
+			key.WithKeys("b"),
+			key.WithHelp("b", "copy branch name"),
+		),
+		SetRef: key.NewBinding(
+			key.WithKeys("B"),
+			key.WithHelp("B", "link git ref"),
+		),
+		Paste: key.NewBinding(
+			key.WithKeys("P"),
+			key.WithHelp("P", "paste tasks"),
+		),
+		DueFilter: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "only dated"),
+		),
+		ExtrasFilter: key.NewBinding(
+			key.WithKeys("@"),
+			key.WithHelp("@", "only with notes/files"),
+		),
+		NextDue: key.NewBinding(
+			key.WithKeys("!"),
+			key.WithHelp("!", "next overdue/today"),
+		),
+		NextTagged: key.NewBinding(
+			key.WithKeys("J"),
+			key.WithHelp("J", "next with same tag"),
+		),
+		MarkReviewed: key.NewBinding(
+			key.WithKeys("*"),
+			key.WithHelp("*", "mark list reviewed"),
+		),
+		LastContext: key.NewBinding(
+			key.WithKeys("`"),
+			key.WithHelp("`", "last context"),
+		),
+		FileTo: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x 1-9", "move to context N"),
+		),
+		SetMark: key.NewBinding(
+			key.WithKeys(";"),
+			key.WithHelp("; a-z", "mark task"),
+		),
+		JumpMark: key.NewBinding(
+			key.WithKeys("'"),
+			key.WithHelp("' a-z", "jump to mark"),
+		),
+		SetCategory: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "category"),
+		),
+		SetEstimate: key.NewBinding(
+			key.WithKeys("E"),
+			key.WithHelp("E", "estimate"),
+		),
+		SetPercent: key.NewBinding(
+			key.WithKeys("%"),
+			key.WithHelp("%", "percent done"),
+		),
+		SetSchedule: key.NewBinding(
+			key.WithKeys("g"),
+			key.WithHelp("g", "repeat schedule"),
+		),
+		CategoryFilter: key.NewBinding(
+			key.WithKeys("C"),
+			key.WithHelp("C", "filter category"),
+		),
+		Recent: key.NewBinding(
+			key.WithKeys("+"),
+			key.WithHelp("+", "recently added"),
+		),
+		FlagToday: key.NewBinding(
+			key.WithKeys("."),
+			key.WithHelp(".", "do today"),
+		),
+		TodayView: key.NewBinding(
+			key.WithKeys(","),
+			key.WithHelp(",", "today's tasks"),
+		),
+		LockContext: key.NewBinding(
+			key.WithKeys("L"),
+			key.WithHelp("L", "lock context"),
+		),
+		FoldContext: key.NewBinding(
+			key.WithKeys("Z"),
+			key.WithHelp("Z", "fold subcontexts"),
+		),
+		Someday: key.NewBinding(
+			key.WithKeys("~"),
+			key.WithHelp("~", "someday list"),
+		),
+		DoneList: key.NewBinding(
+			key.WithKeys("$"),
+			key.WithHelp("$", "done list"),
+		),
+		SweepDone: key.NewBinding(
+			key.WithKeys("&"),
+			key.WithHelp("&", "sweep completed to done list"),
+		),
+		RelativeDates: key.NewBinding(
+			key.WithKeys("R"),
+			key.WithHelp("R", "relative dates"),
+		),
+		ShowIDs: key.NewBinding(
+			key.WithKeys("#"),
+			key.WithHelp("#", "show ids"),
+		),
+		GroupPriority: key.NewBinding(
+			key.WithKeys("H"),
+			key.WithHelp("H", "group by priority"),
+		),
+		SortDirection: key.NewBinding(
+			key.WithKeys("ctrl+o"),
+			key.WithHelp("ctrl+o", "flip sort"),
+		),
+		Park: key.NewBinding(
+			key.WithKeys(">"),
+			key.WithHelp(">", "park/promote"),
+		),
+		MarkTemplate: key.NewBinding(
+			key.WithKeys("M"),
+			key.WithHelp("M", "mark template"),
+		),
+		TaskTemplate: key.NewBinding(
+			key.WithKeys("Y"),
+			key.WithHelp("Y", "task template"),
+		),
+		Archive: key.NewBinding(
+			key.WithKeys("X"),
+			key.WithHelp("X", "archive context"),
+		),
+		Restore: key.NewBinding(
+			key.WithKeys("V"),
+			key.WithHelp("V", "restore context"),
+		),
+		SpawnTemplate: key.NewBinding(
+			key.WithKeys("I"),
+			key.WithHelp("I", "use template"),
+		),
+		CloneContext: key.NewBinding(
+			key.WithKeys("K"),
+			key.WithHelp("K", "clone context"),
+		),
 		Quit: key.NewBinding(
 			key.WithKeys("q", "ctrl+c"),
 			key.WithHelp("q", "quit"),
 		),
+		Report: key.NewBinding(
+			key.WithKeys("w"),
+			key.WithHelp("w", "write report"),
+		),
+		ExportContext: key.NewBinding(
+			key.WithKeys("O"),
+			key.WithHelp("O", "export context"),
+		),
+		Keys: key.NewBinding(
+			key.WithKeys("?"),
+			key.WithHelp("?", "all keybindings"),
+		),
+		Messages: key.NewBinding(
+			key.WithKeys("W"),
+			key.WithHelp("W", "recent messages"),
+		),
+		EditConfig: key.NewBinding(
+			key.WithKeys("ctrl+e"),
+			key.WithHelp("ctrl+e", "edit config"),
+		),
+		QuitNoSave: key.NewBinding(
+			key.WithKeys("Q"),
+			key.WithHelp("Q", "quit without saving"),
+		),
 		Back: key.NewBinding(
 			key.WithKeys("esc"),
 			key.WithHelp("esc", "back"),
@@ -226,6 +1026,38 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "confirm"),
 		),
+		SelectAll: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "select all/none"),
+		),
+		TagUp: key.NewBinding(
+			key.WithKeys("shift+up", "K"),
+			key.WithHelp("shift+↑", "move tag up"),
+		),
+		TagDown: key.NewBinding(
+			key.WithKeys("shift+down", "J"),
+			key.WithHelp("shift+↓", "move tag down"),
+		),
+		StatsOrder: key.NewBinding(
+			key.WithKeys("o"),
+			key.WithHelp("o", "order stats"),
+		),
+		KanbanCompact: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "compact board"),
+		),
+		Snooze: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "snooze reminder"),
+		),
+		Calendar: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "calendar/fields"),
+		),
+		DateRange: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "date range"),
+		),
 		Nav: key.NewBinding(
 			key.WithKeys("↑", "↓", "←", "→"),
 			key.WithHelp("↑↓←→", "navigation"),
@@ -233,7 +1065,40 @@ func DefaultKeyMap() KeyMap {
 	}
 }
 
-// Styles
+// defaultContext is used whenever no other context exists
+const defaultContext = "Work"
+
+// statusTTL is how long a transient status message stays visible
+const statusTTL = 3 * time.Second
+
+// defaultErrorTimeout is how long an error stays visible unless configured
+const defaultErrorTimeout = 5 * time.Second
+
+// statusExpiredMsg clears the status message with the matching ID
+type statusExpiredMsg int
+
+// errorExpiredMsg clears the error message set at the given time
+type errorExpiredMsg time.Time
+
+// dayChangedMsg arrives just after midnight, when scheduled tasks may be due
+type dayChangedMsg struct{}
+
+// hookDoneMsg reports how a hook command finished
+type hookDoneMsg struct {
+	event string
+	err   error
+}
+
+// configEditedMsg arrives when the editor opened on the config file exits
+type configEditedMsg struct {
+	err error
+}
+
+// idleCheckMsg asks whether the idle timeout has passed since the last key press
+type idleCheckMsg struct{}
+
+// Styles use adaptive colors: the Dark variant is picked on dark terminals,
+// the Light one on light terminals (see Theme.Background)
 var (
 	// Base styles
 	baseStyle = lipgloss.NewStyle().
@@ -243,7 +1108,7 @@ var (
 	// Title styles
 	titleStyle = lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#FFFDF5")).
-		Background(lipgloss.Color("#25A065")).
+		Background(lipgloss.AdaptiveColor{Light: "#1E7A4C", Dark: "#25A065"}).
 		Padding(0, 1).
 		Bold(true)
 
@@ -252,37 +1117,90 @@ var (
 		PaddingLeft(2)
 
 	selectedTaskStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#EE6FF8")).
-		Background(lipgloss.Color("#313244")).
+		Foreground(lipgloss.AdaptiveColor{Light: "#8839EF", Dark: "#EE6FF8"}).
+		Background(lipgloss.AdaptiveColor{Light: "#DCE0E8", Dark: "#313244"}).
 		PaddingLeft(2)
 
+	cursorStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#8839EF", Dark: "#EE6FF8"}).
+		Bold(true)
+
 	completedTaskStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#A6E3A1")).
-		Strikethrough(true)
+		Foreground(lipgloss.AdaptiveColor{Light: "#40A02B", Dark: "#A6E3A1"}).
+		Strikethrough(true).
+		PaddingLeft(2)
+
+	ghostStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#8C8FA1", Dark: "#6C7086"}).
+		Italic(true).
+		PaddingLeft(2)
+
+	templateTaskStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#8C8FA1", Dark: "#6C7086"}).
+		PaddingLeft(2)
+
+	staleTaskStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#7C7F93", Dark: "#7F849C"}).
+		PaddingLeft(2)
 
 	// Priority styles
 	highPriorityStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#F38BA8"))
+		Foreground(lipgloss.AdaptiveColor{Light: "#D20F39", Dark: "#F38BA8"})
 
 	mediumPriorityStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#FAB387"))
+		Foreground(lipgloss.AdaptiveColor{Light: "#FE640B", Dark: "#FAB387"})
 
 	lowPriorityStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#F9E2AF"))
+		Foreground(lipgloss.AdaptiveColor{Light: "#DF8E1D", Dark: "#F9E2AF"})
+
+	// Due date styles
+	overdueTaskStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#D20F39", Dark: "#F38BA8"}).
+		PaddingLeft(2)
+
+	scratchpadStyle = lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(lipgloss.AdaptiveColor{Light: "#8C8FA1", Dark: "#6C7086"}).
+		PaddingLeft(1)
+
+	tutorialStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.AdaptiveColor{Light: "#1E66F5", Dark: "#89B4FA"}).
+		Padding(0, 1)
+
+	reminderStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#1E1E2E")).
+		Background(lipgloss.Color("#F9E2AF")).
+		Bold(true).
+		Padding(0, 1)
 
 	// Context styles
 	contextStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#89B4FA")).
+		Foreground(lipgloss.AdaptiveColor{Light: "#1E66F5", Dark: "#89B4FA"}).
+		Bold(true)
+
+	overLimitStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#D20F39", Dark: "#F38BA8"}).
 		Bold(true)
 
 	// Error style
 	errorStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#F38BA8")).
+		Foreground(lipgloss.AdaptiveColor{Light: "#D20F39", Dark: "#F38BA8"}).
 		Bold(true)
 
 	// Help style
 	helpStyle = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#6C7086"))
+		Foreground(lipgloss.AdaptiveColor{Light: "#8C8FA1", Dark: "#6C7086"})
+
+	// Status line styles
+	statusModeStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#EFF1F5", Dark: "#1E1E2E"}).
+		Background(lipgloss.AdaptiveColor{Light: "#1E66F5", Dark: "#89B4FA"}).
+		Padding(0, 1).
+		Bold(true)
+
+	statusMessageStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.AdaptiveColor{Light: "#40A02B", Dark: "#A6E3A1"})
 
 	// Input styles
 	inputStyle = lipgloss.NewStyle().
@@ -291,17 +1209,23 @@ var (
 		Margin(1)
 )
 
-// Initialize creates a new model
-func Initialize() Model {
-	homeDir, _ := os.UserHomeDir()
-	configPath := filepath.Join(homeDir, ".config", "tuido")
+// newModel builds a model with no tasks loaded yet
+func newModel() Model {
+	configPath := defaultConfigPath()
 
 	ti := textinput.New()
 	ti.Focus()
 	ti.CharLimit = 200
 	ti.Width = 50
 
-	dateInputs := make([]textinput.Model, 3)
+	ta := textarea.New()
+	ta.CharLimit = 0
+	ta.SetWidth(60)
+	ta.SetHeight(8)
+	ta.ShowLineNumbers = false
+
+	// Day, month, year, then the optional hour and minute
+	dateInputs := make([]textinput.Model, 5)
 	for i := range dateInputs {
 		dateInputs[i] = textinput.New()
 		dateInputs[i].Focus()
@@ -311,92 +1235,716 @@ func Initialize() Model {
 
 	m := Model{
 		textInput:      ti,
+		textArea:       ta,
 		dateInputs:     dateInputs,
 		keyMap:         DefaultKeyMap(),
 		help:           help.New(),
 		configPath:     configPath,
 		maxHistory:     50,
 		viewMode:       NormalView,
+		spinner:        spinner.New(spinner.WithSpinner(spinner.Dot)),
+		lockInput:      textinput.New(),
+		lastKeyAt:      time.Now(),
+		remindSince:    time.Now(),
 	}
+	m.lockInput.EchoMode = textinput.EchoPassword
+	m.lockInput.Placeholder = "passphrase"
+
+	return m
+}
 
+// load reads config.json and prepares the task list for display
+func (m *Model) load() {
 	m.loadConfig()
+	if m.settings.SortOnLoad {
+		sortTasks(m.tasks, m.sortKeys())
+	}
+	m.clearTodayFlags(time.Now())
+	if n := m.generateScheduled(time.Now()); n > 0 {
+		m.setStatus(fmt.Sprintf("Added %d scheduled task(s)", n))
+	}
 	m.updateContexts()
+	m.followContextHours(time.Now())
+	m.glyphs = m.settings.Glyphs.resolve()
+	m.priorityMarks = m.settings.PriorityMarks.resolve()
+	m.settings.Theme.apply()
+	if len(m.settings.ToggleKeys) > 0 {
+		// Enter has to stay free to confirm dialogs such as tag removal
+		keys := removeMatching(m.settings.ToggleKeys, func(k string) bool { return k == "enter" })
+		if len(keys) < len(m.settings.ToggleKeys) {
+			m.errorMessage = "toggle_keys can't use enter, which confirms dialogs; set enter_action to toggle instead"
+		}
+		if len(keys) > 0 {
+			m.keyMap.Toggle = toggleBinding(keys)
+		}
+	}
+	if len(m.settings.EmptyTips) > 0 {
+		m.tipIndex = rand.Intn(len(m.settings.EmptyTips))
+	}
+	if boolOr(m.settings.DueReminder, true) {
+		m.dueReminder = m.dueSummary()
+	}
+	m.reviewReminder = m.reviewSummary(time.Now())
+	// A config that doesn't parse returns above, so this never starts the
+	// tutorial over settings that aren't the user's
+	if m.replayTutorial && m.configError == "" {
+		m.settings.TutorialStep = 1
+	}
+}
 
-	return m
+// Tutorial
+
+// tutorialStep is one step of the first-run tutorial: what to try, and how
+// to tell from the model before and after a key press that it was done. A
+// prompt naming a remappable key has a %s for it, filled in from key.
+type tutorialStep struct {
+	prompt string
+	key    func(k KeyMap) key.Binding
+	done   func(before, after *Model) bool
 }
 
-// Init implements tea.Model
-func (m Model) Init() tea.Cmd {
-	return textinput.Blink
+// tutorialSteps walks through the basics; the last step has nothing to do
+// but press enter
+var tutorialSteps = []tutorialStep{
+	{"Press a, type something you need to do and press enter to add it.", nil, func(before, after *Model) bool {
+		return len(after.tasks) > len(before.tasks)
+	}},
+	{"Press %s to tick the selected task off. Pressing it again reopens it.", func(k KeyMap) key.Binding { return k.Toggle }, func(before, after *Model) bool {
+		return checkedCount(after.tasks) != checkedCount(before.tasks)
+	}},
+	{"Tasks live in contexts. Press ←/→ to switch between them, or n to add a new one.", nil, func(before, after *Model) bool {
+		return after.currentContext != before.currentContext
+	}},
+	{"Add a task here too, then press p to cycle its priority, or 1, 2, 3 and 0 to set it.", nil, func(before, after *Model) bool {
+		for _, task := range after.tasks {
+			if i := before.findTaskIndex(task.ID); i >= 0 && before.tasks[i].Priority != task.Priority {
+				return true
+			}
+		}
+		return false
+	}},
+	{"That's the basics. Press ? any time to see every key. Press enter to start.", nil, nil},
 }
 
-// Update implements tea.Model  
-func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.WindowSizeMsg:
-		m.windowWidth = msg.Width
-		m.windowHeight = msg.Height
-		m.help.Width = msg.Width
-		return m, tea.ClearScreen
+// checkedCount counts the completed tasks
+func checkedCount(tasks []Task) int {
+	n := 0
+	for _, task := range tasks {
+		if task.Checked {
+			n++
+		}
+	}
+	return n
+}
 
-	case tea.KeyMsg:
-		// Clear error message on any key press
-		m.errorMessage = ""
+// advanceTutorial moves to the next tutorial step once a key press has done
+// what the current one asks
+func (m *Model) advanceTutorial(before *Model) {
+	step := m.settings.TutorialStep
+	if step <= 0 || step > len(tutorialSteps) || step != before.settings.TutorialStep {
+		return
+	}
+	if done := tutorialSteps[step-1].done; done != nil && done(before, m) {
+		m.settings.TutorialStep++
+	}
+}
 
-		// Handle input mode
-		if m.viewMode == InputView {
-			return m.updateInputMode(msg)
-		} else if m.viewMode == DateInputView {
-			return m.updateDateInputMode(msg)
-		} else if m.viewMode == RemoveTagView {
-			return m.updateRemoveTagMode(msg)
-		}
+// finishTutorial ends the tutorial for good; --tutorial brings it back
+func (m *Model) finishTutorial(completed bool) {
+	m.settings.TutorialStep = 0
+	m.dirty = true
+	if completed {
+		m.setStatus("Tutorial finished; run tuido --tutorial to see it again")
+	} else {
+		m.setStatus("Tutorial skipped; run tuido --tutorial to see it again")
+	}
+}
+
+// renderTutorial renders the current tutorial step as a box above the list
+func (m Model) renderTutorial() string {
+	step := min(m.settings.TutorialStep, len(tutorialSteps))
+	prompt := tutorialSteps[step-1].prompt
+	if binding := tutorialSteps[step-1].key; binding != nil {
+		prompt = fmt.Sprintf(prompt, binding(m.keyMap).Help().Key)
+	}
+	text := fmt.Sprintf("Tutorial %d/%d\n%s\n%s", step, len(tutorialSteps), prompt, helpStyle.Render("esc to skip"))
+	if width := m.detailWidth(); width > 0 {
+		return tutorialStyle.Width(width).Render(text)
+	}
+	return tutorialStyle.Render(text)
+}
+
+// dueSummary counts the open tasks that are overdue or due today across all
+// contexts, or returns "" when there are none
+func (m *Model) dueSummary() string {
+	overdue, today := m.agenda()
+	if len(overdue) == 0 && len(today) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d overdue, %d due today", len(overdue), len(today))
+}
 
-		// Handle different view modes
-		switch m.viewMode {
-		case NormalView, SearchView:
-			return m.updateNormalView(msg)
-		case KanbanView:
-			return m.updateKanbanView(msg)
-		case StatsView:
-			return m.updateStatsView(msg)
+// reviewSummary says how long it has been since the last review, once that
+// is review_days or more, or "" when no review is due
+func (m *Model) reviewSummary(now time.Time) string {
+	if m.settings.ReviewDays <= 0 {
+		return ""
+	}
+	stale := 0
+	for _, task := range m.tasks {
+		if _, ok := m.staleAge(task); ok {
+			stale++
 		}
 	}
+	staleText := ""
+	if stale > 0 {
+		staleText = fmt.Sprintf(", %d stale task(s)", stale)
+	}
 
-	return m, nil
+	last, err := time.Parse(time.RFC3339, m.settings.LastReview)
+	if err != nil {
+		return "Time for a review" + staleText
+	}
+	days := int(now.Sub(last).Hours() / 24)
+	if days < m.settings.ReviewDays {
+		return ""
+	}
+	return fmt.Sprintf("%d days since your last review%s", days, staleText)
 }
 
-// updateInputMode handles input dialog updates
-func (m Model) updateInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
+// markReviewed records a review now, clearing the review banner until
+// review_days have passed again
+func (m *Model) markReviewed() {
+	m.settings.LastReview = time.Now().Format(time.RFC3339)
+	m.reviewReminder = ""
+	m.dirty = true
+	if m.settings.ReviewDays > 0 {
+		m.setStatus(fmt.Sprintf("Marked reviewed; next review in %d days", m.settings.ReviewDays))
+	} else {
+		m.setStatus("Marked reviewed")
+	}
+}
 
-	switch {
-	case key.Matches(msg, m.keyMap.Back):
-		m.viewMode = NormalView
-		return m, nil
+// agenda splits the open tasks that need attention into overdue and due
+// today, each ordered by context and then due date
+func (m *Model) agenda() (overdue, today []Task) {
+	for _, task := range m.tasks {
+		if m.isOverdue(task) {
+			overdue = append(overdue, task)
+		} else if !task.Checked && !task.Template && m.matchesDue(task, "today") {
+			today = append(today, task)
+		}
+	}
+	for _, bucket := range [][]Task{overdue, today} {
+		sort.SliceStable(bucket, func(i, j int) bool {
+			ci, cj := m.findContextIndex(bucket[i].Context), m.findContextIndex(bucket[j].Context)
+			if ci != cj {
+				return ci < cj
+			}
+			return bucket[i].DueDate < bucket[j].DueDate
+		})
+	}
+	return overdue, today
+}
 
-	case key.Matches(msg, m.keyMap.Enter):
-		input := strings.TrimSpace(m.textInput.Value())
-		m.textInput.SetValue("")
+// configLoadedMsg carries the model once config.json has been loaded in the background
+type configLoadedMsg struct {
+	model Model
+}
+
+// loadInBackground loads a copy of the model off the UI goroutine
+func (m Model) loadInBackground() tea.Cmd {
+	return func() tea.Msg {
+		m.load()
+		m.loading = false
+		return configLoadedMsg{model: m}
+	}
+}
+
+// defaultConfigPath returns the directory holding config.json
+func defaultConfigPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".config", "tuido")
+}
+
+// Init implements tea.Model
+func (m Model) Init() tea.Cmd {
+	if m.loading {
+		return tea.Batch(m.spinner.Tick, m.loadInBackground())
+	}
+	if m.statusMessage != "" {
+		return tea.Batch(textinput.Blink, m.expireStatus(), m.checkIdleIn(m.idleTimeout()), checkDayChange(), m.checkContextHours(), m.checkReminders())
+	}
+	return tea.Batch(textinput.Blink, m.checkIdleIn(m.idleTimeout()), checkDayChange(), m.checkContextHours(), m.checkReminders())
+}
+
+// contextHoursMsg asks to switch to the context scheduled for the time of day
+type contextHoursMsg struct{}
+
+// checkContextHours schedules a contextHoursMsg at the start of the next
+// minute, as long as context_hours is set
+func (m Model) checkContextHours() tea.Cmd {
+	if len(m.settings.ContextHours) == 0 {
+		return nil
+	}
+	return tea.Tick(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)), func(time.Time) tea.Msg {
+		return contextHoursMsg{}
+	})
+}
+
+// scheduledContext returns the context context_hours gives for now, if any
+func (m *Model) scheduledContext(now time.Time) (string, bool) {
+	clock := now.Format("15:04")
+	for _, slot := range m.settings.ContextHours {
+		inRange := slot.From <= clock && clock < slot.To
+		if slot.From > slot.To {
+			inRange = clock >= slot.From || clock < slot.To
+		}
+		if inRange && indexOf(m.navigableContexts(), slot.Context) >= 0 {
+			return slot.Context, true
+		}
+	}
+	return "", false
+}
+
+// followContextHours switches to the scheduled context, unless a context
+// was picked by hand this session
+func (m *Model) followContextHours(now time.Time) {
+	if m.contextChosen || m.contextLocked {
+		return
+	}
+	if context, ok := m.scheduledContext(now); ok && context != m.currentContext {
+		m.currentContext = context
+		m.selectedIndex = 0
+	}
+}
+
+// reminderMsg asks to look for a task reminder that has come due
+type reminderMsg struct{}
+
+// checkReminders schedules a reminderMsg at the start of the next minute, as
+// long as task_reminders is on
+func (m Model) checkReminders() tea.Cmd {
+	if !m.settings.TaskReminders {
+		return nil
+	}
+	return tea.Tick(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)), func(time.Time) tea.Msg {
+		return reminderMsg{}
+	})
+}
+
+// dueReminderTask returns the first open task whose reminder is due: a
+// snoozed reminder whose time has come, or a deadline passed since launch
+func (m *Model) dueReminderTask(now time.Time) (Task, bool) {
+	for _, task := range m.tasks {
+		if task.Checked || task.Template || m.reminded[task.ID] {
+			continue
+		}
+		at, ok := m.remindAt[task.ID]
+		if !ok {
+			deadline, hasDue := m.dueDeadline(task)
+			if !hasDue || !deadline.After(m.remindSince) {
+				continue
+			}
+			at = deadline
+		}
+		if !at.After(now) {
+			return task, true
+		}
+	}
+	return Task{}, false
+}
+
+// snoozeMinutes is how long s puts off a task reminder
+func (m Model) snoozeMinutes() int {
+	if m.settings.SnoozeMinutes > 0 {
+		return m.settings.SnoozeMinutes
+	}
+	return 10
+}
+
+// answerReminder handles a key while a task reminder is showing: enter jumps
+// to the task, s snoozes it and esc dismisses it. Other keys pass through.
+func (m *Model) answerReminder(msg tea.KeyMsg) bool {
+	id := m.notifyTask
+	switch {
+	case key.Matches(msg, m.keyMap.Enter):
+		m.reminded[id] = true
+		if !m.revealTask(id) && m.errorMessage == "" {
+			m.errorMessage = "The reminded task is hidden in its list"
+		}
+	case key.Matches(msg, m.keyMap.Snooze):
+		m.remindAt[id] = time.Now().Add(time.Duration(m.snoozeMinutes()) * time.Minute)
+		m.setStatus(fmt.Sprintf("Snoozed for %d min", m.snoozeMinutes()))
+	case key.Matches(msg, m.keyMap.Back):
+		m.reminded[id] = true
+	default:
+		return false
+	}
+	m.notifyTask = 0
+	return true
+}
+
+// reminderLine is the notification shown for the task in notifyTask
+func (m Model) reminderLine() string {
+	i := m.findTaskIndex(m.notifyTask)
+	if i < 0 {
+		return ""
+	}
+	task := m.tasks[i]
+	return fmt.Sprintf("🔔 %s is due %s (enter to jump, s to snooze %dm, esc to dismiss)", truncateWidth(firstLine(task.Task), 40), m.dueLabel(task), m.snoozeMinutes())
+}
+
+// checkDayChange schedules a dayChangedMsg for the coming midnight
+func checkDayChange() tea.Cmd {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 1, 0, now.Location())
+	return tea.Tick(midnight.Sub(now), func(time.Time) tea.Msg {
+		return dayChangedMsg{}
+	})
+}
+
+// idleTimeout is how long without a key press before idle_action kicks in; 0 = never
+func (m Model) idleTimeout() time.Duration {
+	return time.Duration(m.settings.IdleMinutes) * time.Minute
+}
+
+// checkIdleIn schedules an idle check after d, unless idling is disabled
+func (m Model) checkIdleIn(d time.Duration) tea.Cmd {
+	if m.idleTimeout() <= 0 {
+		return nil
+	}
+	return tea.Tick(d, func(time.Time) tea.Msg {
+		return idleCheckMsg{}
+	})
+}
+
+// updateLocked handles keys on the lock screen. Without a passphrase any key
+// unlocks; otherwise the passphrase must be typed and confirmed with enter.
+func (m Model) updateLocked(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.settings.LockPassphrase == "" {
+		m.locked = false
+		return m, nil
+	}
+
+	if key.Matches(msg, m.keyMap.Enter) {
+		if m.lockInput.Value() == m.settings.LockPassphrase {
+			m.locked = false
+			m.errorMessage = ""
+		} else {
+			m.errorMessage = "Wrong passphrase"
+		}
+		m.lockInput.SetValue("")
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.lockInput, cmd = m.lockInput.Update(msg)
+	return m, cmd
+}
+
+// Update implements tea.Model  
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.windowWidth = msg.Width
+		m.windowHeight = msg.Height
+		m.help.Width = msg.Width
+		if m.settings.MaxWidth > 0 && m.settings.MaxWidth < msg.Width {
+			m.help.Width = m.settings.MaxWidth
+		}
+		return m, tea.ClearScreen
+
+	case configLoadedMsg:
+		loaded := msg.model
+		loaded.windowWidth, loaded.windowHeight, loaded.help.Width = m.windowWidth, m.windowHeight, m.help.Width
+		if loaded.settings.MaxWidth > 0 && loaded.settings.MaxWidth < loaded.help.Width {
+			loaded.help.Width = loaded.settings.MaxWidth
+		}
+
+		cmds := []tea.Cmd{textinput.Blink, loaded.checkIdleIn(loaded.idleTimeout()), checkDayChange(), loaded.checkContextHours(), loaded.checkReminders()}
+		if loaded.statusMessage != "" {
+			cmds = append(cmds, loaded.expireStatus())
+		}
+		if loaded.errorMessage != "" {
+			loaded.errorSetAt = time.Now()
+			cmds = append(cmds, loaded.expireError())
+		}
+		if len(loaded.pendingHooks) > 0 {
+			cmds = append(cmds, loaded.runHooks())
+			loaded.pendingHooks = nil
+		}
+		return loaded, tea.Batch(cmds...)
+
+	case spinner.TickMsg:
+		if m.loading {
+			var cmd tea.Cmd
+			m.spinner, cmd = m.spinner.Update(msg)
+			return m, cmd
+		}
+
+	case contextHoursMsg:
+		// Only follow the schedule from the plain list, not mid-dialog
+		if m.viewMode == NormalView && !m.movingMode {
+			m.followContextHours(time.Now())
+		}
+		return m, m.checkContextHours()
+
+	case reminderMsg:
+		if m.notifyTask == 0 {
+			if task, ok := m.dueReminderTask(time.Now()); ok {
+				if m.remindAt == nil {
+					m.remindAt = make(map[int]time.Time)
+					m.reminded = make(map[int]bool)
+				}
+				delete(m.remindAt, task.ID)
+				m.notifyTask = task.ID
+			}
+		}
+		return m, m.checkReminders()
+
+	case dayChangedMsg:
+		now := time.Now()
+		m.clearTodayFlags(now)
+		if n := m.generateScheduled(now); n > 0 {
+			m.setStatus(fmt.Sprintf("Added %d scheduled task(s)", n))
+			cmd := m.runHooks()
+			m.pendingHooks = nil
+			return m, tea.Batch(checkDayChange(), m.expireStatus(), cmd)
+		}
+		return m, checkDayChange()
+
+	case idleCheckMsg:
+		idle := time.Since(m.lastKeyAt)
+		if idle < m.idleTimeout() {
+			return m, m.checkIdleIn(m.idleTimeout() - idle)
+		}
+		if m.settings.IdleAction == "quit" {
+			m.saveConfig()
+			return m, tea.Quit
+		}
+		m.locked = true
+		m.lockInput.SetValue("")
+		m.lockInput.Focus()
+		return m, m.checkIdleIn(m.idleTimeout())
+
+	case tea.KeyMsg:
+		// Nothing to act on until the tasks are loaded; ctrl+c leaves without saving
+		if m.loading {
+			if msg.String() == "ctrl+c" {
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+
+		m.lastKeyAt = time.Now()
+		if m.locked {
+			return m.updateLocked(msg)
+		}
+
+		// Schedule expiry of any status message set while handling the key
+		statusID := m.statusID
+
+		// Tasks are changed in place, so the tutorial compares against a copy
+		before := m
+		if m.settings.TutorialStep > 0 {
+			before.tasks = append([]Task(nil), m.tasks...)
+		}
+
+		next, cmd := m.handleKey(msg)
+		if nm, ok := next.(Model); ok {
+			nm.advanceTutorial(&before)
+			if nm.statusID != statusID {
+				cmd = tea.Batch(cmd, nm.expireStatus())
+			}
+			if nm.errorMessage != "" {
+				nm.errorSetAt = time.Now()
+				nm.recordMessage(nm.errorMessage, true)
+				cmd = tea.Batch(cmd, nm.expireError())
+			}
+			if len(nm.pendingHooks) > 0 {
+				cmd = tea.Batch(cmd, nm.runHooks())
+				nm.pendingHooks = nil
+			}
+			next = nm
+		}
+		return next, cmd
+
+	case hookDoneMsg:
+		// A failed hook is reported, but the change it followed stands
+		if msg.err != nil {
+			m.errorMessage = fmt.Sprintf("Hook %s failed: %v", msg.event, msg.err)
+			m.errorSetAt = time.Now()
+			m.recordMessage(m.errorMessage, true)
+			return m, m.expireError()
+		}
+
+	case configEditedMsg:
+		if msg.err != nil {
+			m.errorMessage = fmt.Sprintf("Editor failed: %v", msg.err)
+		} else {
+			m.reloadConfig()
+		}
+		if m.errorMessage != "" {
+			m.errorSetAt = time.Now()
+			m.recordMessage(m.errorMessage, true)
+			return m, m.expireError()
+		}
+		return m, m.expireStatus()
+
+	case statusExpiredMsg:
+		if int(msg) == m.statusID {
+			m.statusMessage = ""
+		}
+
+	case errorExpiredMsg:
+		if m.errorSetAt.Equal(time.Time(msg)) {
+			m.errorMessage = ""
+		}
+	}
+
+	return m, nil
+}
+
+// handleKey routes a key press to the handler for the current view
+func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Clear error message on any key press
+	m.errorMessage = ""
+
+	// Quitting from a dialog only closes it, the same as esc, so nothing is
+	// saved from a half-finished edit; quitting again leaves. Typed keys
+	// such as q are text here.
+	if m.viewMode.isDialog() && msg.Type != tea.KeyRunes && key.Matches(msg, m.keyMap.Quit) {
+		next, cmd := m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+		if nm, ok := next.(Model); ok && !nm.viewMode.isDialog() {
+			nm.setStatus("Cancelled; quit again to leave")
+			return nm, cmd
+		}
+		return next, cmd
+	}
+
+	// Handle input mode
+	if m.viewMode == InputView {
+		return m.updateInputMode(msg)
+	} else if m.viewMode == DateInputView {
+		return m.updateDateInputMode(msg)
+	} else if m.viewMode == RemoveTagView {
+		return m.updateRemoveTagMode(msg)
+	} else if m.viewMode == URLPickerView {
+		return m.updateURLPickerMode(msg)
+	} else if m.viewMode == CategoryPickerView {
+		return m.updateCategoryPickerMode(msg)
+	} else if m.viewMode == ArchivePickerView {
+		return m.updateArchivePickerMode(msg)
+	} else if m.viewMode == TextAreaView {
+		return m.updateTextAreaMode(msg)
+	}
+
+	// Handle different view modes
+	switch m.viewMode {
+	case NormalView, SearchView:
+		return m.updateNormalView(msg)
+	case KanbanView:
+		return m.updateKanbanView(msg)
+	case StatsView:
+		return m.updateStatsView(msg)
+	case DetailView:
+		return m.updateDetailView(msg)
+	case KeysView:
+		return m.updateKeysView(msg)
+	}
+
+	return m, nil
+}
+
+// updateInputMode handles input dialog updates
+func (m Model) updateInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch {
+	case key.Matches(msg, m.keyMap.Back):
+		if m.captureMode {
+			m.saveConfig()
+			return m, tea.Quit
+		}
+		m.viewMode = NormalView
+		m.addTarget, m.followUp = "", false
+		if m.inputMode == SearchInput && m.searchAgain {
+			// Back to the results the dialog was opened from
+			m.viewMode = SearchView
+			m.searchAgain = false
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keyMap.MultiLine):
+		// Carry what was typed so far over to the multi-line editor
+		if !m.captureMode && (m.inputMode == AddTaskInput || m.inputMode == EditTaskInput) {
+			m.showTextArea(m.inputMode, m.inputPrompt, m.textInput.Value())
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keyMap.Expand) && m.expandSnippet(true):
+		return m, nil
+
+	case key.Matches(msg, m.keyMap.Enter):
+		input := strings.TrimSpace(m.textInput.Value())
+		m.textInput.SetValue("")
+
+		// Quick capture saves straight away and leaves, unless adding several
+		if m.captureMode && m.inputMode == AddTaskInput {
+			if input != "" {
+				added := m.addTask(input)
+				if m.captureMulti {
+					m.inputPrompt = fmt.Sprintf("Captured %q. Next task (empty to finish):", input)
+					if !added {
+						m.inputPrompt = m.errorMessage + ". Next task (empty to finish):"
+					}
+					return m, nil
+				}
+			}
+			m.saveConfig()
+			return m, tea.Quit
+		}
 		
 		switch m.inputMode {
 		case AddTaskInput:
 			if input != "" {
-				m.saveStateForUndo()
+				if !m.followUp {
+					m.saveStateForUndo()
+				}
 				m.addTask(input)
 			}
+			m.addTarget, m.followUp = "", false
 		case EditTaskInput:
 			if input != "" {
 				m.saveStateForUndo()
 				m.editCurrentTask(input)
 			}
+		case AddSubtaskInput:
+			if input != "" {
+				m.saveStateForUndo()
+				m.addSubtask(input)
+			}
+		case AddTargetInput:
+			if input != "" {
+				m.addTarget = m.contextNamed(input)
+				m.showInputDialog(AddTaskInput, fmt.Sprintf("Add new task to %s:", m.addTarget))
+				return m, nil
+			}
 		case AddContextInput:
 			if input != "" {
 				m.addContext(input)
 			}
 		case RenameContextInput:
 			if input != "" && input != m.currentContext {
-				m.renameContext(input)
+				if m.renameOrMergeContext(input) {
+					return m, nil
+				}
+			}
+		case MergeConfirmInput:
+			if strings.ToLower(input) == "y" {
+				m.saveStateForUndo()
+				m.mergeContext(m.currentContext, m.mergeTarget)
 			}
 		case AddTagInput:
 			if input != "" {
@@ -406,21 +1954,117 @@ func (m Model) updateInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		case SearchInput:
 			if input != "" {
 				m.searchTasks(input)
-			} else {
-				m.viewMode = NormalView
+			} else if !m.searchAgain {
+				m.searchForNothing()
+			}
+			if m.viewMode == InputView && m.searchAgain {
+				m.viewMode = SearchView
 			}
+			m.searchAgain = false
 		case DeleteConfirmInput:
 			if strings.ToLower(input) == "y" {
 				m.saveStateForUndo()
 				m.deleteContext()
 			}
+		case DiscardConfirmInput:
+			if strings.ToLower(input) == "y" {
+				return m, tea.Quit
+			}
+		case BatchScopeInput:
+			if n, err := m.batchScopeCount(input); err != nil {
+				m.errorMessage = err.Error()
+			} else if n == 0 {
+				m.errorMessage = fmt.Sprintf("No open tasks in %s", input)
+			} else {
+				// Ask for the date next, staying in the input view
+				m.batchScope = input
+				m.showInputDialog(BatchDueInput, fmt.Sprintf("Due date for %d task(s) in %s (YYYY-MM-DD [HH:MM] or clear):", n, input))
+				return m, nil
+			}
+		case BatchDueInput:
+			if input != "" {
+				m.saveStateForUndo()
+				m.setDueDateForScope(m.batchScope, input)
+			}
+		case ClearDueConfirmInput:
+			if strings.ToLower(input) == "y" {
+				m.saveStateForUndo()
+				m.setDueDateForCurrentTask("clear")
+			}
+		case CloneContextInput:
+			if target, shift, err := parseCloneTarget(input); err != nil {
+				m.errorMessage = err.Error()
+			} else if target = m.contextNamed(target); target == m.currentContext {
+				m.errorMessage = "Pick another context to clone into"
+			} else {
+				m.saveStateForUndo()
+				m.cloneContext(target, shift)
+			}
+		case SpawnTemplateInput:
+			if input != "" {
+				m.saveStateForUndo()
+				m.spawnTemplate(m.contextNamed(input))
+			}
+		case PromoteInput:
+			if input != "" && input != m.somedayContext() {
+				m.saveStateForUndo()
+				m.moveCurrentTaskToContext(m.contextNamed(input))
+			}
+		case EstimateInput:
+			if points, err := strconv.Atoi(input); err == nil && points >= 0 {
+				m.saveStateForUndo()
+				m.setEstimateForCurrentTask(points)
+			} else if input != "" {
+				m.errorMessage = "Estimate must be a whole number of points"
+			}
+		case PercentInput:
+			if percent, ok := parsePercent(input, m.getCurrentTask().Percent); ok {
+				m.saveStateForUndo()
+				m.setPercentForCurrentTask(percent)
+			} else {
+				m.errorMessage = "Percent must be 0-100, or +N/-N to adjust"
+			}
+		case ScheduleInput:
+			schedule := strings.ToLower(input)
+			if schedule == "none" {
+				schedule = ""
+			}
+			if schedule == "" || indexOf(schedules, strings.TrimPrefix(schedule, "after ")) >= 0 {
+				m.saveStateForUndo()
+				m.setScheduleForCurrentTask(schedule)
+			} else {
+				m.errorMessage = fmt.Sprintf("Unknown schedule %q", input)
+			}
+		case RefInput:
+			m.saveStateForUndo()
+			m.setRefForCurrentTask(input)
+		case AttachInput:
+			if input != "" {
+				m.saveStateForUndo()
+				m.attachToCurrentTask(input)
+			}
+		}
+
+		// Handlers may switch to another view (e.g. search results)
+		if m.viewMode == InputView {
+			m.viewMode = NormalView
 		}
-		
-		m.viewMode = NormalView
 		return m, nil
 	}
 
 	m.textInput, cmd = m.textInput.Update(msg)
+	if msg.Type == tea.KeyRunes {
+		m.expandSnippet(false)
+	}
+
+	// Search filters as you type; enter keeps the results, esc drops them
+	if m.inputMode == SearchInput {
+		if m.refining() {
+			m.searchResults = m.narrowTasks(m.searchMatches(), m.textInput.Value())
+		} else {
+			m.searchResults = m.matchTasks(m.textInput.Value())
+		}
+	}
 	return m, cmd
 }
 
@@ -434,23 +2078,64 @@ func (m Model) updateDateInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case key.Matches(msg, m.keyMap.Enter):
-		day := m.dateInputs[0].Value()
-		month := m.dateInputs[1].Value()
-		year := m.dateInputs[2].Value()
+		day := padDateField(m.dateInputs[0].Value())
+		month := padDateField(m.dateInputs[1].Value())
+		year := expandYear(m.dateInputs[2].Value())
 		dateStr := fmt.Sprintf("%s-%s-%s", year, month, day)
+
+		// Time is optional; leaving both fields empty keeps a date-only due date
+		hour := strings.TrimSpace(m.dateInputs[3].Value())
+		minute := strings.TrimSpace(m.dateInputs[4].Value())
+		if hour != "" || minute != "" {
+			dateStr += fmt.Sprintf(" %s:%s", padDateField(hour), padDateField(minute))
+		}
 		m.saveStateForUndo()
-		m.setDueDateForCurrentTask(dateStr)
+		if m.dateRangeStart != "" {
+			m.setDateRangeForCurrentTask(m.dateRangeStart, dateStr)
+		} else {
+			m.setDueDateForCurrentTask(dateStr)
+		}
 		m.viewMode = NormalView
 		return m, nil
 
+	case key.Matches(msg, m.keyMap.DateRange):
+		// The date entered so far becomes the start; the fields then pick the end
+		if m.dateRangeStart != "" {
+			m.dateRangeStart = ""
+		} else if start, ok := parseDueInput(fmt.Sprintf("%s-%s-%s", expandYear(m.dateInputs[2].Value()),
+			padDateField(m.dateInputs[1].Value()), padDateField(m.dateInputs[0].Value()))); ok && start != "" {
+			m.dateRangeStart = start
+		} else {
+			m.errorMessage = "Enter a valid start date first"
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keyMap.Calendar):
+		m.dateCalendar = !m.dateCalendar
+		return m, nil
+
+	case m.dateCalendar:
+		// Arrow keys move the selected day; the typed fields follow along
+		switch {
+		case key.Matches(msg, m.keyMap.Left):
+			m.shiftPickedDate(-1)
+		case key.Matches(msg, m.keyMap.Right):
+			m.shiftPickedDate(1)
+		case key.Matches(msg, m.keyMap.Up):
+			m.shiftPickedDate(-7)
+		case key.Matches(msg, m.keyMap.Down):
+			m.shiftPickedDate(7)
+		}
+		return m, nil
+
 	case key.Matches(msg, m.keyMap.Up):
 		m.dateInputs[m.dateInputIndex].Blur()
-		m.dateInputIndex = (m.dateInputIndex - 1 + 3) % 3
+		m.dateInputIndex = (m.dateInputIndex - 1 + len(m.dateInputs)) % len(m.dateInputs)
 		m.dateInputs[m.dateInputIndex].Focus()
 
 	case key.Matches(msg, m.keyMap.Down):
 		m.dateInputs[m.dateInputIndex].Blur()
-		m.dateInputIndex = (m.dateInputIndex + 1) % 3
+		m.dateInputIndex = (m.dateInputIndex + 1) % len(m.dateInputs)
 		m.dateInputs[m.dateInputIndex].Focus()
 	}
 
@@ -458,7 +2143,8 @@ func (m Model) updateDateInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-// updateRemoveTagMode handles remove tag view updates
+// updateRemoveTagMode handles remove tag view updates: tags are checked
+// for removal and moved up and down, all applied together on enter
 func (m Model) updateRemoveTagMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch {
 	case key.Matches(msg, m.keyMap.Back):
@@ -471,977 +2157,7944 @@ func (m Model) updateRemoveTagMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.viewMode = NormalView
 		return m, nil
 
+	case key.Matches(msg, m.keyMap.TagUp):
+		if i := m.removeTagIndex; i > 0 {
+			m.tagOrder[i-1], m.tagOrder[i] = m.tagOrder[i], m.tagOrder[i-1]
+			m.removeTagChecks[i-1], m.removeTagChecks[i] = m.removeTagChecks[i], m.removeTagChecks[i-1]
+			m.removeTagIndex--
+		}
+
+	case key.Matches(msg, m.keyMap.TagDown):
+		if i := m.removeTagIndex; i < len(m.tagOrder)-1 {
+			m.tagOrder[i+1], m.tagOrder[i] = m.tagOrder[i], m.tagOrder[i+1]
+			m.removeTagChecks[i+1], m.removeTagChecks[i] = m.removeTagChecks[i], m.removeTagChecks[i+1]
+			m.removeTagIndex++
+		}
+
 	case key.Matches(msg, m.keyMap.Up):
 		if m.removeTagIndex > 0 {
 			m.removeTagIndex--
 		}
 
 	case key.Matches(msg, m.keyMap.Down):
-		task := m.getCurrentTask()
-		if m.removeTagIndex < len(task.Tags)-1 {
+		if m.removeTagIndex < len(m.tagOrder)-1 {
 			m.removeTagIndex++
 		}
 
 	case key.Matches(msg, m.keyMap.Toggle):
 		m.removeTagChecks[m.removeTagIndex] = !m.removeTagChecks[m.removeTagIndex]
+
+	case key.Matches(msg, m.keyMap.SelectAll):
+		// Check everything, or clear everything once all are checked
+		all := true
+		for _, checked := range m.removeTagChecks {
+			all = all && checked
+		}
+		for i := range m.removeTagChecks {
+			m.removeTagChecks[i] = !all
+		}
+
+	default:
+		// 1-9 jump to the numbered tag
+		if n, err := strconv.Atoi(msg.String()); err == nil && n >= 1 && n <= len(m.removeTagChecks) {
+			m.removeTagIndex = n - 1
+		}
 	}
 
 	return m, nil
 }
 
-// updateNormalView handles normal view updates
-func (m Model) updateNormalView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+// updateURLPickerMode handles the link picker shown for tasks with several URLs
+func (m Model) updateURLPickerMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch {
-	case key.Matches(msg, m.keyMap.Quit):
-		m.saveConfig()
-		return m, tea.Quit
-
 	case key.Matches(msg, m.keyMap.Back):
-		if m.viewMode == SearchView {
-			m.exitSearchMode()
-		}
-		return m, nil
+		m.viewMode = NormalView
+
+	case key.Matches(msg, m.keyMap.Enter):
+		m.openURL(m.urlChoices[m.urlIndex])
+		m.viewMode = NormalView
 
 	case key.Matches(msg, m.keyMap.Up):
-		if m.movingMode {
-			m.moveTaskUp()
-		} else {
-			m.moveUp()
+		if m.urlIndex > 0 {
+			m.urlIndex--
 		}
 
 	case key.Matches(msg, m.keyMap.Down):
-		if m.movingMode {
-			m.moveTaskDown()
-		} else {
-			m.moveDown()
+		if m.urlIndex < len(m.urlChoices)-1 {
+			m.urlIndex++
 		}
+	}
 
-	case key.Matches(msg, m.keyMap.Left):
-		m.previousContext()
-
-	case key.Matches(msg, m.keyMap.Right):
-		m.nextContext()
+	return m, nil
+}
 
-	case key.Matches(msg, m.keyMap.Toggle):
-		if len(m.getFilteredTasks()) > 0 {
-			m.saveStateForUndo()
-			m.toggleCurrentTask()
-		}
+// updateTextAreaMode handles the multi-line editor, where enter inserts a
+// newline and ctrl+s saves
+func (m Model) updateTextAreaMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
 
-	case key.Matches(msg, m.keyMap.Add):
-		m.showInputDialog(AddTaskInput, "Add new task:")
+	switch {
+	case key.Matches(msg, m.keyMap.Back):
+		m.textArea.Blur()
+		m.viewMode = NormalView
+		m.addTarget, m.followUp = "", false
+		return m, nil
 
-	case key.Matches(msg, m.keyMap.Edit):
-		if len(m.getFilteredTasks()) > 0 {
-			task := m.getCurrentTask()
-			m.showInputDialog(EditTaskInput, "Edit task:")
-			m.textInput.SetValue(task.Task)
-		}
+	case key.Matches(msg, m.keyMap.Commit):
+		text := strings.TrimSpace(m.textArea.Value())
+		m.textArea.Blur()
+		m.viewMode = NormalView
 
-	case key.Matches(msg, m.keyMap.Delete):
-		if len(m.getFilteredTasks()) > 0 {
+		switch m.inputMode {
+		case AddTaskInput:
+			if text != "" {
+				if !m.followUp {
+					m.saveStateForUndo()
+				}
+				m.addTask(text)
+			}
+			m.addTarget, m.followUp = "", false
+		case EditTaskInput:
+			if text != "" {
+				m.saveStateForUndo()
+				m.editCurrentTask(text)
+			}
+		case NotesInput:
 			m.saveStateForUndo()
-			m.deleteCurrentTask()
+			m.setNotesForCurrentTask(text)
+		case ScratchpadInput:
+			m.saveStateForUndo()
+			m.setScratchpad(m.currentContext, text)
 		}
+		return m, nil
+	}
 
-	case key.Matches(msg, m.keyMap.AddContext):
-		m.showInputDialog(AddContextInput, "New context name:")
-
-	case key.Matches(msg, m.keyMap.RenameContext):
-		m.showInputDialog(RenameContextInput, "Rename context to:")
-		m.textInput.SetValue(m.currentContext)
-
-	case key.Matches(msg, m.keyMap.DeleteContext):
-		if len(m.contexts) > 1 {
-			m.showInputDialog(DeleteConfirmInput, fmt.Sprintf("Delete context '%s'? (y/n):", m.currentContext))
-		} else {
-			m.errorMessage = "Cannot delete the only context"
-		}
+	m.textArea, cmd = m.textArea.Update(msg)
+	return m, cmd
+}
 
-	case key.Matches(msg, m.keyMap.TogglePriority):
-		if len(m.getFilteredTasks()) > 0 {
-			m.saveStateForUndo()
-			m.toggleCurrentTaskPriority()
-		}
+// updateArchivePickerMode handles choosing an archived context to restore
+func (m Model) updateArchivePickerMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keyMap.Back):
+		m.viewMode = NormalView
 
-	case key.Matches(msg, m.keyMap.AddTag):
-		if len(m.getFilteredTasks()) > 0 {
-			m.showInputDialog(AddTagInput, "Add tag:")
-		}
+	case key.Matches(msg, m.keyMap.Enter):
+		m.restoreContext(m.settings.Archived[m.archiveIndex])
+		m.viewMode = NormalView
 
-	case key.Matches(msg, m.keyMap.RemoveTag):
-		if len(m.getFilteredTasks()) > 0 {
-			m.showRemoveTagDialog()
+	case key.Matches(msg, m.keyMap.Up):
+		if m.archiveIndex > 0 {
+			m.archiveIndex--
 		}
 
-	case key.Matches(msg, m.keyMap.SetDueDate):
-		if len(m.getFilteredTasks()) > 0 {
-			m.showDateInputDialog()
+	case key.Matches(msg, m.keyMap.Down):
+		if m.archiveIndex < len(m.settings.Archived)-1 {
+			m.archiveIndex++
 		}
+	}
 
-	case key.Matches(msg, m.keyMap.ClearDueDate):
-		if len(m.getFilteredTasks()) > 0 {
-			m.saveStateForUndo()
-			m.setDueDateForCurrentTask("clear")
-		}
+	return m, nil
+}
 
-	case key.Matches(msg, m.keyMap.Search):
-		m.showInputDialog(SearchInput, "Search tasks:")
+// updateCategoryPickerMode handles choosing a category for the selected task
+func (m Model) updateCategoryPickerMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	choices := m.categoryNames()
 
-	case key.Matches(msg, m.keyMap.KanbanView):
-		m.viewMode = KanbanView
+	switch {
+	case key.Matches(msg, m.keyMap.Back):
+		m.viewMode = NormalView
 
-	case key.Matches(msg, m.keyMap.StatsView):
-		m.viewMode = StatsView
+	case key.Matches(msg, m.keyMap.Enter):
+		m.saveStateForUndo()
+		category := ""
+		if m.categoryIndex > 0 {
+			category = choices[m.categoryIndex-1]
+		}
+		m.setCategoryForCurrentTask(category)
+		m.viewMode = NormalView
 
-	case key.Matches(msg, m.keyMap.Undo):
-		m.undo()
+	case key.Matches(msg, m.keyMap.Up):
+		if m.categoryIndex > 0 {
+			m.categoryIndex--
+		}
 
-	case key.Matches(msg, m.keyMap.Move):
-		if len(m.getFilteredTasks()) > 0 {
-			m.movingMode = !m.movingMode
-			if m.movingMode {
-				m.movingTaskIndex = m.selectedIndex
-			} else {
-				m.saveStateForUndo()
-			}
+	case key.Matches(msg, m.keyMap.Down):
+		if m.categoryIndex < len(choices) {
+			m.categoryIndex++
 		}
 	}
 
 	return m, nil
 }
 
-// updateKanbanView handles kanban view updates
-func (m Model) updateKanbanView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch {
-	case key.Matches(msg, m.keyMap.Back), key.Matches(msg, m.keyMap.Quit), key.Matches(msg, m.keyMap.KanbanView):
-		m.viewMode = NormalView
+// updateNormalView handles normal view updates
+func (m Model) updateNormalView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Enter stands in for the key of the configured enter_action
+	enter := key.Matches(msg, m.keyMap.Enter)
+	enterAction := m.settings.EnterAction
+	if enterAction == "" {
+		enterAction = "details"
 	}
-	return m, nil
-}
 
-// updateStatsView handles stats view updates  
-func (m Model) updateStatsView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch {
-	case key.Matches(msg, m.keyMap.Back), key.Matches(msg, m.keyMap.Quit), key.Matches(msg, m.keyMap.StatsView):
-		m.viewMode = NormalView
+	// A task reminder takes its keys ahead of the launch banners
+	if m.notifyTask != 0 && m.viewMode == NormalView {
+		if m.findTaskIndex(m.notifyTask) < 0 {
+			m.notifyTask = 0
+		} else if m.answerReminder(msg) {
+			if m.statusMessage != "" {
+				return m, m.expireStatus()
+			}
+			return m, nil
+		}
 	}
-	return m, nil
-}
 
-// View implements tea.Model
-func (m Model) View() string {
-	switch m.viewMode {
-	case InputView:
-		return m.renderInputView()
-	case DateInputView:
-		return m.renderDateInputView()
-	case RemoveTagView:
-		return m.renderRemoveTagView()
-	case KanbanView:
-		return m.renderKanbanView()
-	case StatsView:
-		return m.renderStatsView()
-	default:
-		return m.renderNormalView()
+	// The launch reminder takes enter and esc until dismissed
+	if m.dueReminder != "" && m.viewMode == NormalView && (enter || key.Matches(msg, m.keyMap.Back)) {
+		m.dueReminder = ""
+		if enter {
+			m.searchTasks("due:now")
+		}
+		return m, nil
 	}
-}
 
-// renderNormalView renders the main task list view
-func (m Model) renderNormalView() string {
-	var content strings.Builder
+	// The review banner, shown once the due reminder is out of the way, counts
+	// enter as reviewing and lists what has gone stale
+	if m.reviewReminder != "" && m.viewMode == NormalView && (enter || key.Matches(msg, m.keyMap.Back)) {
+		m.reviewReminder = ""
+		if enter {
+			m.markReviewed()
+			if m.settings.StaleDays > 0 {
+				m.searchTasks("is:stale")
+			}
+		}
+		return m, nil
+	}
 
-	// Header
-	contextText := fmt.Sprintf("Context: %s", m.currentContext)
-	if m.viewMode == SearchView {
-		contextText = "Search Results (ESC to exit)"
+	if m.focusMode && key.Matches(msg, m.keyMap.Back) {
+		m.focusMode = false
+		return m, nil
 	}
-	content.WriteString(titleStyle.Render(contextText) + "\n\n")
 
-	// Tasks
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		if m.viewMode == SearchView {
-			content.WriteString("No matching tasks found.\n")
-		} else if len(m.contexts) == 0 {
-			content.WriteString("No contexts exist. Press 'n' to create one.\n")
-		} else {
-			content.WriteString("No tasks in this context. Press 'a' to add one.\n")
+	// Focus mode is for working through tasks one by one, so it only moves
+	// between them, toggles, and leaves
+	if m.focusMode {
+		switch {
+		case key.Matches(msg, m.keyMap.Up), key.Matches(msg, m.keyMap.Down),
+			key.Matches(msg, m.keyMap.Toggle), enter && enterAction == "toggle",
+			key.Matches(msg, m.keyMap.Focus), key.Matches(msg, m.keyMap.Quit):
+		default:
+			m.errorMessage = fmt.Sprintf("Focus mode: ↑/↓ to move, %s to toggle, esc to leave", m.keyMap.Toggle.Help().Key)
+			return m, nil
 		}
-	} else {
-		for i, task := range tasks {
-			taskLine := m.renderTask(task, i == m.selectedIndex, i == m.movingTaskIndex && m.movingMode)
-			content.WriteString(taskLine + "\n")
+	}
+
+	// After x, a digit moves the task to that numbered context; any other key cancels
+	if m.filing {
+		m.filing = false
+		contexts := m.fileToContexts()
+		n, err := strconv.Atoi(msg.String())
+		switch {
+		case err != nil || n < 1 || n > len(contexts):
+			m.setStatus("Move cancelled")
+		case contexts[n-1] == m.getCurrentTask().Context:
+			m.setStatus(fmt.Sprintf("Already in '%s'", contexts[n-1]))
+		default:
+			m.saveStateForUndo()
+			m.moveCurrentTaskToContext(contexts[n-1])
 		}
+		return m, nil
 	}
 
-	// Error message
-	if m.errorMessage != "" {
-		content.WriteString("\n" + errorStyle.Render(m.errorMessage) + "\n")
+	// After ; or ', a letter sets or jumps to that mark; any other key cancels
+	if m.marking != "" {
+		marking := m.marking
+		m.marking = ""
+		letter := msg.String()
+		switch {
+		case len(letter) != 1 || !unicode.IsLetter(rune(letter[0])):
+			m.setStatus("Mark cancelled")
+		case marking == "set":
+			m.setMark(letter)
+		default:
+			m.jumpToMark(letter)
+		}
+		return m, nil
 	}
 
-	// Help
-	m.help.ShowAll = true
-	content.WriteString("\n" + helpStyle.Render(m.help.View(m.keyMap)))
+	// The tutorial takes esc to skip it, and enter on its last step
+	if m.settings.TutorialStep > 0 && m.viewMode == NormalView {
+		last := m.settings.TutorialStep >= len(tutorialSteps)
+		if key.Matches(msg, m.keyMap.Back) || (enter && last) {
+			m.finishTutorial(last)
+			return m, nil
+		}
+	}
 
-	return baseStyle.Render(content.String())
-}
+	switch {
+	case key.Matches(msg, m.keyMap.Quit) && m.movingMode:
+		// Leave move mode first, so the list is saved as it was
+		m.movingMode = false
+		m.selectedIndex = m.movingTaskIndex
+		m.setStatus("Move cancelled; quit again to leave")
 
-// renderTask renders a single task
-func (m Model) renderTask(task Task, selected, moving bool) string {
-	// Checkbox
-	checkbox := "[ ]"
-	if task.Checked {
-		checkbox = "[✓]"
-	}
+	case key.Matches(msg, m.keyMap.Quit):
+		m.saveConfig()
+		return m, tea.Quit
 
-	// Priority indicator
-	priority := ""
-	switch task.Priority {
-	case "high":
-		priority = highPriorityStyle.Render("!!! ")
-	case "medium":
-		priority = mediumPriorityStyle.Render("!! ")
-	case "low":
-		priority = lowPriorityStyle.Render("! ")
-	}
+	case key.Matches(msg, m.keyMap.QuitNoSave):
+		if !m.dirty {
+			return m, tea.Quit
+		}
+		m.showInputDialog(DiscardConfirmInput, "Quit without saving? Unsaved changes will be lost (y/n):")
 
-	// Task text
-	taskText := task.Task
+	case key.Matches(msg, m.keyMap.Back):
+		if m.movingMode {
+			// Cancel the move, leaving the task where it was
+			m.movingMode = false
+			m.selectedIndex = m.movingTaskIndex
+		} else if m.viewMode == SearchView {
+			m.exitSearchMode()
+		}
+		return m, nil
 
-	// Tags
-	tags := ""
-	if len(task.Tags) > 0 {
-		tags = " > " + strings.Join(task.Tags, ", ")
-	}
+	case key.Matches(msg, m.keyMap.Up):
+		m.moveUp()
 
-	// Due date
-	dueDate := ""
-	if task.DueDate != "" {
-		dueDate = fmt.Sprintf(" [Due: %s]", task.DueDate)
-	}
+	case key.Matches(msg, m.keyMap.Down):
+		m.moveDown()
 
-	// Combine text
-	text := fmt.Sprintf("%s %s%s%s", checkbox, taskText, tags, dueDate)
+	case key.Matches(msg, m.keyMap.Left):
+		m.previousContext()
 
-	// Apply styles
-	style := taskStyle
-	if task.Checked {
-		style = completedTaskStyle
-	}
+	case key.Matches(msg, m.keyMap.Right):
+		m.nextContext()
 
-	if selected {
-		style = style.Copy().Background(lipgloss.Color("#313244"))
-	}
+	case key.Matches(msg, m.keyMap.LastContext):
+		m.switchToLastContext()
 
-	if moving {
-		style = style.Copy().Bold(true)
-	}
+	case key.Matches(msg, m.keyMap.Toggle), enter && enterAction == "toggle":
+		if m.requireTask() {
+			id := m.getCurrentTask().ID
+			completed := m.toggleUndoable(m.toggleView(), id)
+			if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining && remaining > 0 {
+				m.selectedIndex = remaining - 1
+			}
+			if completed {
+				m.setStatus("Task completed ✓")
+				// Focus moves on to the next task unless the done one already left the list
+				if tasks := m.getFilteredTasks(); m.focusMode && m.selectedIndex < len(tasks)-1 && tasks[m.selectedIndex].ID == id {
+					m.selectedIndex++
+				}
+				if m.settings.CompleteBell {
+					return m, ringBell
+				}
+			}
+		}
 
-	return priority + style.Render(text)
-}
+	case key.Matches(msg, m.keyMap.FollowUp):
+		if m.requireTask() {
+			task := m.getCurrentTask()
+			if task.Checked {
+				m.errorMessage = "Task is already done"
+				return m, nil
+			}
+			completed := m.toggleUndoable(m.toggleView(), task.ID)
+			if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining && remaining > 0 {
+				m.selectedIndex = remaining - 1
+			}
+			if completed {
+				m.startFollowUp(task)
+				if m.settings.CompleteBell {
+					return m, ringBell
+				}
+			}
+		}
 
-// renderInputView renders input dialogs
-func (m Model) renderInputView() string {
-	return inputStyle.Render(
-		fmt.Sprintf("%s\n\n%s", m.inputPrompt, m.textInput.View()),
-	)
-}
+	case key.Matches(msg, m.keyMap.ToggleAll):
+		if len(m.getTasksUnderContext(m.currentContext)) > 0 {
+			dirty := m.dirty
+			m.saveStateForUndo()
+			completed, count := m.toggleAllInContext()
+			if count == 0 {
+				m.dropUndoState(dirty)
+			} else if completed && m.settings.CompleteBell {
+				return m, ringBell
+			}
+		}
 
-// renderDateInputView renders due date input dialog
-func (m Model) renderDateInputView() string {
-	var content strings.Builder
-	content.WriteString("Set due date (YYYY-MM-DD):\n\n")
-	inputs := []string{
-		fmt.Sprintf("Day: %s", m.dateInputs[0].View()),
-		fmt.Sprintf("Month: %s", m.dateInputs[1].View()),
-		fmt.Sprintf("Year: %s", m.dateInputs[2].View()),
-	}
-	for i, input := range inputs {
-		if i == m.dateInputIndex {
-			content.WriteString(selectedTaskStyle.Render(input) + "\n")
-		} else {
-			content.WriteString(input + "\n")
+	case key.Matches(msg, m.keyMap.Add):
+		m.startAdd()
+
+	case key.Matches(msg, m.keyMap.AddSubtask):
+		if m.requireTask() {
+			m.showInputDialog(AddSubtaskInput, fmt.Sprintf("Add subtask to %q:", firstLine(m.parentOf(m.getCurrentTask()).Task)))
 		}
-	}
-	return inputStyle.Render(content.String())
-}
 
-// renderRemoveTagView renders remove tag view
-func (m Model) renderRemoveTagView() string {
-	var content strings.Builder
-	content.WriteString("Select tags to remove:\n\n")
-	task := m.getCurrentTask()
-	for i, tag := range task.Tags {
-		checkbox := "[ ]"
-		if m.removeTagChecks[i] {
-			checkbox = "[✓]"
+	case key.Matches(msg, m.keyMap.Fold):
+		if m.requireTask() {
+			m.toggleFold()
 		}
-		line := fmt.Sprintf("%s %s", checkbox, tag)
-		if i == m.removeTagIndex {
-			content.WriteString(selectedTaskStyle.Render(line) + "\n")
-		} else {
-			content.WriteString(line + "\n")
+
+	case key.Matches(msg, m.keyMap.FoldCompleted):
+		if m.settings.OnComplete != "collapse" {
+			m.errorMessage = "Completed tasks only fold with on_complete set to collapse"
+			break
+		}
+		m.showCompleted = !m.showCompleted
+		if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining {
+			m.selectedIndex = max(remaining-1, 0)
+		}
+
+	case key.Matches(msg, m.keyMap.Edit), enter && enterAction == "edit":
+		if m.requireTask() {
+			task := m.getCurrentTask()
+			if strings.Contains(task.Task, "\n") {
+				m.showTextArea(EditTaskInput, "Edit task:", task.Task)
+			} else {
+				m.showInputDialog(EditTaskInput, "Edit task:")
+				m.textInput.SetValue(task.Task)
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.Notes):
+		if m.requireTask() {
+			m.showTextArea(NotesInput, "Notes:", m.getCurrentTask().Notes)
+		}
+
+	case key.Matches(msg, m.keyMap.Scratchpad):
+		m.showTextArea(ScratchpadInput, fmt.Sprintf("Scratchpad for %s:", m.currentContext), m.settings.Scratchpads[m.currentContext])
+
+	case key.Matches(msg, m.keyMap.FoldScratchpad):
+		if m.settings.Scratchpads[m.currentContext] == "" {
+			m.errorMessage = "No scratchpad here; press = to write one"
+		} else if i := indexOf(m.settings.FoldedScratchpads, m.currentContext); i >= 0 {
+			m.saveStateForUndo()
+			m.settings.FoldedScratchpads = append(m.settings.FoldedScratchpads[:i], m.settings.FoldedScratchpads[i+1:]...)
+		} else {
+			m.saveStateForUndo()
+			m.settings.FoldedScratchpads = append(m.settings.FoldedScratchpads, m.currentContext)
+		}
+
+	case key.Matches(msg, m.keyMap.Delete):
+		if m.requireTask() {
+			m.saveStateForUndo()
+			m.deleteCurrentTask()
+		}
+
+	case key.Matches(msg, m.keyMap.AddContext):
+		m.showInputDialog(AddContextInput, "New context name:")
+
+	case key.Matches(msg, m.keyMap.RenameContext):
+		m.showInputDialog(RenameContextInput, "Rename context to:")
+		m.textInput.SetValue(m.currentContext)
+
+	case key.Matches(msg, m.keyMap.DeleteContext):
+		subcontexts := len(m.subcontextsOf(m.currentContext))
+		switch {
+		case len(m.contexts) <= 1+subcontexts:
+			m.errorMessage = "Cannot delete the only context"
+		case subcontexts > 0:
+			m.showInputDialog(DeleteConfirmInput, fmt.Sprintf("Delete context '%s' and its %d subcontext(s)? (y/n):", m.currentContext, subcontexts))
+		default:
+			m.showInputDialog(DeleteConfirmInput, fmt.Sprintf("Delete context '%s'? (y/n):", m.currentContext))
+		}
+
+	case key.Matches(msg, m.keyMap.CopyBranch):
+		if m.requireTask() {
+			branch := slugify(firstLine(m.getCurrentTask().Task))
+			if err := m.copyToClipboard(branch); err != nil {
+				m.errorMessage = fmt.Sprintf("Could not copy: %v", err)
+			} else {
+				m.setStatus("Copied " + branch)
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.SetRef):
+		if m.requireTask() {
+			m.showInputDialog(RefInput, "Git branch or commit (empty to unlink):")
+			m.textInput.SetValue(m.getCurrentTask().Ref)
+		}
+
+	case key.Matches(msg, m.keyMap.Copy):
+		if m.requireTask() {
+			if err := m.copyToClipboard(m.getCurrentTask().Task); err != nil {
+				m.errorMessage = fmt.Sprintf("Could not copy: %v", err)
+			} else {
+				m.setStatus("Copied")
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.Paste):
+		text, err := m.readClipboard()
+		if err != nil {
+			m.errorMessage = fmt.Sprintf("Could not paste: %v", err)
+			break
+		}
+
+		var lines []string
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			m.setStatus("Clipboard is empty")
+			break
+		}
+
+		m.saveStateForUndo()
+		pasted := 0
+		for _, line := range lines {
+			if m.addTask(line) {
+				pasted++
+			}
+		}
+		m.setStatus(fmt.Sprintf("Pasted %d task(s)", pasted))
+
+	case key.Matches(msg, m.keyMap.DueFilter):
+		m.dueOnly = !m.dueOnly
+		m.selectedIndex = 0
+
+	case key.Matches(msg, m.keyMap.ExtrasFilter):
+		m.extrasOnly = !m.extrasOnly
+		m.selectedIndex = 0
+
+	case key.Matches(msg, m.keyMap.NextDue):
+		m.jumpToNextDue()
+
+	case key.Matches(msg, m.keyMap.NextTagged):
+		if m.requireTask() {
+			m.jumpToNextTagged()
+		}
+
+	case key.Matches(msg, m.keyMap.MarkReviewed):
+		m.markReviewed()
+
+	case key.Matches(msg, m.keyMap.ShowIDs):
+		m.settings.ShowIDs = !m.settings.ShowIDs
+		m.dirty = true
+
+	case key.Matches(msg, m.keyMap.GroupPriority):
+		// Keep the same task selected as it moves into its group
+		task, ok := m.currentTask()
+		m.settings.GroupByPriority = !m.settings.GroupByPriority
+		m.dirty = true
+		if ok {
+			m.selectTask(task.ID)
+		}
+
+	case key.Matches(msg, m.keyMap.SortDirection):
+		view := "normal"
+		if m.viewMode == SearchView {
+			view = "search"
+		}
+		if len(m.viewSortKeys(view)) == 0 {
+			m.errorMessage = "Manual order; set sort_keys or view_sort to sort"
+		} else {
+			task, ok := m.currentTask()
+			m.sortDescending = !m.sortDescending
+			if ok {
+				m.selectTask(task.ID)
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.RelativeDates):
+		m.settings.RelativeDue = !m.settings.RelativeDue
+		m.dirty = true
+
+	case key.Matches(msg, m.keyMap.SetCategory):
+		if m.requireTask() {
+			m.showCategoryPicker()
+		}
+
+	case key.Matches(msg, m.keyMap.SetPercent):
+		if m.requireTask() {
+			m.showInputDialog(PercentInput, "Percent done (0-100, +10/-10 to adjust, empty to clear):")
+			if percent := m.getCurrentTask().Percent; percent > 0 {
+				m.textInput.SetValue(strconv.Itoa(percent))
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.SetEstimate):
+		if m.requireTask() {
+			m.showInputDialog(EstimateInput, "Estimate in points (0 to clear):")
+			if points := m.getCurrentTask().Estimate; points > 0 {
+				m.textInput.SetValue(strconv.Itoa(points))
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.SetSchedule):
+		if m.requireTask() {
+			m.showInputDialog(ScheduleInput, "Repeat daily, weekdays, weekly or monthly, or e.g. 'after weekly' once done (none to stop):")
+			m.textInput.SetValue(m.getCurrentTask().Schedule)
+		}
+
+	case key.Matches(msg, m.keyMap.CategoryFilter):
+		m.cycleCategoryFilter()
+
+	case key.Matches(msg, m.keyMap.Archive):
+		m.archiveContext()
+
+	case key.Matches(msg, m.keyMap.Restore):
+		if len(m.settings.Archived) == 0 {
+			m.errorMessage = "No archived contexts"
+		} else {
+			m.viewMode = ArchivePickerView
+			m.archiveIndex = 0
+		}
+
+	case key.Matches(msg, m.keyMap.Recent):
+		m.showRecent()
+
+	case key.Matches(msg, m.keyMap.FlagToday):
+		if m.requireTask() {
+			m.saveStateForUndo()
+			m.toggleTodayFlag()
+		}
+
+	case key.Matches(msg, m.keyMap.TodayView):
+		m.showToday()
+
+	case key.Matches(msg, m.keyMap.LockContext):
+		m.contextLocked = !m.contextLocked
+		if m.contextLocked {
+			m.setStatus(fmt.Sprintf("Locked to '%s'", m.currentContext))
+		} else {
+			m.setStatus("Context unlocked")
+		}
+
+	case key.Matches(msg, m.keyMap.ExportContext):
+		path := filepath.Join(m.configPath, exportFileName(m.currentContext, "md"))
+		if count, err := m.exportTasks(path, m.getTasksForContext(m.currentContext)); err != nil {
+			m.errorMessage = fmt.Sprintf("Export failed: %v", err)
+		} else {
+			m.setStatus(fmt.Sprintf("Exported %d task(s) to %s", count, path))
+		}
+
+	case key.Matches(msg, m.keyMap.FoldContext):
+		m.foldContext()
+
+	case key.Matches(msg, m.keyMap.Someday):
+		m.toggleSideList(m.somedayContext())
+
+	case key.Matches(msg, m.keyMap.DoneList):
+		m.toggleSideList(m.doneContext())
+
+	case key.Matches(msg, m.keyMap.SweepDone):
+		m.sweepCompleted()
+
+	case key.Matches(msg, m.keyMap.FileTo):
+		if m.requireTask() {
+			m.filing = true
+		}
+
+	case key.Matches(msg, m.keyMap.SetMark):
+		if m.requireTask() {
+			m.marking = "set"
+		}
+
+	case key.Matches(msg, m.keyMap.JumpMark):
+		m.pruneMarks()
+		if len(m.settings.Marks) == 0 {
+			m.errorMessage = "No marks set; press ; and a letter to mark a task"
+		} else {
+			m.marking = "jump"
+		}
+
+	case key.Matches(msg, m.keyMap.Park):
+		if !m.requireTask() {
+			break
+		}
+		if m.currentContext == m.somedayContext() {
+			m.showInputDialog(PromoteInput, "Promote task to context:")
+		} else {
+			m.saveStateForUndo()
+			m.moveCurrentTaskToContext(m.somedayContext())
+		}
+
+	case key.Matches(msg, m.keyMap.MarkTemplate):
+		m.toggleTemplateContext()
+
+	case key.Matches(msg, m.keyMap.TaskTemplate):
+		if m.requireTask() {
+			m.saveStateForUndo()
+			m.toggleTemplateTask()
+		}
+
+	case key.Matches(msg, m.keyMap.SpawnTemplate):
+		// A template task is copied in place; a template context needs a target
+		if task, ok := m.currentTask(); ok && task.Template && !m.isTemplateContext(m.currentContext) {
+			m.saveStateForUndo()
+			m.instantiateTemplate(task)
+		} else if !m.isTemplateContext(m.currentContext) {
+			m.errorMessage = "Current context is not a template"
+		} else if len(m.getTasksForContext(m.currentContext)) == 0 {
+			m.errorMessage = "Template has no tasks"
+		} else {
+			m.showInputDialog(SpawnTemplateInput, fmt.Sprintf("Copy template '%s' into context:", m.currentContext))
+		}
+
+	case key.Matches(msg, m.keyMap.CloneContext):
+		if len(m.getTasksForContext(m.currentContext)) == 0 {
+			m.errorMessage = "No tasks to clone"
+		} else {
+			m.showInputDialog(CloneContextInput, fmt.Sprintf("Clone '%s' into context (add +N to move dates N days):", m.currentContext))
+		}
+
+	case key.Matches(msg, m.keyMap.TogglePriority):
+		if m.requireTask() {
+			m.saveStateForUndo()
+			m.toggleCurrentTaskPriority(1)
+		}
+
+	case key.Matches(msg, m.keyMap.LowerPriority):
+		if m.requireTask() {
+			m.saveStateForUndo()
+			m.toggleCurrentTaskPriority(-1)
+		}
+
+	// Number keys jump between items only inside dialogs (remove tag), so
+	// in the list they are free to set the priority directly
+	case key.Matches(msg, m.keyMap.SetPriority):
+		if m.requireTask() {
+			m.saveStateForUndo()
+			m.setCurrentTaskPriority(quickPriorities[msg.String()])
+		}
+
+	case key.Matches(msg, m.keyMap.AddTag):
+		if m.requireTask() {
+			m.showInputDialog(AddTagInput, "Add tag (key:value allowed, tab completes):")
+			m.textInput.ShowSuggestions = true
+			m.textInput.SetSuggestions(m.knownTags())
+		}
+
+	case key.Matches(msg, m.keyMap.RemoveTag):
+		if m.requireTask() {
+			m.showRemoveTagDialog()
+		}
+
+	case key.Matches(msg, m.keyMap.SetDueDate):
+		if m.requireTask() {
+			m.showDateInputDialog()
+		}
+
+	case key.Matches(msg, m.keyMap.ClearDueDate):
+		if m.requireTask() {
+			if due := m.getCurrentTask().DueDate; due != "" && m.settings.ConfirmClearDue {
+				m.showInputDialog(ClearDueConfirmInput, fmt.Sprintf("Clear due date %s? (y/n):", due))
+			} else {
+				m.saveStateForUndo()
+				m.setDueDateForCurrentTask("clear")
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.BatchDueDate):
+		m.showInputDialog(BatchScopeInput, "Set a due date for tag:NAME or context:NAME:")
+		m.textInput.SetValue("context:" + m.currentContext)
+
+	case key.Matches(msg, m.keyMap.Search):
+		m.searchAgain = m.viewMode == SearchView
+		if m.refining() {
+			m.showInputDialog(SearchInput, "Refine results (text, or tag: due: priority: context: category: is:):")
+		} else {
+			m.showInputDialog(SearchInput, "Search tasks (text, or tag: due: priority: context: category: is:):")
+		}
+
+	case key.Matches(msg, m.keyMap.KanbanView):
+		m.viewMode = KanbanView
+		// The board opens on the column of the context being viewed
+		m.kanbanColumn, m.kanbanRow = 0, 0
+		columns, groups := m.kanbanColumns()
+		for i, context := range columns {
+			if context == m.currentContext || indexOf(groups[context], m.currentContext) >= 0 {
+				m.kanbanColumn = i
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.Keys):
+		m.keysQuery, m.keysOffset = "", 0
+		m.viewMode = KeysView
+
+	case key.Matches(msg, m.keyMap.Messages):
+		m.showMessages = !m.showMessages
+
+	case key.Matches(msg, m.keyMap.EditConfig):
+		return m, m.editConfig()
+
+	case key.Matches(msg, m.keyMap.Focus):
+		m.focusMode = !m.focusMode
+
+	case key.Matches(msg, m.keyMap.StatsView):
+		m.viewMode = StatsView
+
+	case key.Matches(msg, m.keyMap.Undo):
+		m.undo()
+
+	case key.Matches(msg, m.keyMap.OpenURL):
+		if m.requireTask() {
+			m.openCurrentTaskURL()
+		}
+
+	case key.Matches(msg, m.keyMap.Attach):
+		if m.requireTask() {
+			m.showInputDialog(AttachInput, "Attach file path:")
+		}
+
+	case key.Matches(msg, m.keyMap.Details), enter && enterAction == "details":
+		if m.requireTask() {
+			m.detailReturn = m.viewMode
+			m.detailTask = m.getCurrentTask().ID
+			m.viewMode = DetailView
+			m.detailOffset = 0
+		}
+
+	case key.Matches(msg, m.keyMap.Move):
+		if m.requireTask() {
+			// The cursor picks the target slot; the task moves on the second press
+			m.movingMode = !m.movingMode
+			if m.movingMode {
+				m.movingTaskIndex = m.selectedIndex
+			} else if m.selectedIndex != m.movingTaskIndex {
+				// Nothing moves before the drop, so this snapshot holds the
+				// pre-move order and one undo puts the task back
+				m.saveStateForUndo()
+				m.dropMovingTask()
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// updateKanbanView handles kanban view updates
+func (m Model) updateKanbanView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keyMap.Back), key.Matches(msg, m.keyMap.Quit), key.Matches(msg, m.keyMap.KanbanView):
+		m.viewMode = NormalView
+
+	case key.Matches(msg, m.keyMap.Left):
+		m.kanbanColumn--
+		m.kanbanRow = 0
+		m.clampKanbanSelection()
+
+	case key.Matches(msg, m.keyMap.Right):
+		m.kanbanColumn++
+		m.kanbanRow = 0
+		m.clampKanbanSelection()
+
+	case key.Matches(msg, m.keyMap.Up):
+		m.kanbanRow--
+		m.clampKanbanSelection()
+
+	case key.Matches(msg, m.keyMap.Down):
+		m.kanbanRow++
+		m.clampKanbanSelection()
+
+	case key.Matches(msg, m.keyMap.Toggle):
+		task, ok := m.kanbanSelected()
+		if m.settings.KanbanCompact {
+			// The compact board has no cards to pick from
+			m.errorMessage = "Press c to show the cards, then pick one to toggle"
+		} else if ok {
+			completed := m.toggleUndoable("kanban", task.ID)
+			m.clampKanbanSelection()
+			if completed {
+				m.setStatus("Task completed ✓")
+				if m.settings.CompleteBell {
+					return m, ringBell
+				}
+			}
+		}
+
+	case key.Matches(msg, m.keyMap.KanbanCompact):
+		m.settings.KanbanCompact = !m.settings.KanbanCompact
+		m.dirty = true
+	}
+	return m, nil
+}
+
+// updateStatsView handles stats view updates  
+func (m Model) updateStatsView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keyMap.Back), key.Matches(msg, m.keyMap.Quit), key.Matches(msg, m.keyMap.StatsView):
+		m.viewMode = NormalView
+
+	case key.Matches(msg, m.keyMap.StatsOrder):
+		orders := []string{"list", "completion", "open"}
+		m.settings.StatsSort = orders[(indexOf(orders, m.statsSort())+1)%len(orders)]
+		m.dirty = true
+
+	case key.Matches(msg, m.keyMap.Report):
+		path := filepath.Join(m.configPath, fmt.Sprintf("report-%s.md", time.Now().Format("2006-01-02")))
+		if err := m.saveReport(path); err != nil {
+			m.errorMessage = fmt.Sprintf("Could not write report: %v", err)
+		} else {
+			m.setStatus("Report written to " + path)
+		}
+	}
+	return m, nil
+}
+
+// updateKeysView handles the keybinding reference. Typing filters the list,
+// so only non-printable keys navigate.
+func (m Model) updateKeysView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		if m.keysQuery == "" {
+			m.viewMode = NormalView
+		}
+		m.keysQuery, m.keysOffset = "", 0
+	case tea.KeyUp:
+		m.keysOffset = max(m.keysOffset-1, 0)
+	case tea.KeyDown:
+		m.keysOffset = min(m.keysOffset+1, max(len(m.keyReference())-m.keysPageSize(), 0))
+	case tea.KeyPgUp:
+		m.keysOffset = max(m.keysOffset-m.keysPageSize(), 0)
+	case tea.KeyPgDown:
+		m.keysOffset = min(m.keysOffset+m.keysPageSize(), max(len(m.keyReference())-m.keysPageSize(), 0))
+	case tea.KeyBackspace:
+		if runes := []rune(m.keysQuery); len(runes) > 0 {
+			m.keysQuery = string(runes[:len(runes)-1])
+			m.keysOffset = 0
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.keysQuery += string(msg.Runes)
+		m.keysOffset = 0
+	}
+	return m, nil
+}
+
+// keyReference lists every binding of the key map, in reference order, whose keys
+// or description match the typed filter
+func (m Model) keyReference() []key.Binding {
+	query := strings.ToLower(m.keysQuery)
+	seen := make(map[string]bool)
+	var bindings []key.Binding
+	for _, row := range m.keyMap.ReferenceHelp() {
+		for _, b := range row {
+			h := b.Help()
+			if !b.Enabled() || seen[h.Key+h.Desc] {
+				continue
+			}
+			seen[h.Key+h.Desc] = true
+			text := strings.ToLower(strings.Join(b.Keys(), " ") + " " + h.Key + " " + h.Desc)
+			if strings.Contains(text, query) {
+				bindings = append(bindings, b)
+			}
+		}
+	}
+	return bindings
+}
+
+// keysPageSize is how many bindings fit on screen at once
+func (m Model) keysPageSize() int {
+	if m.windowHeight == 0 {
+		return 20
+	}
+	return max(m.windowHeight-8, 3)
+}
+
+// updateDetailView handles the task detail view
+func (m Model) updateDetailView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keyMap.Back), key.Matches(msg, m.keyMap.Enter), key.Matches(msg, m.keyMap.Details):
+		m.viewMode = m.detailReturn
+
+	case key.Matches(msg, m.keyMap.OpenURL):
+		m.openTaskURL(m.detailedTask())
+
+	case key.Matches(msg, m.keyMap.Up):
+		m.detailOffset = max(m.detailOffset-1, 0)
+
+	case key.Matches(msg, m.keyMap.Down):
+		if m.detailOffset < len(m.detailLines())-m.detailPageSize() {
+			m.detailOffset++
+		}
+	}
+	return m, nil
+}
+
+// detailWidth is the width details wrap at, or 0 before the window size is known
+func (m Model) detailWidth() int {
+	width := m.windowWidth
+	if m.settings.MaxWidth > 0 && m.settings.MaxWidth < width {
+		width = m.settings.MaxWidth
+	}
+	if width == 0 {
+		return 0
+	}
+	return max(width-baseStyle.GetHorizontalPadding(), 20)
+}
+
+// View implements tea.Model
+func (m Model) View() string {
+	if m.loading {
+		return baseStyle.Render(m.spinner.View() + " Loading tasks...")
+	}
+	if m.locked {
+		return m.renderLockView()
+	}
+
+	switch m.viewMode {
+	case InputView:
+		if m.inputMode == SearchInput {
+			return m.centered(m.renderInputView() + "\n" + m.renderSearchPreview())
+		}
+		return m.centered(m.renderInputView())
+	case TextAreaView:
+		return m.centered(m.renderTextAreaView())
+	case DateInputView:
+		return m.centered(m.renderDateInputView())
+	case RemoveTagView:
+		return m.centered(m.renderRemoveTagView())
+	case URLPickerView:
+		return m.centered(m.renderURLPickerView())
+	case CategoryPickerView:
+		return m.centered(m.renderCategoryPickerView())
+	case ArchivePickerView:
+		return m.centered(m.renderArchivePickerView())
+	case KanbanView:
+		// The board always uses the full terminal width
+		return m.renderKanbanView()
+	case StatsView:
+		return m.centered(m.renderStatsView())
+	case DetailView:
+		return m.centered(m.renderDetailView())
+	case KeysView:
+		return m.centered(m.renderKeysView())
+	case NormalView:
+		if m.focusMode {
+			return m.renderFocusView()
+		}
+	}
+	return m.centered(m.renderNormalView())
+}
+
+// centered caps a rendered view at max_width and centers it in the window
+func (m Model) centered(view string) string {
+	maxWidth := m.settings.MaxWidth
+	if maxWidth <= 0 || m.windowWidth <= maxWidth {
+		return view
+	}
+	view = lipgloss.NewStyle().MaxWidth(maxWidth).Render(view)
+	return lipgloss.PlaceHorizontal(m.windowWidth, lipgloss.Center, view)
+}
+
+// renderNormalView renders the main task list view
+func (m Model) renderNormalView() string {
+	var content strings.Builder
+
+	// Header
+	contextText := fmt.Sprintf("Context: %s", strings.ReplaceAll(m.currentContext, contextSeparator, " › "))
+	if n := len(m.subcontextsOf(m.currentContext)); n > 0 {
+		if indexOf(m.settings.CollapsedContexts, m.currentContext) >= 0 {
+			contextText += fmt.Sprintf(" ▸ %d more", n)
+		} else {
+			contextText += fmt.Sprintf(" ▾ %d", n)
+		}
+	}
+	if m.contextLocked {
+		contextText += " 🔒"
+	}
+	if m.isTemplateContext(m.currentContext) {
+		contextText += " (template)"
+	}
+	if filters := m.activeFilters(); len(filters) > 0 {
+		contextText += " [" + strings.Join(filters, ", ") + "]"
+	}
+	if progress := m.titleProgress(); progress != "" {
+		contextText += " — " + progress
+	}
+	contextText += m.sortArrow("normal")
+	overLimit := ""
+	if open, limit, over := m.overTaskLimit(m.currentContext); over {
+		overLimit = overLimitStyle.Render(fmt.Sprintf(" ⚠ %d/%d open", open, limit))
+	}
+	if m.viewMode == SearchView {
+		contextText = "Search Results (ESC to exit)"
+		if m.searchQuery == "" {
+			contextText = "All Tasks (ESC to exit)"
+		}
+		if len(m.searchRefines) > 0 {
+			contextText = fmt.Sprintf("Search: %s (ESC to exit)", strings.Join(append(append([]string(nil), m.searchRefines...), m.searchQuery), " › "))
+		}
+		if m.recentView {
+			contextText = "Recently Added (ESC to exit)"
+		} else if m.todayView {
+			contextText = "Today (ESC to exit)"
+		}
+		contextText += m.sortArrow("search")
+	}
+	if m.viewMode == SearchView {
+		overLimit = ""
+	}
+	content.WriteString(titleStyle.Render(contextText) + overLimit + "\n\n")
+
+	if line := m.reminderLine(); line != "" && m.viewMode == NormalView {
+		content.WriteString(reminderStyle.Render(line) + "\n\n")
+	}
+	if m.dueReminder != "" && m.viewMode == NormalView {
+		content.WriteString(reminderStyle.Render("⏰ "+m.dueReminder+" (enter to review, esc to dismiss)") + "\n\n")
+	} else if m.reviewReminder != "" && m.viewMode == NormalView {
+		content.WriteString(reminderStyle.Render("🧹 "+m.reviewReminder+" (enter to mark reviewed, esc to dismiss)") + "\n\n")
+	} else if m.settings.TutorialStep > 0 && m.viewMode == NormalView {
+		content.WriteString(m.renderTutorial() + "\n\n")
+	}
+	if m.settings.Scratchpads[m.currentContext] != "" && m.viewMode == NormalView {
+		content.WriteString(m.renderScratchpad() + "\n\n")
+	}
+	if m.filing {
+		var choices []string
+		for i, context := range m.fileToContexts() {
+			choices = append(choices, fmt.Sprintf("%d %s", i+1, context))
+		}
+		content.WriteString(reminderStyle.Render("Move to: "+strings.Join(choices, " · ")+" (esc to cancel)") + "\n\n")
+	}
+	if m.marking != "" {
+		content.WriteString(reminderStyle.Render(m.markPrompt()) + "\n\n")
+	}
+
+	// Tasks
+	tasks := m.getFilteredTasks()
+	if len(tasks) == 0 {
+		if m.recentView {
+			content.WriteString("No recently added tasks.\n")
+		} else if m.todayView {
+			content.WriteString("Nothing picked for today. Press . on a task to add it.\n")
+		} else if m.viewMode == SearchView {
+			content.WriteString("No matching tasks found.\n")
+		} else {
+			content.WriteString(m.emptyContextMessage() + "\n")
+		}
+	} else {
+		group := ""
+		for i, task := range tasks {
+			// Subtasks stay under their parent's heading
+			if m.settings.GroupByPriority && m.viewMode == NormalView && task.ParentID == 0 && priorityGroup(task) != group {
+				group = priorityGroup(task)
+				content.WriteString(contextStyle.Render(group) + "\n")
+			}
+			if !m.movingMode {
+				content.WriteString(m.renderTask(task, i == m.selectedIndex, false) + "\n")
+				continue
+			}
+
+			// Moving: the task stays put, with a ghost marking where it will land
+			target := m.selectedIndex
+			ghost := ghostStyle.Render("──▶ " + firstLine(tasks[m.movingTaskIndex].Task))
+			if i == target && target < m.movingTaskIndex {
+				content.WriteString(ghost + "\n")
+			}
+			content.WriteString(m.renderTask(task, i == m.movingTaskIndex, i == m.movingTaskIndex) + "\n")
+			if i == target && target > m.movingTaskIndex {
+				content.WriteString(ghost + "\n")
+			}
+		}
+	}
+
+	if n := m.collapsedCompleted(); n > 0 {
+		content.WriteString(completedTaskStyle.Copy().Strikethrough(false).Render(fmt.Sprintf("✓ %d completed (_ to show)", n)) + "\n")
+	}
+
+	// Status line
+	content.WriteString("\n" + m.renderStatusLine() + "\n")
+	if m.showMessages {
+		content.WriteString(m.renderMessages())
+	}
+
+	// Help
+	m.help.ShowAll = true
+	content.WriteString("\n" + helpStyle.Render(m.help.View(m.keyMap)))
+
+	return baseStyle.Render(content.String())
+}
+
+// renderStatusLine renders the mode, position and latest feedback
+func (m Model) renderStatusLine() string {
+	mode := "NORMAL"
+	if m.movingMode {
+		mode = "MOVE"
+	} else if m.recentView {
+		mode = "RECENT"
+	} else if m.todayView {
+		mode = "TODAY"
+	} else if m.viewMode == SearchView {
+		mode = "SEARCH"
+	}
+
+	tasks := m.getFilteredTasks()
+	position := "0/0"
+	if len(tasks) > 0 {
+		position = fmt.Sprintf("%d/%d", m.selectedIndex+1, len(tasks))
+	}
+
+	hints := []string{position, fmt.Sprintf("undo %d/%d", len(m.history), m.maxHistory)}
+	if m.dirty {
+		hints = append(hints, "modified")
+	}
+	if filters := m.activeFilters(); len(filters) > 0 {
+		hints = append(hints, "filter: "+strings.Join(filters, ", "))
+	}
+	if m.movingMode {
+		hints = append(hints, "↑/↓ to pick a slot, m to drop, esc to cancel")
+	}
+
+	line := statusModeStyle.Render(mode) + " " + helpStyle.Render(strings.Join(hints, " · "))
+
+	// The line always takes exactly one row, so messages coming and going
+	// don't shift the list; long ones are cut and can be read with W
+	room := m.help.Width - baseStyle.GetHorizontalPadding() - lipgloss.Width(line) - 2
+	fit := func(text string) string {
+		if m.help.Width <= 0 {
+			return text
+		}
+		return truncateWidth(text, max(room, 0))
+	}
+
+	// Errors take precedence over informational messages
+	if m.errorMessage != "" {
+		line += "  " + errorStyle.Render(fit(m.errorMessage))
+	} else if m.statusMessage != "" {
+		line += "  " + statusMessageStyle.Render(fit(m.statusMessage))
+	}
+
+	return line
+}
+
+// renderMessages lists the recent messages below the status line, newest first
+func (m Model) renderMessages() string {
+	var content strings.Builder
+	content.WriteString(helpStyle.Render("Recent messages (W to hide):") + "\n")
+	if len(m.messages) == 0 {
+		content.WriteString(helpStyle.Render("  none yet") + "\n")
+	}
+	for i := len(m.messages) - 1; i >= 0 && i >= len(m.messages)-10; i-- {
+		msg := m.messages[i]
+		style := statusMessageStyle
+		if msg.err {
+			style = errorStyle
+		}
+		content.WriteString("  " + helpStyle.Render(msg.at.Format("15:04:05")) + " " + style.Render(msg.text) + "\n")
+	}
+	return content.String()
+}
+
+// renderTask renders a single task
+func (m Model) renderTask(task Task, selected, moving bool) string {
+	// Checkbox
+	checkbox := m.glyphs.Unchecked
+	if task.Checked {
+		checkbox = m.glyphs.Checked
+	}
+
+	// Priority indicator
+	priority := ""
+	// Search results and their preview mix contexts, so each task names its own
+	if m.viewMode == SearchView || m.viewMode == InputView && m.inputMode == SearchInput {
+		priority = contextStyle.Render(task.Context) + " "
+	}
+	if m.settings.ShowIDs {
+		priority += helpStyle.Render(taskRef(task) + " ")
+	}
+	priority += m.priorityMarks.render(task.Priority)
+
+	// Category label
+	if task.Category != "" {
+		priority += m.categoryLabel(task.Category) + " "
+	}
+
+	// Task text, first line only for multi-line tasks. Search results can be
+	// cut short to stay scannable; the details view has the whole title.
+	taskText := firstLine(task.Task)
+	if limit := m.settings.SearchTitleWidth; limit > 0 && (m.viewMode == SearchView || m.viewMode == InputView && m.inputMode == SearchInput) {
+		taskText = truncateWidth(taskText, limit)
+	}
+	if task.ParentID != 0 {
+		checkbox = "  ↳ " + checkbox
+	}
+	if task.Template {
+		taskText = "◇ " + taskText
+	}
+	if subtasks := m.subtasksOf(task.ID); len(subtasks) > 0 {
+		taskText += fmt.Sprintf(" [%d/%d]", subtasksDone(subtasks), len(subtasks))
+		if task.Collapsed {
+			taskText += fmt.Sprintf(" (+%d)", len(subtasks))
+		}
+	}
+	if task.Percent > 0 && !task.Checked {
+		taskText += " " + percentBar(task.Percent)
+	}
+	if task.Notes != "" {
+		taskText += " " + m.glyphs.Notes
+	}
+	if len(task.Attachments) > 0 {
+		taskText += " " + m.glyphs.Attachment
+	}
+	if task.Schedule != "" {
+		taskText += " ↻"
+	}
+	if m.settings.ShowCompletionCount && task.CompletionCount > 0 {
+		taskText += fmt.Sprintf(" done %d×", task.CompletionCount)
+	}
+	if task.Today {
+		taskText += " ☀"
+	}
+	if m.viewMode == NormalView && isSubcontext(task.Context, m.currentContext) {
+		taskText += " · " + strings.TrimPrefix(task.Context, m.currentContext+contextSeparator)
+	}
+	query := ""
+	if m.viewMode == SearchView {
+		if filter, err := parseSearchQuery(m.searchQuery); err == nil {
+			query = filter.phrase
+		}
+	}
+
+	// Due date
+	dueDate := ""
+	overdue := m.isOverdue(task)
+	if overdue {
+		dueDate = fmt.Sprintf(" [Overdue: %s]", m.dueLabel(task))
+	} else if task.DueDate != "" {
+		dueDate = fmt.Sprintf(" [Due: %s]", m.dueLabel(task))
+	}
+
+	// Effort estimate
+	estimate := ""
+	if total, subtasks := m.rolledUpEstimate(task); subtasks > 0 && total > 0 {
+		estimate = fmt.Sprintf(" (%dpt across %d subtasks)", total, subtasks)
+	} else if task.Estimate > 0 {
+		estimate = fmt.Sprintf(" (%dpt)", task.Estimate)
+	}
+
+	// Age marker for tasks left open too long
+	stale := false
+	age := ""
+	if days, ok := m.staleAge(task); ok {
+		stale = true
+		age = fmt.Sprintf(" (stale %dd)", days)
+	}
+
+	// Apply styles
+	style := taskStyle
+	if task.Template {
+		style = templateTaskStyle
+	} else if task.Checked {
+		style = completedTaskStyle
+	} else if overdue {
+		style = overdueTaskStyle
+	} else if stale {
+		style = staleTaskStyle
+	} else if color := m.tagLineColor(task.Tags); color != "" {
+		style = taskStyle.Copy().Foreground(lipgloss.Color(color))
+	}
+
+	// The selected row uses the selection colors, whatever its state, unless
+	// only a cursor marks it; completed tasks keep their strikethrough
+	if selected && m.settings.Theme.Selection != "cursor" {
+		style = selectedTaskStyle.Copy().Strikethrough(task.Checked)
+	}
+
+	if moving {
+		style = style.Copy().Bold(true)
+	}
+
+	// Render piece by piece so matches and tag keys can be emphasized
+	// without losing the row style
+	base := style.Copy().UnsetPaddingLeft()
+	body := base.Render(taskText)
+	if query != "" {
+		body = highlightMatches(taskText, query, base)
+	}
+	line := base.Render(checkbox+" ") + body + renderTags(task.Tags, m.settings.TagLimit, base) +
+		base.Render(dueDate+estimate+age)
+	return m.cursor(selected) + priority + style.Copy().UnsetForeground().UnsetStrikethrough().Render("") + line
+}
+
+// cursor returns the column in front of a task row when the selection shows
+// a cursor: the cursor glyph on the selected row, blanks on the others
+func (m Model) cursor(selected bool) string {
+	if m.settings.Theme.Selection != "cursor" && m.settings.Theme.Selection != "both" {
+		return ""
+	}
+	if selected {
+		return cursorStyle.Render(m.glyphs.Cursor) + " "
+	}
+	return strings.Repeat(" ", runewidth.StringWidth(m.glyphs.Cursor)+1)
+}
+
+// rolledUpEstimate adds a parent's estimate to its subtasks' when
+// estimate_rollup is on, returning the total and the number of subtasks;
+// without the setting or subtasks it reports none
+func (m Model) rolledUpEstimate(task Task) (int, int) {
+	if !m.settings.EstimateRollup {
+		return 0, 0
+	}
+	subtasks := m.subtasksOf(task.ID)
+	total := task.Estimate
+	for _, subtask := range subtasks {
+		total += subtask.Estimate
+	}
+	return total, len(subtasks)
+}
+
+// tagLineColor returns the line color of the first tag mapped in
+// tag_line_colors, matching the whole tag or a key:value tag's key
+func (m Model) tagLineColor(tags []string) string {
+	if len(m.settings.TagLineColors) == 0 {
+		return ""
+	}
+	for _, tag := range tags {
+		key, _ := splitTag(tag)
+		// Look for the whole tag before its key, so project:web can
+		// have its own color apart from project
+		for _, want := range []string{tag, key} {
+			for name, color := range m.settings.TagLineColors {
+				if strings.EqualFold(name, want) {
+					return color
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// tagKeyColors colors key:value tags, picked per key so a key keeps its color
+var tagKeyColors = []string{"#89B4FA", "#F5C2E7", "#94E2D5", "#FAB387", "#CBA6F7", "#A6E3A1", "#F9E2AF"}
+
+// splitTag splits a key:value tag; plain tags have no value
+func splitTag(tag string) (key, value string) {
+	key, value, _ = strings.Cut(tag, ":")
+	return key, value
+}
+
+// renderTags renders tags after a " > " marker in the order they are stored,
+// which the tag dialog lets you change, with each key of key:value tags in
+// its own color. Past limit the remaining tags are only counted.
+func renderTags(tags []string, limit int, base lipgloss.Style) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	ordered, more := limitTags(tags, limit)
+	parts := make([]string, len(ordered))
+	for i, tag := range ordered {
+		key, value := splitTag(tag)
+		if value == "" {
+			parts[i] = base.Render(tag)
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToLower(key)))
+		color := tagKeyColors[h.Sum32()%uint32(len(tagKeyColors))]
+		parts[i] = base.Copy().Foreground(lipgloss.Color(color)).Render(tag)
+	}
+	return base.Render(" > ") + strings.Join(parts, base.Render(", ")) + base.Render(more)
+}
+
+// limitTags keeps the first limit tags and describes the rest as " +N";
+// a limit of 0 keeps them all
+func limitTags(tags []string, limit int) ([]string, string) {
+	if limit <= 0 || len(tags) <= limit {
+		return tags, ""
+	}
+	return tags[:limit], fmt.Sprintf(" +%d", len(tags)-limit)
+}
+
+// truncateWidth cuts s to at most width terminal cells, ending with "..." when shortened
+func truncateWidth(s string, width int) string {
+	return runewidth.Truncate(s, width, "...")
+}
+
+// highlightMatches renders text with base, emphasizing case-insensitive matches of query
+func highlightMatches(text, query string, base lipgloss.Style) string {
+	match := base.Copy().Foreground(lipgloss.AdaptiveColor{Light: "#DF8E1D", Dark: "#F9E2AF"}).Bold(true).Underline(true)
+	lowerText, lowerQuery := strings.ToLower(text), strings.ToLower(query)
+
+	// Lowercasing can change byte lengths for some scripts; fall back to plain text
+	if len(lowerText) != len(text) || query == "" {
+		return base.Render(text)
+	}
+
+	var b strings.Builder
+	for {
+		idx := strings.Index(lowerText, lowerQuery)
+		if idx < 0 {
+			break
+		}
+		if idx > 0 {
+			b.WriteString(base.Render(text[:idx]))
+		}
+		b.WriteString(match.Render(text[idx : idx+len(lowerQuery)]))
+		text, lowerText = text[idx+len(lowerQuery):], lowerText[idx+len(lowerQuery):]
+	}
+	if text != "" {
+		b.WriteString(base.Render(text))
+	}
+	return b.String()
+}
+
+// expandSnippet replaces the snippet trigger just before the cursor in the
+// add or edit box with its text. A trigger must start a word, and one that
+// begins a longer trigger waits for tab (forced), so ;f doesn't fire on the
+// way to ;fu.
+func (m *Model) expandSnippet(forced bool) bool {
+	if len(m.settings.Snippets) == 0 || (m.inputMode != AddTaskInput && m.inputMode != EditTaskInput) {
+		return false
+	}
+	value := []rune(m.textInput.Value())
+	pos := min(m.textInput.Position(), len(value))
+	before := string(value[:pos])
+
+	// The longest trigger wins, so ;fu beats u
+	trigger := ""
+	for t := range m.settings.Snippets {
+		if len(t) <= len(trigger) || !strings.HasSuffix(before, t) {
+			continue
+		}
+		if r, _ := utf8.DecodeLastRuneInString(strings.TrimSuffix(before, t)); r != utf8.RuneError && !unicode.IsSpace(r) {
+			continue
+		}
+		trigger = t
+	}
+	if trigger == "" {
+		return false
+	}
+	if !forced {
+		for t := range m.settings.Snippets {
+			if t != trigger && strings.HasPrefix(t, trigger) {
+				return false
+			}
+		}
+	}
+
+	expanded := strings.TrimSuffix(before, trigger) + m.settings.Snippets[trigger]
+	m.textInput.SetValue(expanded + string(value[pos:]))
+	m.textInput.SetCursor(utf8.RuneCountInString(expanded))
+	return true
+}
+
+// renderInputView renders input dialogs
+func (m Model) renderInputView() string {
+	hint := ""
+	if !m.captureMode && (m.inputMode == AddTaskInput || m.inputMode == EditTaskInput) {
+		hint = "\n\n" + helpStyle.Render("alt+enter: multi-line")
+		if len(m.settings.Snippets) > 0 {
+			hint += helpStyle.Render(" · tab: expand snippet")
+		}
+	}
+	return inputStyle.Render(
+		fmt.Sprintf("%s\n\n%s%s", m.inputPrompt, m.textInput.View(), hint),
+	)
+}
+
+// renderSearchPreview lists the tasks matching the search typed so far
+func (m Model) renderSearchPreview() string {
+	query := strings.TrimSpace(m.textInput.Value())
+	if query == "" {
+		return ""
+	}
+	if _, err := parseSearchQuery(query); err != nil {
+		return baseStyle.Render(errorStyle.Render(err.Error()))
+	}
+
+	var content strings.Builder
+	content.WriteString(helpStyle.Render(fmt.Sprintf("%d matching (enter to keep, esc to cancel)", len(m.searchResults))) + "\n")
+	limit := 10
+	if m.windowHeight > 0 {
+		limit = max(m.windowHeight-12, 3)
+	}
+	for i, task := range m.searchResults {
+		if i == limit {
+			content.WriteString(helpStyle.Render(fmt.Sprintf("  … %d more", len(m.searchResults)-limit)) + "\n")
+			break
+		}
+		content.WriteString(m.renderTask(task, false, false) + "\n")
+	}
+	return baseStyle.Render(content.String())
+}
+
+// renderTextAreaView renders the multi-line editor dialog
+func (m Model) renderTextAreaView() string {
+	return inputStyle.Render(
+		fmt.Sprintf("%s\n\n%s\n\n%s", m.inputPrompt, m.textArea.View(),
+			helpStyle.Render("enter: new line • ctrl+s: save • esc: cancel")),
+	)
+}
+
+// renderDateInputView renders due date input dialog
+func (m Model) renderDateInputView() string {
+	var content strings.Builder
+	if m.dateRangeStart != "" {
+		content.WriteString(fmt.Sprintf("Range from %s, now pick the end (ctrl+r for a single date):\n\n", m.dateRangeStart))
+	} else if m.dateCalendar {
+		content.WriteString("Pick due date (arrows move, enter selects, tab types it, ctrl+r starts a range):\n\n")
+	} else {
+		content.WriteString("Set due date (a two-digit year means 20YY, time optional, tab for calendar, ctrl+r starts a range):\n\n")
+	}
+	inputs := []string{
+		fmt.Sprintf("Day: %s", m.dateInputs[0].View()),
+		fmt.Sprintf("Month: %s", m.dateInputs[1].View()),
+		fmt.Sprintf("Year: %s", m.dateInputs[2].View()),
+		fmt.Sprintf("Hour: %s", m.dateInputs[3].View()),
+		fmt.Sprintf("Minute: %s", m.dateInputs[4].View()),
+	}
+	for i, input := range inputs {
+		if i == m.dateInputIndex && !m.dateCalendar {
+			content.WriteString(selectedTaskStyle.Render(input) + "\n")
+		} else {
+			content.WriteString(input + "\n")
+		}
+	}
+
+	// Preview the entered date on a month calendar
+	year, yerr := strconv.Atoi(expandYear(m.dateInputs[2].Value()))
+	month, merr := strconv.Atoi(m.dateInputs[1].Value())
+	day, _ := strconv.Atoi(m.dateInputs[0].Value())
+	if yerr == nil && merr == nil && month >= 1 && month <= 12 {
+		content.WriteString("\n" + m.renderMonthCalendar(year, time.Month(month), day))
+	}
+
+	return inputStyle.Render(content.String())
+}
+
+// firstWeekday returns the day weeks start on, from week_start
+func (m Model) firstWeekday() time.Weekday {
+	if strings.ToLower(m.settings.WeekStart) == "sunday" {
+		return time.Sunday
+	}
+	return time.Monday
+}
+
+// renderMonthCalendar renders a month grid with the given day highlighted,
+// starting weeks on the configured week_start day
+func (m Model) renderMonthCalendar(year int, month time.Month, selectedDay int) string {
+	var content strings.Builder
+
+	firstWeekday := m.firstWeekday()
+
+	content.WriteString(fmt.Sprintf("%s %d\n", month, year))
+	for i := 0; i < 7; i++ {
+		content.WriteString(fmt.Sprintf("%3s", time.Weekday((int(firstWeekday)+i)%7).String()[:2]))
+	}
+	content.WriteString("\n")
+
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	offset := (int(first.Weekday()) - int(firstWeekday) + 7) % 7
+	content.WriteString(strings.Repeat("   ", offset))
+
+	// While picking a range, the days from its start up to the selected end are marked too
+	rangeStart, _, rangeErr := parseDueDate(m.dateRangeStart)
+	selected := time.Date(year, month, selectedDay, 0, 0, 0, 0, time.Local)
+
+	for day := 1; day <= daysInMonth; day++ {
+		cell := fmt.Sprintf("%3d", day)
+		date := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+		if day == selectedDay {
+			cell = " " + selectedTaskStyle.Copy().PaddingLeft(0).Render(fmt.Sprintf("%2d", day))
+		} else if rangeErr == nil && !date.Before(rangeStart) && date.Before(selected) {
+			cell = " " + contextStyle.Render(fmt.Sprintf("%2d", day))
+		}
+		content.WriteString(cell)
+		if (offset+day)%7 == 0 && day != daysInMonth {
+			content.WriteString("\n")
+		}
+	}
+
+	return content.String() + "\n"
+}
+
+// renderRemoveTagView renders remove tag view
+func (m Model) renderRemoveTagView() string {
+	var content strings.Builder
+	task := m.getCurrentTask()
+	content.WriteString(fmt.Sprintf("Remove or reorder the tags of %q:\n\n", truncateWidth(firstLine(task.Task), 40)))
+	for i, tag := range m.tagOrder {
+		checkbox := m.glyphs.Unchecked
+		if m.removeTagChecks[i] {
+			checkbox = m.glyphs.Checked
+		}
+		number := "  "
+		if i < 9 {
+			number = fmt.Sprintf("%d.", i+1)
+		}
+		line := fmt.Sprintf("%s %s %s", number, checkbox, tag)
+		if i == m.removeTagIndex {
+			content.WriteString(selectedTaskStyle.Render(line) + "\n")
+		} else {
+			content.WriteString(line + "\n")
+		}
+	}
+	content.WriteString("\n" + helpStyle.Render("space: toggle • a: all/none • shift+↑/↓: move • 1-9: jump • enter: apply"))
+	return inputStyle.Render(content.String())
+}
+
+// renderURLPickerView renders the list of links found in the current task
+func (m Model) renderURLPickerView() string {
+	var content strings.Builder
+	content.WriteString("Open which link?\n\n")
+	for i, url := range m.urlChoices {
+		if i == m.urlIndex {
+			content.WriteString(selectedTaskStyle.Render(url) + "\n")
+		} else {
+			content.WriteString(url + "\n")
+		}
+	}
+	return inputStyle.Render(content.String())
+}
+
+// renderLockView hides everything behind a lock screen
+func (m Model) renderLockView() string {
+	var content strings.Builder
+	content.WriteString(titleStyle.Render("🔒 Locked") + "\n\n")
+	if m.settings.LockPassphrase == "" {
+		content.WriteString("Press any key to unlock.\n")
+	} else {
+		content.WriteString("Enter passphrase to unlock:\n\n" + m.lockInput.View() + "\n")
+		if m.errorMessage != "" {
+			content.WriteString("\n" + errorStyle.Render(m.errorMessage) + "\n")
+		}
+	}
+	return baseStyle.Render(content.String())
+}
+
+// renderFocusView shows only the selected task, centered in the window
+func (m Model) renderFocusView() string {
+	var content strings.Builder
+	tasks := m.getFilteredTasks()
+	if len(tasks) == 0 {
+		content.WriteString(helpStyle.Render("Nothing left here. esc to leave focus mode") + "\n")
+	} else {
+		index := min(m.selectedIndex, len(tasks)-1)
+		task := tasks[index]
+		content.WriteString(helpStyle.Render(fmt.Sprintf("%s · %d/%d", task.Context, index+1, len(tasks))) + "\n\n")
+
+		text := lipgloss.NewStyle().Bold(true)
+		if task.Checked {
+			text = completedTaskStyle.Copy().UnsetPaddingLeft().Bold(true)
+		}
+		width := max(m.windowWidth*2/3, 20)
+		content.WriteString(m.priorityMarks.render(task.Priority) + text.Width(width).Render(task.Task) + "\n")
+		if m.isOverdue(task) {
+			content.WriteString("\n" + errorStyle.Render("Overdue: "+m.dueLabel(task)) + "\n")
+		} else if task.DueDate != "" {
+			content.WriteString("\n" + helpStyle.Render("Due: "+m.dueLabel(task)) + "\n")
+		}
+		if m.settings.FocusNotes && task.Notes != "" {
+			content.WriteString("\n" + lipgloss.NewStyle().Width(width).Render(task.Notes) + "\n")
+		}
+	}
+
+	feedback := ""
+	if m.errorMessage != "" {
+		feedback = errorStyle.Render(m.errorMessage)
+	} else if m.statusMessage != "" {
+		feedback = statusMessageStyle.Render(m.statusMessage)
+	}
+	content.WriteString("\n" + feedback + "\n")
+	content.WriteString(helpStyle.Render(fmt.Sprintf("↑/↓ previous/next · %s done · esc leave focus", m.keyMap.Toggle.Help().Key)))
+
+	if m.windowWidth == 0 || m.windowHeight == 0 {
+		return baseStyle.Render(content.String())
+	}
+	return lipgloss.Place(m.windowWidth, m.windowHeight, lipgloss.Center, lipgloss.Center, content.String())
+}
+
+// detailLines lays out every field of the selected task, wrapped to the window
+func (m Model) detailLines() []string {
+	task := m.detailedTask()
+	var content strings.Builder
+	width := m.detailWidth()
+
+	// Long values wrap at the window width, lined up after the field name
+	field := func(name, value string) {
+		if value == "" {
+			return
+		}
+		label := fmt.Sprintf("%-12s", name+":")
+		if width > 0 {
+			value = lipgloss.NewStyle().Width(width - len(label) - 1).Render(value)
+			value = strings.ReplaceAll(value, "\n", "\n"+strings.Repeat(" ", len(label)+1))
+		}
+		content.WriteString(fmt.Sprintf("%s %s\n", helpStyle.Render(label), value))
+	}
+	status := "open"
+	if task.Checked {
+		status = "done"
+	}
+	field("Task", task.Task)
+	field("ID", strconv.Itoa(task.ID))
+	field("Code", task.Code)
+	field("Status", status)
+	field("Context", task.Context)
+	field("Priority", task.Priority)
+	field("Category", task.Category)
+	field("Tags", strings.Join(task.Tags, ", "))
+	field("Starts", task.StartDate)
+	field("Due", task.DueDate)
+	if after := repeatAfter(task); after != "" {
+		field("Repeats", after+" after completion")
+	} else {
+		field("Repeats", task.Schedule)
+	}
+	field("Git ref", task.Ref)
+	if task.Estimate > 0 {
+		field("Estimate", fmt.Sprintf("%d points", task.Estimate))
+	}
+	if total, subtasks := m.rolledUpEstimate(task); subtasks > 0 && total > 0 {
+		field("With subtasks", fmt.Sprintf("%d points across %d subtasks", total, subtasks))
+	}
+	if task.Percent > 0 {
+		field("Progress", fmt.Sprintf("%d%%", task.Percent))
+	}
+	if task.CompletionCount > 0 {
+		field("Times done", strconv.Itoa(task.CompletionCount))
+	}
+	field("Created", task.CreatedAt)
+	field("Completed", task.CompletedAt)
+
+	if task.Notes != "" {
+		// Soft-wrapped, keeping the note's own line breaks
+		notes := task.Notes
+		if width > 0 {
+			notes = lipgloss.NewStyle().Width(width).Render(notes)
+		}
+		content.WriteString("\nNotes:\n" + notes + "\n")
+	}
+
+	if len(task.Attachments) > 0 {
+		content.WriteString("\nAttachments (o to open):\n")
+		for _, path := range task.Attachments {
+			line := "  " + m.glyphs.Attachment + " " + path
+			if _, err := os.Stat(path); err != nil {
+				line += errorStyle.Render(" (missing)")
+			}
+			content.WriteString(line + "\n")
+		}
+	}
+
+	return strings.Split(strings.TrimSuffix(content.String(), "\n"), "\n")
+}
+
+// detailPageSize is how many detail lines fit under the title
+func (m Model) detailPageSize() int {
+	if m.windowHeight == 0 {
+		return 40
+	}
+	return max(m.windowHeight-4, 3)
+}
+
+// detailedTask returns the task the details view was opened on. It is looked
+// up by ID: search, recent and today results are no longer what
+// getFilteredTasks lists once the view has changed.
+func (m *Model) detailedTask() Task {
+	if i := m.findTaskIndex(m.detailTask); i >= 0 {
+		return m.tasks[i]
+	}
+	return Task{}
+}
+
+// renderDetailView renders every field of the selected task, scrolling
+// when they are taller than the window
+func (m Model) renderDetailView() string {
+	title := titleStyle.Render("Task Details (ESC to return)") + "\n\n"
+	lines := m.detailLines()
+	height := m.detailPageSize()
+	if len(lines) <= height {
+		return baseStyle.Render(title + strings.Join(lines, "\n"))
+	}
+	offset := min(m.detailOffset, len(lines)-height)
+	shown := strings.Join(lines[offset:offset+height], "\n")
+	more := helpStyle.Render(fmt.Sprintf("↑/↓ to scroll · %d-%d of %d lines", offset+1, offset+height, len(lines)))
+	return baseStyle.Render(title + shown + "\n" + more)
+}
+
+// renderKeysView renders the searchable keybinding reference, a page at a time
+func (m Model) renderKeysView() string {
+	var content strings.Builder
+	content.WriteString(titleStyle.Render("Keybindings (type to filter, ESC to return)") + "\n\n")
+	content.WriteString("Filter: " + m.keysQuery + "▏\n\n")
+
+	bindings := m.keyReference()
+	if len(bindings) == 0 {
+		content.WriteString("No matching keybindings.\n")
+		return baseStyle.Render(content.String())
+	}
+
+	end := min(m.keysOffset+m.keysPageSize(), len(bindings))
+	for _, b := range bindings[m.keysOffset:end] {
+		content.WriteString(fmt.Sprintf("  %-16s %s\n", strings.Join(b.Keys(), ", "), b.Help().Desc))
+	}
+	if len(bindings) > end || m.keysOffset > 0 {
+		content.WriteString(helpStyle.Render(fmt.Sprintf("\n%d-%d of %d, ↑/↓ to scroll", m.keysOffset+1, end, len(bindings))))
+	}
+	return baseStyle.Render(content.String())
+}
+
+// renderArchivePickerView renders the archived contexts with their task counts
+func (m Model) renderArchivePickerView() string {
+	var content strings.Builder
+	content.WriteString("Restore which context?\n\n")
+	for i, ctx := range m.settings.Archived {
+		line := fmt.Sprintf("%s (%d tasks)", ctx, len(m.getTasksForContext(ctx)))
+		if i == m.archiveIndex {
+			content.WriteString(selectedTaskStyle.Render(line) + "\n")
+		} else {
+			content.WriteString(line + "\n")
+		}
+	}
+	return inputStyle.Render(content.String())
+}
+
+// renderCategoryPickerView renders the list of configured categories
+func (m Model) renderCategoryPickerView() string {
+	var content strings.Builder
+	content.WriteString("Set category:\n\n")
+	lines := append([]string{"(none)"}, m.categoryNames()...)
+	for i, name := range lines {
+		if i > 0 {
+			name = m.categoryLabel(name)
+		}
+		if i == m.categoryIndex {
+			content.WriteString(selectedTaskStyle.Render("> ") + name + "\n")
+		} else {
+			content.WriteString("  " + name + "\n")
+		}
+	}
+	return inputStyle.Render(content.String())
+}
+
+// renderKanbanView renders the kanban board
+func (m Model) renderKanbanView() string {
+	var content strings.Builder
+	
+	content.WriteString(titleStyle.Render(fmt.Sprintf("Kanban View (ESC to return, %s to toggle, c for compact/cards)", m.keyMap.Toggle.Help().Key)) + "\n\n")
+
+	contexts, groups := m.kanbanColumns()
+	selected, _ := m.kanbanSelected()
+
+	// Calculate column width in terminal cells, leaving room for the separators
+	colWidth := (m.windowWidth-4)/len(contexts) - 2
+	if colWidth < 20 {
+		colWidth = 20
+	}
+
+	// Render columns
+	var columns []string
+	for col, context := range contexts {
+		var column strings.Builder
+		
+		// Tasks in this context and the subcontexts grouped under it
+		tasks := m.getTasksForContext(context)
+		for _, sub := range groups[context] {
+			tasks = append(tasks, m.getTasksForContext(sub)...)
+		}
+
+		// Column header, flagged when open tasks exceed the WIP limit
+		header := contextStyle.Render(truncateWidth(context, colWidth))
+		if limit, ok := m.settings.WIPLimits[context]; ok && limit > 0 {
+			open := 0
+			for _, task := range tasks {
+				if !task.Checked && !task.Template {
+					open++
+				}
+			}
+			wip := truncateWidth(fmt.Sprintf("%s %d/%d", context, open, limit), colWidth)
+			if open > limit {
+				header = overLimitStyle.Render(wip)
+			} else {
+				header = contextStyle.Render(wip)
+			}
+		}
+		column.WriteString(header + "\n")
+		column.WriteString(strings.Repeat("─", colWidth/runewidth.StringWidth("─")) + "\n")
+
+		if m.settings.KanbanCompact {
+			m.writeKanbanCounts(&column, tasks)
+			columns = append(columns, column.String())
+			continue
+		}
+		for _, group := range append([]string{context}, groups[context]...) {
+			groupTasks := m.getTasksForContext(group)
+			if group != context {
+				if len(groupTasks) == 0 {
+					continue
+				}
+				column.WriteString(helpStyle.Render(truncateWidth("▸ "+strings.TrimPrefix(group, context+contextSeparator), colWidth)) + "\n")
+			}
+			selectedID := 0
+			if col == m.kanbanColumn {
+				selectedID = selected.ID
+			}
+			m.writeKanbanCards(&column, groupTasks, colWidth, selectedID)
+		}
+
+		columns = append(columns, column.String())
+	}
+
+	// Combine columns side by side, each padded to the same width
+	var rendered []string
+	for i, col := range columns {
+		style := lipgloss.NewStyle().Width(colWidth)
+		if i > 0 {
+			style = style.BorderStyle(lipgloss.NormalBorder()).BorderLeft(true).PaddingLeft(1)
+		}
+		rendered = append(rendered, style.Render(strings.TrimSuffix(col, "\n")))
+	}
+	content.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, rendered...))
+
+	if m.errorMessage != "" {
+		content.WriteString("\n\n" + errorStyle.Render(m.errorMessage))
+	} else if m.statusMessage != "" {
+		content.WriteString("\n\n" + statusMessageStyle.Render(m.statusMessage))
+	}
+
+	return baseStyle.Render(content.String())
+}
+
+// kanbanColumns returns the contexts that get a kanban column, with the
+// subcontexts grouped into each. The someday list is not part of the board.
+func (m Model) kanbanColumns() ([]string, map[string][]string) {
+	contexts := m.navigableContexts()
+	if len(contexts) == 0 {
+		contexts = m.contexts
+	}
+
+	// Subcontexts are grouped into their top-level context's column
+	groups := make(map[string][]string)
+	var columns []string
+	for _, context := range contexts {
+		top := context
+		for parent, ok := parentContext(context); ok; parent, ok = parentContext(parent) {
+			if indexOf(contexts, parent) >= 0 {
+				top = parent
+			}
+		}
+		if top == context {
+			columns = append(columns, context)
+		} else {
+			groups[top] = append(groups[top], context)
+		}
+	}
+	return columns, groups
+}
+
+// kanbanCards lists the cards of a column in the order they are drawn:
+// the column's own tasks, then each grouped subcontext's
+func (m Model) kanbanCards(context string, groups []string) []Task {
+	var cards []Task
+	for _, group := range append([]string{context}, groups...) {
+		tasks := m.getTasksForContext(group)
+		if keys := m.viewSortKeys("kanban"); len(keys) > 0 {
+			sortTasks(tasks, keys)
+		}
+		cards = append(cards, tasks...)
+	}
+	return cards
+}
+
+// kanbanSelected returns the selected card of the board, if there is one
+func (m Model) kanbanSelected() (Task, bool) {
+	columns, groups := m.kanbanColumns()
+	if m.kanbanColumn < 0 || m.kanbanColumn >= len(columns) {
+		return Task{}, false
+	}
+	context := columns[m.kanbanColumn]
+	cards := m.kanbanCards(context, groups[context])
+	if m.kanbanRow < 0 || m.kanbanRow >= len(cards) {
+		return Task{}, false
+	}
+	return cards[m.kanbanRow], true
+}
+
+// clampKanbanSelection keeps the selected card on the board after columns
+// or cards come and go
+func (m *Model) clampKanbanSelection() {
+	columns, groups := m.kanbanColumns()
+	m.kanbanColumn = min(max(m.kanbanColumn, 0), max(len(columns)-1, 0))
+	cards := 0
+	if m.kanbanColumn < len(columns) {
+		context := columns[m.kanbanColumn]
+		cards = len(m.kanbanCards(context, groups[context]))
+	}
+	m.kanbanRow = min(max(m.kanbanRow, 0), max(cards-1, 0))
+}
+
+// writeKanbanCards writes one card per task, in the kanban sort order, the
+// one with selected as its ID highlighted
+func (m Model) writeKanbanCards(column *strings.Builder, tasks []Task, colWidth int, selected int) {
+	if keys := m.viewSortKeys("kanban"); len(keys) > 0 {
+		sortTasks(tasks, keys)
+	}
+	for _, task := range tasks {
+		taskText := firstLine(task.Task)
+
+		tags := ""
+		if len(task.Tags) > 0 {
+			shown, more := limitTags(task.Tags, m.settings.TagLimit)
+			tags = " > " + strings.Join(shown, ", ") + more
+		}
+
+		dueDate := ""
+		if m.isOverdue(task) {
+			dueDate = fmt.Sprintf(" [Overdue: %s]", m.dueLabel(task))
+		} else if task.DueDate != "" {
+			dueDate = fmt.Sprintf(" [Due: %s]", m.dueLabel(task))
+		}
+
+		// Cards are cut to the column width, counting wide glyphs as two cells
+		cardWidth := colWidth - taskStyle.GetPaddingLeft()
+		bar := ""
+		if m.settings.KanbanEmphasis {
+			bar = kanbanBar(task)
+			cardWidth -= lipgloss.Width(bar)
+		}
+		if task.ID == selected && selected != 0 {
+			glyph := m.glyphs.Bullet
+			if task.Checked {
+				glyph = m.glyphs.Done
+			}
+			card := truncateWidth(fmt.Sprintf("%s %s%s%s", glyph, taskText, tags, dueDate), cardWidth)
+			column.WriteString(selectedTaskStyle.Render(bar+card) + "\n")
+		} else if task.Checked {
+			card := truncateWidth(fmt.Sprintf("%s %s%s%s", m.glyphs.Done, taskText, tags, dueDate), cardWidth)
+			column.WriteString(completedTaskStyle.Render(bar+card) + "\n")
+		} else {
+			// The priority indicator keeps its color, so it goes ahead of the cut text
+			mark := m.priorityMarks.render(task.Priority)
+			card := truncateWidth(fmt.Sprintf("%s %s%s%s", m.glyphs.Bullet, taskText, tags, dueDate), cardWidth-lipgloss.Width(mark))
+			style := taskStyle
+			if m.settings.KanbanEmphasis && task.Priority == "high" {
+				style = taskStyle.Copy().Bold(true)
+			}
+			column.WriteString(style.Render(bar+mark+card) + "\n")
+		}
+	}
+}
+
+// writeKanbanCounts sums up a column for the compact board: open out of all
+// tasks, open tasks by priority, and how many are overdue
+func (m Model) writeKanbanCounts(column *strings.Builder, tasks []Task) {
+	open, total, overdue := 0, 0, 0
+	byPriority := make(map[string]int)
+	for _, task := range tasks {
+		if task.Template {
+			continue
+		}
+		total++
+		if task.Checked {
+			continue
+		}
+		open++
+		byPriority[task.Priority]++
+		if m.isOverdue(task) {
+			overdue++
+		}
+	}
+
+	column.WriteString(fmt.Sprintf("Open: %d/%d\n", open, total))
+	for _, priority := range []string{"high", "medium", "low", ""} {
+		name := priority
+		if name == "" {
+			name = "none"
+		}
+		column.WriteString(fmt.Sprintf("%s%s: %d\n", m.priorityMarks.render(priority), name, byPriority[priority]))
+	}
+	if overdue > 0 {
+		column.WriteString(overLimitStyle.Render(fmt.Sprintf("Overdue: %d", overdue)) + "\n")
+	}
+}
+
+// kanbanBar is the edge drawn along an open card with kanban_emphasis:
+// heavy for high priority, light for medium, blank otherwise
+func kanbanBar(task Task) string {
+	if task.Checked {
+		return "  "
+	}
+	switch task.Priority {
+	case "high":
+		return "┃ "
+	case "medium":
+		return "│ "
+	}
+	return "  "
+}
+
+// renderStatsView renders the statistics view
+func (m Model) renderStatsView() string {
+	var content strings.Builder
+	
+	content.WriteString(titleStyle.Render("Statistics (ESC to return, w to write a report, o to reorder)") + "\n\n")
+
+	// Overall stats, leaving out the lists stats_include doesn't name
+	overall := m.completionOf(m.tasks)
+	counted := "Counting active tasks"
+	if len(m.settings.StatsInclude) > 0 {
+		counted += " and " + strings.Join(m.settings.StatsInclude, ", ")
+	}
+	content.WriteString(helpStyle.Render(counted) + "\n")
+	content.WriteString(fmt.Sprintf("Total Tasks: %d\n", overall.total))
+	content.WriteString(fmt.Sprintf("Completed: %d (%.1f%%)\n", overall.done, overall.rate()))
+	if basis, ok := m.statsWeightBasis(); ok {
+		content.WriteString(fmt.Sprintf("Completed by %s: %.1f%%\n", basis, m.weightedCompletion(m.tasks)))
+	}
+	if progress, ok := m.partialCompletion(m.tasks); ok {
+		content.WriteString(fmt.Sprintf("Progress with partial tasks: %.1f%%\n", progress))
+	}
+	if notes, attached := countExtras(m.tasks); notes+attached > 0 {
+		content.WriteString(fmt.Sprintf("With notes: %d · With attachments: %d\n", notes, attached))
+	}
+	content.WriteString("\n")
+	if heatmap := m.renderHeatmap(time.Now()); heatmap != "" {
+		content.WriteString(heatmap + "\n")
+	}
+
+	// Context stats
+	content.WriteString(fmt.Sprintf("Context Statistics (by %s):\n", m.statsSort()))
+	history := m.completionHistory(m.statsContexts())
+	for _, context := range m.statsContexts() {
+		tasks := m.getTasksForContext(context)
+		stats := m.completionOf(tasks)
+		line := fmt.Sprintf("  %s: %d/%d (%.1f%%)",
+			contextStyle.Render(context), stats.done, stats.total, stats.rate())
+		if counts, ok := history.counts[context]; ok {
+			line += " " + sparkline(counts, history.peak)
+		}
+		if open, limit, over := m.overTaskLimit(context); over {
+			line += overLimitStyle.Render(fmt.Sprintf(" ⚠ %d/%d open", open, limit))
+		}
+		if basis, ok := m.statsWeightBasis(); ok {
+			line += fmt.Sprintf(" (%.1f%% by %s)", m.weightedCompletion(tasks), basis)
+		}
+
+		// Progress toward the daily goal, if one is set
+		if goal := m.settings.DailyGoals[context]; goal > 0 {
+			today := completedOn(tasks, time.Now())
+			line += fmt.Sprintf("  today: %d/%d", today, goal)
+			if today >= goal {
+				line += statusMessageStyle.Render(" ★ goal met")
+			}
+		}
+
+		content.WriteString(line + "\n")
+	}
+
+	if m.errorMessage != "" {
+		content.WriteString("\n" + errorStyle.Render(m.errorMessage) + "\n")
+	} else if m.statusMessage != "" {
+		content.WriteString("\n" + statusMessageStyle.Render(m.statusMessage) + "\n")
+	}
+
+	return baseStyle.Render(content.String())
+}
+
+// Helper methods
+
+// contextHistory holds each context's completions per day, oldest first,
+// and the highest daily count across them so the charts share a scale
+type contextHistory struct {
+	counts map[string][]int
+	peak   int
+}
+
+// completionHistory counts the completions per day of each context over the
+// last sparkline_days days
+func (m Model) completionHistory(contexts []string) contextHistory {
+	history := contextHistory{counts: make(map[string][]int)}
+	days := m.settings.SparklineDays
+	if days < 0 {
+		return history
+	}
+	if days == 0 {
+		days = 7
+	}
+
+	now := time.Now()
+	for _, context := range contexts {
+		tasks := m.getTasksForContext(context)
+		counts := make([]int, days)
+		for i := range counts {
+			counts[i] = completedOn(tasks, now.AddDate(0, 0, i-days+1))
+			history.peak = max(history.peak, counts[i])
+		}
+		history.counts[context] = counts
+	}
+	return history
+}
+
+// sparkBlocks are the bar heights of a sparkline, lowest first
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws daily counts as block characters scaled to peak; days
+// without completions show as a dot
+func sparkline(counts []int, peak int) string {
+	var b strings.Builder
+	for _, count := range counts {
+		if count == 0 || peak == 0 {
+			b.WriteString("·")
+			continue
+		}
+		b.WriteRune(sparkBlocks[(count*len(sparkBlocks)-1)/peak])
+	}
+	return statusMessageStyle.Render(b.String())
+}
+
+// heatBlocks shade a heatmap day from a few completions up to the busiest day
+var heatBlocks = []rune("░▒▓█")
+
+// renderHeatmap charts the completions of the last heatmap_weeks weeks as a
+// grid with a row per weekday and a column per week, the current week last
+func (m Model) renderHeatmap(now time.Time) string {
+	weeks := m.settings.HeatmapWeeks
+	if weeks < 0 {
+		return ""
+	}
+	if weeks == 0 {
+		weeks = 12
+	}
+
+	counts := make(map[string]int)
+	for _, task := range m.tasks {
+		if !task.Checked || !m.taskCountsInStats(task) {
+			continue
+		}
+		if done, err := time.Parse(time.RFC3339, task.CompletedAt); err == nil {
+			counts[done.In(now.Location()).Format(dueDateLayout)]++
+		}
+	}
+
+	firstWeekday := m.firstWeekday()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := today.AddDate(0, 0, -(int(today.Weekday())-int(firstWeekday)+7)%7-7*(weeks-1))
+	peak := 0
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		peak = max(peak, counts[day.Format(dueDateLayout)])
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Completions, last %d weeks:\n", weeks))
+	for row := 0; row < 7; row++ {
+		cells := make([]string, weeks)
+		for week := range cells {
+			day := start.AddDate(0, 0, 7*week+row)
+			count := counts[day.Format(dueDateLayout)]
+			switch {
+			case day.After(today):
+				cells[week] = " "
+			case count == 0:
+				cells[week] = "·"
+			default:
+				cells[week] = string(heatBlocks[(count*len(heatBlocks)-1)/peak])
+			}
+		}
+		weekday := time.Weekday((int(firstWeekday) + row) % 7).String()[:2]
+		b.WriteString(fmt.Sprintf("  %s %s\n", weekday, statusMessageStyle.Render(strings.Join(cells, " "))))
+	}
+	b.WriteString(helpStyle.Render(fmt.Sprintf("     less · %s more (busiest day: %d)", string(heatBlocks), peak)) + "\n")
+	return b.String()
+}
+
+// completion counts finished tasks against the total
+type completion struct {
+	done, total int
+}
+
+// rate returns the completed percentage
+func (c completion) rate() float64 {
+	if c.total == 0 {
+		return 0
+	}
+	return float64(c.done) / float64(c.total) * 100
+}
+
+// completionOf counts the tasks that are part of stats, see countsInStats
+func (m Model) completionOf(tasks []Task) completion {
+	var c completion
+	for _, task := range tasks {
+		if !m.taskCountsInStats(task) {
+			continue
+		}
+		c.total++
+		if task.Checked {
+			c.done++
+		}
+	}
+	return c
+}
+
+// statsSort returns the configured stats context order
+func (m Model) statsSort() string {
+	if m.settings.StatsSort == "" {
+		return "list"
+	}
+	return m.settings.StatsSort
+}
+
+// statsContexts returns the contexts that count in stats, ordered by
+// stats_sort so the ones needing attention can come first
+func (m Model) statsContexts() []string {
+	var contexts []string
+	stats := make(map[string]completion)
+	for _, context := range m.contexts {
+		if m.countsInStats(context) {
+			contexts = append(contexts, context)
+			stats[context] = m.completionOf(m.getTasksForContext(context))
+		}
+	}
+
+	switch m.statsSort() {
+	case "completion":
+		sort.SliceStable(contexts, func(i, j int) bool {
+			return stats[contexts[i]].rate() < stats[contexts[j]].rate()
+		})
+	case "open":
+		open := func(c completion) int { return c.total - c.done }
+		sort.SliceStable(contexts, func(i, j int) bool {
+			return open(stats[contexts[i]]) > open(stats[contexts[j]])
+		})
+	}
+	return contexts
+}
+
+// overTaskLimit reports whether a context has more open tasks than its soft
+// cap from task_limits or task_limit, along with both numbers
+func (m Model) overTaskLimit(context string) (int, int, bool) {
+	limit, ok := m.settings.TaskLimits[context]
+	if !ok {
+		limit = m.settings.TaskLimit
+	}
+	if limit <= 0 {
+		return 0, 0, false
+	}
+	open := 0
+	for _, task := range m.getTasksForContext(context) {
+		if !task.Checked && !task.Template {
+			open++
+		}
+	}
+	return open, limit, open > limit
+}
+
+// titleProgress summarizes completion for the header as configured by
+// title_progress, shortened to just the percentage on narrow terminals
+func (m Model) titleProgress() string {
+	var c completion
+	switch m.settings.TitleProgress {
+	case "context":
+		c = m.completionOf(m.getTasksUnderContext(m.currentContext))
+	case "overall":
+		c = m.completionOf(m.tasks)
+	default:
+		return ""
+	}
+	if c.total == 0 {
+		return ""
+	}
+	if m.windowWidth > 0 && m.windowWidth < 60 {
+		return fmt.Sprintf("%.0f%%", c.rate())
+	}
+	return fmt.Sprintf("%d/%d (%.0f%%)", c.done, c.total, c.rate())
+}
+
+// statsWeightBasis names the configured stats weighting, if any other than plain counts
+func (m Model) statsWeightBasis() (string, bool) {
+	switch m.settings.StatsWeight {
+	case "priority":
+		return "priority", true
+	case "estimate":
+		return "effort", true
+	}
+	return "", false
+}
+
+// taskWeight is how much a task counts toward weighted completion. Unset
+// priorities and estimates count as one, like a plain task count.
+func (m Model) taskWeight(task Task) float64 {
+	switch m.settings.StatsWeight {
+	case "priority":
+		return float64(len(priorityRank) - priorityRank[task.Priority])
+	case "estimate":
+		if task.Estimate > 0 {
+			return float64(task.Estimate)
+		}
+	}
+	return 1
+}
+
+// taskProgress is how far along a task is, from 0 to 1: done tasks count
+// fully and open ones by their percent done
+func taskProgress(task Task) float64 {
+	if task.Checked {
+		return 1
+	}
+	return float64(task.Percent) / 100
+}
+
+// partialCompletion returns the percentage done counting partial progress,
+// and whether any task that counts in stats has some
+func (m Model) partialCompletion(tasks []Task) (float64, bool) {
+	var total, done float64
+	partial := false
+	for _, task := range tasks {
+		if !m.taskCountsInStats(task) {
+			continue
+		}
+		total++
+		done += taskProgress(task)
+		partial = partial || (!task.Checked && task.Percent > 0)
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return done / total * 100, partial
+}
+
+// weightedCompletion returns the weighted percentage of completed tasks that
+// count in stats, giving open tasks credit for their percent done
+func (m Model) weightedCompletion(tasks []Task) float64 {
+	var total, done float64
+	for _, task := range tasks {
+		if !m.taskCountsInStats(task) {
+			continue
+		}
+		weight := m.taskWeight(task)
+		total += weight
+		done += weight * taskProgress(task)
+	}
+	if total == 0 {
+		return 0
+	}
+	return done / total * 100
+}
+
+// setStatus shows a transient message in the status line
+func (m *Model) setStatus(msg string) {
+	m.statusMessage = msg
+	m.statusID++
+	m.recordMessage(msg, false)
+}
+
+// message is a status or error message kept for the message history
+type message struct {
+	at   time.Time
+	text string
+	err  bool
+}
+
+// maxMessages is how many messages the history keeps
+const maxMessages = 50
+
+// recordMessage adds a message to the history, dropping the oldest past maxMessages
+func (m *Model) recordMessage(text string, err bool) {
+	m.messages = append(m.messages, message{at: time.Now(), text: text, err: err})
+	if len(m.messages) > maxMessages {
+		m.messages = m.messages[len(m.messages)-maxMessages:]
+	}
+}
+
+// expireError returns a command that clears the current error once it times out
+func (m Model) expireError() tea.Cmd {
+	timeout := defaultErrorTimeout
+	if m.settings.ErrorTimeout < 0 {
+		return nil
+	} else if m.settings.ErrorTimeout > 0 {
+		timeout = time.Duration(m.settings.ErrorTimeout) * time.Second
+	}
+
+	setAt := m.errorSetAt
+	return tea.Tick(timeout, func(time.Time) tea.Msg {
+		return errorExpiredMsg(setAt)
+	})
+}
+
+// expireStatus returns a command that clears the current status message after statusTTL
+func (m Model) expireStatus() tea.Cmd {
+	id := m.statusID
+	return tea.Tick(statusTTL, func(time.Time) tea.Msg {
+		return statusExpiredMsg(id)
+	})
+}
+
+// startAdd opens the add dialog. Search results, recent and today mix
+// contexts, so a task added there goes where add_from_search says: to a
+// context asked for first, to the inbox, or to the current context.
+func (m *Model) startAdd() {
+	m.addTarget = ""
+	m.followUp = false
+	if m.viewMode != SearchView {
+		m.showInputDialog(AddTaskInput, "Add new task:")
+		return
+	}
+
+	switch m.settings.AddFromSearch {
+	case "current":
+		m.showInputDialog(AddTaskInput, fmt.Sprintf("Add new task to %s:", m.currentContext))
+	case "inbox":
+		m.addTarget = m.settings.Inbox
+		if m.addTarget == "" {
+			m.addTarget = m.currentContext
+		}
+		m.showInputDialog(AddTaskInput, fmt.Sprintf("Add new task to %s:", m.addTarget))
+	default:
+		m.showInputDialog(AddTargetInput, "Add task to which context? (tab completes)")
+		m.textInput.ShowSuggestions = true
+		m.textInput.SetSuggestions(m.navigableContexts())
+	}
+}
+
+// startFollowUp opens the add dialog once ctrl+n has completed a task, filled in
+// with a reference to it. The follow-up goes to the same context and shares
+// the completion's undo step.
+func (m *Model) startFollowUp(task Task) {
+	m.addTarget = task.Context
+	m.followUp = true
+	m.showInputDialog(AddTaskInput, fmt.Sprintf("Done ✓ Follow-up task in %s:", task.Context))
+	m.textInput.SetValue("Follow up: " + firstLine(task.Task))
+	m.textInput.CursorEnd()
+}
+
+func (m *Model) showInputDialog(mode InputMode, prompt string) {
+	m.viewMode = InputView
+	m.inputMode = mode
+	m.inputPrompt = prompt
+	m.textInput.SetValue("")
+	m.textInput.ShowSuggestions = false
+	m.textInput.Focus()
+}
+
+// knownTags lists every tag in use, plus "key:" for each key of a key:value
+// tag, for tag completion
+func (m *Model) knownTags() []string {
+	seen := make(map[string]bool)
+	for _, task := range m.tasks {
+		for _, tag := range task.Tags {
+			seen[tag] = true
+			if key, value := splitTag(tag); value != "" {
+				seen[key+":"] = true
+			}
+		}
+	}
+
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// showTextArea opens the multi-line editor for the given input mode
+func (m *Model) showTextArea(mode InputMode, prompt, value string) {
+	m.viewMode = TextAreaView
+	m.inputMode = mode
+	m.inputPrompt = prompt
+	m.textArea.SetValue(value)
+	m.textArea.Focus()
+}
+
+// startCapture opens the add-task dialog for a quick capture session
+func (m *Model) startCapture(multi bool, context string) {
+	m.captureMode = true
+	m.captureMulti = multi
+	if context == "" {
+		context = m.settings.Inbox
+	}
+	if context != "" {
+		context = m.contextNamed(context)
+		if m.findContextIndex(context) < 0 {
+			m.contexts = append(m.contexts, context)
+		}
+		m.currentContext = context
+	}
+	m.showInputDialog(AddTaskInput, fmt.Sprintf("Capture task to %s:", m.currentContext))
+}
+
+// showDateInputDialog opens the due date dialog filled in with the task's
+// current due date, or today when it has none
+func (m *Model) showDateInputDialog() {
+	m.viewMode = DateInputView
+	m.dateInputIndex = 0
+	m.dateCalendar = false
+	m.dateRangeStart = ""
+	picked, hasTime, err := parseDueDate(m.getCurrentTask().DueDate)
+	if err != nil {
+		picked, hasTime = time.Now(), false
+	}
+	m.dateInputs[0].SetValue(fmt.Sprintf("%02d", picked.Day()))
+	m.dateInputs[1].SetValue(fmt.Sprintf("%02d", picked.Month()))
+	m.dateInputs[2].SetValue(fmt.Sprintf("%d", picked.Year()))
+	m.dateInputs[3].SetValue("")
+	m.dateInputs[4].SetValue("")
+	if hasTime {
+		m.dateInputs[3].SetValue(fmt.Sprintf("%02d", picked.Hour()))
+		m.dateInputs[4].SetValue(fmt.Sprintf("%02d", picked.Minute()))
+	}
+	for i := range m.dateInputs {
+		m.dateInputs[i].Focus()
+	}
+}
+
+// shiftPickedDate moves the date in the day/month/year fields by the given
+// number of days, starting from today when the fields don't hold a valid date
+func (m *Model) shiftPickedDate(days int) {
+	picked, err := time.ParseInLocation("2006-01-02", fmt.Sprintf("%s-%s-%s",
+		expandYear(m.dateInputs[2].Value()), padDateField(m.dateInputs[1].Value()), padDateField(m.dateInputs[0].Value())), time.Local)
+	if err != nil {
+		now := time.Now()
+		picked = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	}
+	picked = picked.AddDate(0, 0, days)
+	m.dateInputs[0].SetValue(fmt.Sprintf("%02d", picked.Day()))
+	m.dateInputs[1].SetValue(fmt.Sprintf("%02d", picked.Month()))
+	m.dateInputs[2].SetValue(fmt.Sprintf("%d", picked.Year()))
+}
+
+func (m *Model) showRemoveTagDialog() {
+	task := m.getCurrentTask()
+	if len(task.Tags) == 0 {
+		m.errorMessage = "No tags to remove"
+		return
+	}
+	m.viewMode = RemoveTagView
+	m.removeTagIndex = 0
+	m.removeTagChecks = make([]bool, len(task.Tags))
+	m.tagOrder = append([]string(nil), task.Tags...)
+}
+
+// openCurrentTaskURL opens the link in the selected task
+func (m *Model) openCurrentTaskURL() {
+	m.openTaskURL(m.getCurrentTask())
+}
+
+// openTaskURL opens the link or attachment in a task, asking which one if there are several
+func (m *Model) openTaskURL(task Task) {
+	urls := append(urlPattern.FindAllString(task.Task, -1), task.Attachments...)
+	switch len(urls) {
+	case 0:
+		m.setStatus("No link or attachment in this task")
+	case 1:
+		m.openURL(urls[0])
+	default:
+		m.viewMode = URLPickerView
+		m.urlChoices = urls
+		m.urlIndex = 0
+	}
+}
+
+func (m *Model) openURL(url string) {
+	if err := openWithOS(url); err != nil {
+		m.errorMessage = fmt.Sprintf("Could not open link: %v", err)
+		return
+	}
+	m.setStatus("Opened " + url)
+}
+
+// activeFilters names the filters currently narrowing the task list
+func (m *Model) activeFilters() []string {
+	var filters []string
+	if m.dueOnly {
+		filters = append(filters, "due only")
+	}
+	if m.extrasOnly {
+		filters = append(filters, "with notes/files")
+	}
+	if m.categoryFilter != "" {
+		filters = append(filters, "category "+m.categoryFilter)
+	}
+	return filters
+}
+
+// categoryNames lists the configured categories in a stable order
+func (m *Model) categoryNames() []string {
+	names := make([]string, 0, len(m.settings.Categories))
+	for name := range m.settings.Categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// categoryLabel renders a category name in its configured color
+func (m *Model) categoryLabel(name string) string {
+	style := lipgloss.NewStyle().Bold(true)
+	if color := m.settings.Categories[name]; color != "" {
+		style = style.Foreground(lipgloss.Color(color))
+	}
+	return style.Render("[" + name + "]")
+}
+
+func (m *Model) showCategoryPicker() {
+	if len(m.settings.Categories) == 0 {
+		m.errorMessage = "No categories configured; add \"categories\" to config.json"
+		return
+	}
+	m.viewMode = CategoryPickerView
+	m.categoryIndex = 0
+	current := m.getCurrentTask().Category
+	for i, name := range m.categoryNames() {
+		if name == current {
+			m.categoryIndex = i + 1
+		}
+	}
+}
+
+func (m *Model) setCategoryForCurrentTask(category string) {
+	task := m.getCurrentTask()
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Category = category
+			m.logEdit("category", m.tasks[i])
+			break
+		}
+	}
+}
+
+// cycleCategoryFilter steps the list filter through every category and back to none
+func (m *Model) cycleCategoryFilter() {
+	names := m.categoryNames()
+	if len(names) == 0 {
+		m.errorMessage = "No categories configured"
+		return
+	}
+	next := indexOf(names, m.categoryFilter) + 1
+	if next == len(names) {
+		m.categoryFilter = ""
+	} else {
+		m.categoryFilter = names[next]
+	}
+	m.selectedIndex = 0
+}
+
+func (m *Model) getFilteredTasks() []Task {
+	if m.viewMode == SearchView {
+		// Re-run the query so edits made from the results show up; the
+		// results kept from the search itself are left as they were
+		var results []Task
+		if m.recentView {
+			results = m.recentTasks()
+		} else if m.todayView {
+			results = m.todayTasks()
+		} else {
+			results = m.searchMatches()
+		}
+		if keys := m.viewSortKeys("search"); len(keys) > 0 {
+			m.sortInDirection(results, keys)
+		}
+		return results
+	}
+
+	tasks := m.getTasksUnderContext(m.currentContext)
+
+	if m.dueOnly || m.extrasOnly || m.categoryFilter != "" {
+		var matching []Task
+		for _, task := range tasks {
+			if m.matchesListFilters(task) {
+				matching = append(matching, task)
+			}
+		}
+		tasks = matching
+	}
+
+	// Configured sort keys; ties keep the manual order
+	if keys := m.viewSortKeys("normal"); len(keys) > 0 {
+		m.sortInDirection(tasks, keys)
+	}
+
+	// Completed tasks stay in place, sink to the bottom or disappear, or
+	// are summed up in one line until expanded
+	switch m.settings.OnComplete {
+	case "bottom":
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return !tasks[i].Checked && tasks[j].Checked
+		})
+	case "collapse":
+		if m.showCompleted {
+			sort.SliceStable(tasks, func(i, j int) bool {
+				return !tasks[i].Checked && tasks[j].Checked
+			})
+			break
+		}
+		fallthrough
+	case "hide":
+		var open []Task
+		for _, task := range tasks {
+			if !task.Checked {
+				open = append(open, task)
+			}
+		}
+		tasks = open
+	}
+
+	if m.settings.GroupByPriority {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return priorityGroups[priorityGroup(tasks[i])] < priorityGroups[priorityGroup(tasks[j])]
+		})
+	}
+
+	return m.nestSubtasks(tasks)
+}
+
+// matchesListFilters reports whether a task passes the due-only, notes/files and category filters
+func (m *Model) matchesListFilters(task Task) bool {
+	if m.dueOnly && task.DueDate == "" {
+		return false
+	}
+	if m.extrasOnly && task.Notes == "" && len(task.Attachments) == 0 {
+		return false
+	}
+	return m.categoryFilter == "" || task.Category == m.categoryFilter
+}
+
+// collapsedCompleted counts the completed tasks folded into the summary line
+// when on_complete is collapse, or 0 when they are listed
+func (m *Model) collapsedCompleted() int {
+	if m.settings.OnComplete != "collapse" || m.showCompleted || m.viewMode != NormalView {
+		return 0
+	}
+	n := 0
+	for _, task := range m.getTasksUnderContext(m.currentContext) {
+		if task.Checked && m.matchesListFilters(task) {
+			n++
+		}
+	}
+	return n
+}
+
+// countExtras counts the tasks with notes and those with attachments
+func countExtras(tasks []Task) (notes, attached int) {
+	for _, task := range tasks {
+		if task.Notes != "" {
+			notes++
+		}
+		if len(task.Attachments) > 0 {
+			attached++
+		}
+	}
+	return notes, attached
+}
+
+// priorityGroups orders the headings of the grouped-by-priority list
+var priorityGroups = map[string]int{"High": 0, "Medium": 1, "Low": 2, "None": 3, "Done": 4}
+
+// priorityGroup names the heading a task is listed under when grouping by priority
+func priorityGroup(task Task) string {
+	if task.Checked {
+		return "Done"
+	}
+	switch task.Priority {
+	case "high":
+		return "High"
+	case "medium":
+		return "Medium"
+	case "low":
+		return "Low"
+	}
+	return "None"
+}
+
+// nestSubtasks moves subtasks right below their parent, leaving out those
+// of collapsed parents. Subtasks whose parent isn't listed keep their place.
+func (m *Model) nestSubtasks(tasks []Task) []Task {
+	listed := make(map[int]bool, len(tasks))
+	children := make(map[int][]Task)
+	for _, task := range tasks {
+		listed[task.ID] = true
+	}
+	for _, task := range tasks {
+		if task.ParentID != 0 && listed[task.ParentID] {
+			children[task.ParentID] = append(children[task.ParentID], task)
+		}
+	}
+	if len(children) == 0 {
+		return tasks
+	}
+
+	nested := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		if task.ParentID != 0 && listed[task.ParentID] {
+			continue
+		}
+		nested = append(nested, task)
+		if !task.Collapsed {
+			nested = append(nested, children[task.ID]...)
+		}
+	}
+	return nested
+}
+
+// subtasksOf returns the subtasks of the task with the given ID
+func (m *Model) subtasksOf(id int) []Task {
+	var subtasks []Task
+	for _, task := range m.tasks {
+		if task.ParentID == id {
+			subtasks = append(subtasks, task)
+		}
+	}
+	return subtasks
+}
+
+// subtasksDone counts the completed tasks among subtasks
+func subtasksDone(subtasks []Task) int {
+	done := 0
+	for _, task := range subtasks {
+		if task.Checked {
+			done++
+		}
+	}
+	return done
+}
+
+// parentOf returns a subtask's parent, or the task itself for top-level tasks
+func (m *Model) parentOf(task Task) Task {
+	if task.ParentID != 0 {
+		for _, t := range m.tasks {
+			if t.ID == task.ParentID {
+				return t
+			}
+		}
+	}
+	return task
+}
+
+// addSubtask adds a subtask under the selected task, or under its parent
+// when a subtask is selected, expanding the parent to show it
+func (m *Model) addSubtask(text string) {
+	parent := m.parentOf(m.getCurrentTask())
+	m.addParent = parent
+	added := m.addTask(text)
+	m.addParent = Task{}
+	if !added {
+		return
+	}
+	if i := m.findTaskIndex(parent.ID); i >= 0 {
+		m.tasks[i].Collapsed = false
+	}
+	m.selectTask(m.nextID - 1)
+}
+
+// toggleFold collapses or expands the subtasks of the selected task's parent
+func (m *Model) toggleFold() {
+	parent := m.parentOf(m.getCurrentTask())
+	if len(m.subtasksOf(parent.ID)) == 0 {
+		m.errorMessage = "No subtasks to fold"
+		return
+	}
+	for i := range m.tasks {
+		if m.tasks[i].ID == parent.ID {
+			m.tasks[i].Collapsed = !m.tasks[i].Collapsed
+			break
+		}
+	}
+	m.selectTask(parent.ID)
+}
+
+// selectTask moves the selection onto the task with the given ID, if it is listed
+func (m *Model) selectTask(id int) {
+	for i, task := range m.getFilteredTasks() {
+		if task.ID == id {
+			m.selectedIndex = i
+			return
+		}
+	}
+}
+
+func (m *Model) getTasksForContext(context string) []Task {
+	var filtered []Task
+	for _, task := range m.tasks {
+		if task.Context == context {
+			filtered = append(filtered, task)
+		}
+	}
+	return filtered
+}
+
+// getTasksUnderContext returns the tasks of a context and of its
+// subcontexts, leaving out archived subcontexts
+func (m *Model) getTasksUnderContext(context string) []Task {
+	var filtered []Task
+	for _, task := range m.tasks {
+		if task.Context == context || (isSubcontext(task.Context, context) && !m.isArchived(task.Context)) {
+			filtered = append(filtered, task)
+		}
+	}
+	return filtered
+}
+
+func (m *Model) getCurrentTask() Task {
+	task, _ := m.currentTask()
+	return task
+}
+
+// currentTask returns the selected task, or false when the list is empty or
+// the selection points past its end
+func (m *Model) currentTask() (Task, bool) {
+	tasks := m.getFilteredTasks()
+	if m.selectedIndex < 0 || m.selectedIndex >= len(tasks) {
+		return Task{}, false
+	}
+	return tasks[m.selectedIndex], true
+}
+
+// requireTask reports whether there is a selected task to act on, explaining
+// why nothing happens when there isn't
+func (m *Model) requireTask() bool {
+	if _, ok := m.currentTask(); !ok {
+		m.errorMessage = "No task selected"
+		return false
+	}
+	return true
+}
+
+func (m *Model) moveUp() {
+	tasks := m.getFilteredTasks()
+	if len(tasks) > 0 {
+		if m.selectedIndex == 0 && !boolOr(m.settings.TaskWrap, true) {
+			return
+		}
+		m.selectedIndex = (m.selectedIndex - 1 + len(tasks)) % len(tasks)
+	}
+}
+
+func (m *Model) moveDown() {
+	tasks := m.getFilteredTasks()
+	if len(tasks) > 0 {
+		if m.selectedIndex >= len(tasks)-1 && !boolOr(m.settings.TaskWrap, true) {
+			return
+		}
+		m.selectedIndex = (m.selectedIndex + 1) % len(tasks)
+	}
+}
+
+// jumpToNextDue selects the next listed task that is overdue or due today,
+// wrapping around to the top
+func (m *Model) jumpToNextDue() {
+	tasks := m.getFilteredTasks()
+	for step := 1; step <= len(tasks); step++ {
+		i := (m.selectedIndex + step) % len(tasks)
+		if m.matchesDue(tasks[i], "now") {
+			m.selectedIndex = i
+			return
+		}
+	}
+	m.setStatus("No overdue or due-today tasks here")
+}
+
+// jumpToNextTagged selects the next task, in list order and wrapping around,
+// that shares the selected task's first tag, switching context if needed
+func (m *Model) jumpToNextTagged() {
+	current := m.getCurrentTask()
+	if len(current.Tags) == 0 {
+		m.errorMessage = "Task has no tags"
+		return
+	}
+	tag := current.Tags[0]
+
+	start := m.findTaskIndex(current.ID)
+	for step := 1; step < len(m.tasks); step++ {
+		task := m.tasks[(start+step)%len(m.tasks)]
+		if !hasTag(task.Tags, tag) || m.isArchived(task.Context) {
+			continue
+		}
+
+		// Stay put when the task is listed here, e.g. in a subcontext
+		context := m.currentContext
+		if task.Context != context && !isSubcontext(task.Context, context) {
+			context = task.Context
+		}
+		previous := m.currentContext
+		m.currentContext = context
+		if !m.lists(task.ID) {
+			m.currentContext = previous
+			continue
+		}
+		if context != previous {
+			m.currentContext = previous
+			if m.lockedOut() {
+				return
+			}
+			m.lastContext, m.currentContext = previous, context
+			m.contextChosen = true
+		}
+		m.selectTask(task.ID)
+		return
+	}
+	m.setStatus("No other tasks tagged " + tag)
+}
+
+// setMark marks the selected task with letter, replacing any task that had it
+func (m *Model) setMark(letter string) {
+	task := m.getCurrentTask()
+	if m.settings.Marks == nil {
+		m.settings.Marks = make(map[string]int)
+	}
+	m.settings.Marks[letter] = task.ID
+	m.dirty = true
+	m.setStatus(fmt.Sprintf("Marked '%s'; press ' %s to come back", letter, letter))
+}
+
+// jumpToMark selects the task marked with letter, switching context and
+// lifting list filters as needed to show it
+func (m *Model) jumpToMark(letter string) {
+	id, ok := m.settings.Marks[letter]
+	if !ok {
+		m.errorMessage = fmt.Sprintf("No mark '%s'", letter)
+		return
+	}
+	if m.findTaskIndex(id) < 0 {
+		delete(m.settings.Marks, letter)
+		m.errorMessage = fmt.Sprintf("The task marked '%s' is gone", letter)
+		return
+	}
+	if !m.revealTask(id) && m.errorMessage == "" {
+		m.errorMessage = fmt.Sprintf("The task marked '%s' is hidden in its list", letter)
+	}
+}
+
+// revealTask selects a task, switching to its context, leaving search and
+// lifting the list filters as needed. It reports whether the task is listed.
+func (m *Model) revealTask(id int) bool {
+	i := m.findTaskIndex(id)
+	if i < 0 {
+		return false
+	}
+	if m.lists(id) {
+		m.selectTask(id)
+		return true
+	}
+
+	task := m.tasks[i]
+	if task.Context != m.currentContext {
+		if m.lockedOut() {
+			return false
+		}
+		m.lastContext, m.currentContext = m.currentContext, task.Context
+		m.contextChosen = true
+	}
+	if m.viewMode == SearchView {
+		m.exitSearchMode()
+	}
+	if !m.lists(id) {
+		m.dueOnly = false
+		m.categoryFilter = ""
+		m.extrasOnly = false
+		m.showCompleted = true
+	}
+	if !m.lists(id) {
+		return false
+	}
+	m.selectTask(id)
+	return true
+}
+
+// pruneMarks forgets marks whose task was deleted
+func (m *Model) pruneMarks() {
+	for letter, id := range m.settings.Marks {
+		if m.findTaskIndex(id) < 0 {
+			delete(m.settings.Marks, letter)
+		}
+	}
+}
+
+// markPrompt lists the marks to pick from while a mark letter is awaited
+func (m Model) markPrompt() string {
+	letters := make([]string, 0, len(m.settings.Marks))
+	for letter := range m.settings.Marks {
+		letters = append(letters, letter)
+	}
+	sort.Strings(letters)
+
+	var choices []string
+	for _, letter := range letters {
+		if i := m.findTaskIndex(m.settings.Marks[letter]); i >= 0 {
+			choices = append(choices, fmt.Sprintf("%s %s", letter, truncateWidth(firstLine(m.tasks[i].Task), 20)))
+		}
+	}
+	if m.marking == "set" {
+		if len(choices) == 0 {
+			return "Mark with a letter (esc to cancel)"
+		}
+		return "Mark with a letter; taken: " + strings.Join(choices, " · ") + " (esc to cancel)"
+	}
+	return "Jump to: " + strings.Join(choices, " · ") + " (esc to cancel)"
+}
+
+// lists reports whether the task with the given ID is in the current list
+func (m *Model) lists(id int) bool {
+	for _, task := range m.getFilteredTasks() {
+		if task.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dropMovingTask moves the task picked up in move mode to the slot under the
+// cursor, placing it next to that slot's task in storage order
+func (m *Model) dropMovingTask() {
+	tasks := m.getFilteredTasks()
+	if m.movingTaskIndex >= len(tasks) || m.selectedIndex >= len(tasks) {
+		return
+	}
+	moving := tasks[m.movingTaskIndex]
+	anchor := tasks[m.selectedIndex]
+
+	var rest []Task
+	for _, task := range m.tasks {
+		if task.ID != moving.ID {
+			rest = append(rest, task)
+		}
+	}
+	for i, task := range rest {
+		if task.ID == anchor.ID {
+			// Moving up lands before the anchor, moving down lands after it
+			if m.selectedIndex > m.movingTaskIndex {
+				i++
+			}
+			rest = append(rest[:i], append([]Task{moving}, rest[i:]...)...)
+			m.logEdit("position", moving)
+			break
+		}
+	}
+	m.tasks = rest
+}
+
+// lockedOut reports, with an error, whether the focus lock forbids leaving the current context
+func (m *Model) lockedOut() bool {
+	if m.contextLocked {
+		m.errorMessage = "Context is locked (L to unlock)"
+	}
+	return m.contextLocked
+}
+
+func (m *Model) nextContext() {
+	if m.lockedOut() {
+		return
+	}
+	contexts := m.visibleContexts()
+	if len(contexts) > 0 {
+		nextIdx := 0
+		if currentIdx := indexOf(contexts, m.currentContext); currentIdx >= 0 {
+			nextIdx = currentIdx + 1
+			if nextIdx == len(contexts) {
+				if !boolOr(m.settings.ContextWrap, true) {
+					return
+				}
+				nextIdx = 0
+			}
+		}
+		m.lastContext = m.currentContext
+		m.currentContext = contexts[nextIdx]
+		m.selectedIndex = 0
+		m.tipIndex++
+		m.contextChosen = true
+	}
+}
+
+func (m *Model) previousContext() {
+	if m.lockedOut() {
+		return
+	}
+	contexts := m.visibleContexts()
+	if len(contexts) > 0 {
+		prevIdx := len(contexts) - 1
+		if currentIdx := indexOf(contexts, m.currentContext); currentIdx >= 0 {
+			prevIdx = currentIdx - 1
+			if prevIdx < 0 {
+				if !boolOr(m.settings.ContextWrap, true) {
+					return
+				}
+				prevIdx = len(contexts) - 1
+			}
+		}
+		m.lastContext = m.currentContext
+		m.currentContext = contexts[prevIdx]
+		m.selectedIndex = 0
+		m.tipIndex++
+		m.contextChosen = true
+	}
+}
+
+// switchToLastContext jumps back to the context used before the current one, so
+// pressing it again returns
+func (m *Model) switchToLastContext() {
+	if m.lockedOut() {
+		return
+	}
+	if m.lastContext == "" || m.lastContext == m.currentContext || m.findContextIndex(m.lastContext) < 0 {
+		m.errorMessage = "No previous context"
+		return
+	}
+	m.lastContext, m.currentContext = m.currentContext, m.lastContext
+	m.selectedIndex = 0
+	m.tipIndex++
+	m.contextChosen = true
+}
+
+// emptyContextMessage is shown in place of the task list when a context is
+// empty, cycling through the configured tips as contexts are switched
+func (m *Model) emptyContextMessage() string {
+	tips := m.settings.EmptyTips
+	if len(tips) == 0 {
+		return "No tasks in this context. Press 'a' to add one."
+	}
+	return tips[m.tipIndex%len(tips)]
+}
+
+// navigableContexts lists the contexts visited by left/right, leaving out the someday list
+func (m *Model) navigableContexts() []string {
+	var contexts []string
+	for _, ctx := range m.contexts {
+		if ctx != m.somedayContext() && ctx != m.doneContext() && !m.isArchived(ctx) {
+			contexts = append(contexts, ctx)
+		}
+	}
+	return contexts
+}
+
+// fileToContexts returns the contexts x followed by 1-9 moves a task to: the
+// first nine that can be navigated to, leaving out templates
+func (m *Model) fileToContexts() []string {
+	var contexts []string
+	for _, context := range m.navigableContexts() {
+		if !m.isTemplateContext(context) && len(contexts) < 9 {
+			contexts = append(contexts, context)
+		}
+	}
+	return contexts
+}
+
+// contextSeparator splits context names into a hierarchy, e.g. "Work/ProjectA"
+const contextSeparator = "/"
+
+// parentContext returns the context one level up, if any
+func parentContext(context string) (string, bool) {
+	i := strings.LastIndex(context, contextSeparator)
+	if i <= 0 {
+		return "", false
+	}
+	return context[:i], true
+}
+
+// isSubcontext reports whether context lies anywhere below ancestor
+func isSubcontext(context, ancestor string) bool {
+	return strings.HasPrefix(context, ancestor+contextSeparator)
+}
+
+// compareContextPaths orders contexts as a tree: level by level, so each
+// parent comes right before its subcontexts
+func compareContextPaths(a, b string) int {
+	as, bs := strings.Split(a, contextSeparator), strings.Split(b, contextSeparator)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// subcontextsOf lists the contexts below context, in list order
+func (m *Model) subcontextsOf(context string) []string {
+	var subcontexts []string
+	for _, ctx := range m.contexts {
+		if isSubcontext(ctx, context) {
+			subcontexts = append(subcontexts, ctx)
+		}
+	}
+	return subcontexts
+}
+
+// isFolded reports whether a context is hidden under a collapsed parent
+func (m *Model) isFolded(context string) bool {
+	for _, collapsed := range m.settings.CollapsedContexts {
+		if isSubcontext(context, collapsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleContexts are the navigable contexts not folded away under a
+// collapsed parent; these are the ones cycling visits
+func (m *Model) visibleContexts() []string {
+	var contexts []string
+	for _, ctx := range m.navigableContexts() {
+		if !m.isFolded(ctx) {
+			contexts = append(contexts, ctx)
+		}
+	}
+	return contexts
+}
+
+// foldContext collapses or expands the subcontexts of the current context,
+// or of its parent when the current context has none
+func (m *Model) foldContext() {
+	context := m.currentContext
+	if len(m.subcontextsOf(context)) == 0 {
+		parent, ok := parentContext(context)
+		if !ok {
+			m.errorMessage = "No subcontexts to fold"
+			return
+		}
+		context = parent
+	}
+
+	m.dirty = true
+	if i := indexOf(m.settings.CollapsedContexts, context); i >= 0 {
+		m.settings.CollapsedContexts = append(m.settings.CollapsedContexts[:i], m.settings.CollapsedContexts[i+1:]...)
+		m.setStatus(fmt.Sprintf("Expanded '%s'", context))
+		return
+	}
+	m.settings.CollapsedContexts = append(m.settings.CollapsedContexts, context)
+	if m.currentContext != context {
+		m.currentContext = context
+		m.selectedIndex = 0
+	}
+	m.setStatus(fmt.Sprintf("Collapsed '%s'", context))
+}
+
+func (m *Model) somedayContext() string {
+	if m.settings.Someday != "" {
+		return m.settings.Someday
+	}
+	return "Someday"
+}
+
+func (m *Model) doneContext() string {
+	if m.settings.DoneContext != "" {
+		return m.settings.DoneContext
+	}
+	return "Done"
+}
+
+// toggleSideList jumps to a list kept out of navigation, such as someday or
+// done, or back to where we came from
+func (m *Model) toggleSideList(list string) {
+	if m.lockedOut() {
+		return
+	}
+	m.contextChosen = true
+	m.lastContext = m.currentContext
+	if m.currentContext == list {
+		m.currentContext = m.sideReturn
+		m.updateContexts()
+		m.selectedIndex = 0
+		return
+	}
+
+	if m.currentContext != m.somedayContext() && m.currentContext != m.doneContext() {
+		m.sideReturn = m.currentContext
+	}
+	if m.findContextIndex(list) < 0 {
+		m.contexts = append(m.contexts, list)
+	}
+	m.currentContext = list
+	m.selectedIndex = 0
+}
+
+// sweepCompleted moves the completed tasks listed in this context, subcontexts
+// included, or in the search results, into the done list in one undo step. A parent goes once all its
+// subtasks are done, taking them along.
+func (m *Model) sweepCompleted() {
+	done := m.doneContext()
+	candidates := m.getTasksUnderContext(m.currentContext)
+	if m.viewMode == SearchView {
+		candidates = m.getFilteredTasks()
+	}
+	swept := make(map[int]bool)
+	for _, task := range candidates {
+		if !task.Checked || task.ParentID != 0 || task.Context == done {
+			continue
+		}
+		if subtasks := m.subtasksOf(task.ID); subtasksDone(subtasks) < len(subtasks) {
+			continue
+		}
+		swept[task.ID] = true
+	}
+	if len(swept) == 0 {
+		m.setStatus("No completed tasks to sweep")
+		return
+	}
+
+	m.saveStateForUndo()
+	for i := range m.tasks {
+		if swept[m.tasks[i].ID] || swept[m.tasks[i].ParentID] {
+			m.tasks[i].Context = done
+			m.logTask("moved", m.tasks[i])
+		}
+	}
+	m.updateContexts()
+	if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining {
+		m.selectedIndex = max(remaining-1, 0)
+	}
+	m.setStatus(fmt.Sprintf("Moved %d completed task(s) to '%s'", len(swept), done))
+}
+
+// statsLists are the kinds of task left out of stats unless stats_include
+// names them
+var statsLists = []string{"templates", "someday", "done", "archived"}
+
+// statsKind names the stats_include entry a context falls under, or "" for
+// an active context, which always counts
+func (m *Model) statsKind(context string) string {
+	switch {
+	case m.isTemplateContext(context):
+		return "templates"
+	case context == m.somedayContext():
+		return "someday"
+	case context == m.doneContext():
+		return "done"
+	case m.isArchived(context):
+		return "archived"
+	}
+	return ""
+}
+
+// countsInStats reports whether a context's tasks are part of completion statistics
+func (m *Model) countsInStats(context string) bool {
+	kind := m.statsKind(context)
+	return kind == "" || indexOf(m.settings.StatsInclude, kind) >= 0
+}
+
+// taskCountsInStats reports whether a task is part of completion statistics:
+// template tasks count only with templates included, wherever they live
+func (m *Model) taskCountsInStats(task Task) bool {
+	if task.Template && indexOf(m.settings.StatsInclude, "templates") < 0 {
+		return false
+	}
+	return m.countsInStats(task.Context)
+}
+
+func (m *Model) isArchived(context string) bool {
+	return indexOf(m.settings.Archived, context) >= 0
+}
+
+// archiveContext hides the current context and its tasks until restored
+func (m *Model) archiveContext() {
+	if len(m.navigableContexts()) <= 1 {
+		m.errorMessage = "Cannot archive the only context"
+		return
+	}
+
+	m.saveStateForUndo()
+	archived := m.currentContext
+	m.settings.Archived = append(m.settings.Archived, archived)
+	m.currentContext = ""
+	m.updateContexts()
+	m.selectedIndex = 0
+	m.setStatus(fmt.Sprintf("Archived '%s'", archived))
+}
+
+// restoreContext brings an archived context back into navigation and switches to it
+func (m *Model) restoreContext(context string) {
+	m.saveStateForUndo()
+	var archived []string
+	for _, ctx := range m.settings.Archived {
+		if ctx != context {
+			archived = append(archived, ctx)
+		}
+	}
+	m.settings.Archived = archived
+	if m.findContextIndex(context) < 0 {
+		m.contexts = append(m.contexts, context)
+	}
+	m.lastContext, m.currentContext = m.currentContext, context
+	m.contextChosen = true
+	m.updateContexts()
+	m.selectedIndex = 0
+	m.setStatus(fmt.Sprintf("Restored '%s'", context))
+}
+
+// moveCurrentTaskToContext refiles the selected task, creating the context if
+// needed. A parent takes its subtasks along; a subtask moved on its own
+// leaves its parent behind and becomes a task of its own.
+func (m *Model) moveCurrentTaskToContext(context string) {
+	task := m.getCurrentTask()
+	subtasks := 0
+	for i := range m.tasks {
+		switch {
+		case m.tasks[i].ID == task.ID:
+			m.tasks[i].Context = context
+			m.tasks[i].ParentID = 0
+		case m.tasks[i].ParentID == task.ID:
+			m.tasks[i].Context = context
+			subtasks++
+		default:
+			continue
+		}
+		m.logTask("moved", m.tasks[i])
+	}
+	m.updateContexts()
+
+	if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining && remaining > 0 {
+		m.selectedIndex = remaining - 1
+	}
+	if subtasks > 0 {
+		m.setStatus(fmt.Sprintf("Moved to '%s' with %d subtask(s)", context, subtasks))
+		return
+	}
+	m.setStatus(fmt.Sprintf("Moved to '%s'", context))
+}
+
+// boolOr returns the value of an optional setting, or def when unset
+func boolOr(p *bool, def bool) bool {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+// indexOf returns the position of s in list, or -1
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// findContextIndex returns the position of context in the list, or -1
+func (m *Model) findContextIndex(context string) int {
+	return indexOf(m.contexts, context)
+}
+
+// existingContext returns the context a new name would clash with: the same
+// name, or one differing only in case unless context_case is exact
+func (m *Model) existingContext(name string) (string, bool) {
+	if m.findContextIndex(name) >= 0 {
+		return name, true
+	}
+	if m.settings.ContextCase == "exact" {
+		return "", false
+	}
+	for _, ctx := range m.contexts {
+		if strings.EqualFold(ctx, name) {
+			return ctx, true
+		}
+	}
+	return "", false
+}
+
+// contextNamed returns the context a typed name refers to: an existing one
+// matching it, in any casing unless context_case is exact, or else the name
+// itself for a new context
+func (m *Model) contextNamed(name string) string {
+	if existing, ok := m.existingContext(name); ok {
+		return existing
+	}
+	return name
+}
+
+// findTaskIndex returns the position of the task with id in m.tasks, or -1
+func (m *Model) findTaskIndex(id int) int {
+	for i := range m.tasks {
+		if m.tasks[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// toggleCurrentTask flips the selected task and reports whether it was just completed
+func (m *Model) toggleCurrentTask() bool {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return false
+	}
+	completed := m.toggleTask(currentTask.ID)
+
+	// The task may have moved or vanished, so keep the selection in range
+	if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining && remaining > 0 {
+		m.selectedIndex = remaining - 1
+	}
+
+	return completed
+}
+
+// toggleTask flips a task and reports whether it was just completed. It is
+// the one way single tasks are completed from the UI, so every view behaves
+// alike: its subtasks are handled, setTaskChecked stamps it and takes care of
+// repeats, and on_complete decides where it is listed afterwards.
+func (m *Model) toggleTask(id int) bool {
+	i := m.findTaskIndex(id)
+	if i < 0 {
+		return false
+	}
+	task := m.tasks[i]
+	if task.Template {
+		m.errorMessage = fmt.Sprintf("Templates can't be completed; press %s to make a task from it", m.keyMap.SpawnTemplate.Help().Key)
+		return false
+	}
+
+	// Completing a parent may depend on or carry over to its subtasks
+	if !task.Checked {
+		if open := m.settleSubtasks(task.ID); open > 0 {
+			m.errorMessage = fmt.Sprintf("%d subtask(s) still open", open)
+			return false
+		}
+	}
+
+	completed := !task.Checked
+	m.setTaskChecked(task.ID, completed)
+	return completed
+}
+
+// viewComplete returns what toggling a task does in view, from view_complete
+func (m *Model) viewComplete(view string) string {
+	if complete := m.settings.ViewComplete[view]; complete != "" {
+		return complete
+	}
+	return "check"
+}
+
+// toggleTaskIn flips a task from view and reports whether it was just
+// completed. view_complete decides what that means there: check (the
+// default) only ticks it off, done also files it and its subtasks in
+// done_context, the way & sweeps, and off refuses. Reopening is the same
+// everywhere completing is allowed.
+func (m *Model) toggleTaskIn(view string, id int) bool {
+	complete := m.viewComplete(view)
+	if complete == "off" {
+		m.errorMessage = fmt.Sprintf("Tasks can't be completed in %s view (view_complete)", view)
+		return false
+	}
+	if !m.toggleTask(id) {
+		return false
+	}
+	if complete == "done" {
+		task := m.tasks[m.findTaskIndex(id)]
+		if task.ParentID == 0 && task.Context != m.doneContext() {
+			for i := range m.tasks {
+				if m.tasks[i].ID == id || m.tasks[i].ParentID == id {
+					m.tasks[i].Context = m.doneContext()
+					m.logTask("moved", m.tasks[i])
+				}
+			}
+			m.updateContexts()
+		}
+	}
+	return true
+}
+
+// toggleUndoable toggles a task from view as one undo step. A toggle that is
+// refused changes nothing, so it leaves no step behind and the list clean.
+func (m *Model) toggleUndoable(view string, id int) bool {
+	i := m.findTaskIndex(id)
+	if i < 0 {
+		return false
+	}
+	checked, dirty := m.tasks[i].Checked, m.dirty
+	m.saveStateForUndo()
+	completed := m.toggleTaskIn(view, id)
+	if i = m.findTaskIndex(id); i >= 0 && m.tasks[i].Checked == checked {
+		m.dropUndoState(dirty)
+	}
+	return completed
+}
+
+// toggleView names the view toggling happens in, for view_complete
+func (m *Model) toggleView() string {
+	switch {
+	case m.focusMode:
+		return "focus"
+	case m.viewMode == SearchView:
+		return "search"
+	}
+	return "normal"
+}
+
+// settleSubtasks applies subtask_completion to a parent about to be completed.
+// Under require it returns how many subtasks are still open, which holds the
+// parent back; under cascade (the default) it checks them off too.
+func (m *Model) settleSubtasks(id int) int {
+	subtasks := m.subtasksOf(id)
+	open := len(subtasks) - subtasksDone(subtasks)
+	switch m.settings.SubtaskCompletion {
+	case "independent":
+	case "require":
+		return open
+	default:
+		now := time.Now().Format(time.RFC3339)
+		for _, i := range m.batchOrder(func(task Task) bool {
+			return task.ParentID == id && !task.Checked
+		}) {
+			m.tasks[i].Checked = true
+			m.tasks[i].CompletedAt = now
+			m.logTask("completed", m.tasks[i])
+		}
+	}
+	return 0
+}
+
+// setTaskChecked completes or reopens one task: it is stamped, hooked and
+// logged, and completing a scheduled task counts: a copy made each period on
+// its source, a task with an "after" schedule on itself, which also adds its
+// next copy. Reopening takes both back.
+func (m *Model) setTaskChecked(id int, checked bool) {
+	i := m.findTaskIndex(id)
+	if i < 0 {
+		return
+	}
+	m.tasks[i].Checked = checked
+	m.tasks[i].CompletedAt = ""
+	if !checked && m.tasks[i].Percent == 100 {
+		// A reopened task isn't all done any more
+		m.tasks[i].Percent = 0
+	}
+	if checked {
+		m.tasks[i].CompletedAt = time.Now().Format(time.RFC3339)
+		m.logTask("completed", m.tasks[i])
+	} else {
+		m.logTask("reopened", m.tasks[i])
+	}
+
+	// A copy made each period counts on the task that has the schedule
+	if s := m.findTaskIndex(m.tasks[i].Source); s >= 0 && m.tasks[i].Schedule == "" && m.tasks[s].Schedule != "" && repeatAfter(m.tasks[s]) == "" {
+		if checked {
+			m.tasks[s].CompletionCount++
+		} else {
+			m.tasks[s].CompletionCount = max(m.tasks[s].CompletionCount-1, 0)
+		}
+	}
+
+	if checked {
+		if repeatAfter(m.tasks[i]) != "" {
+			m.tasks[i].CompletionCount++
+			m.spawnRepeat(id)
+		}
+		return
+	}
+	if m.handedOn(id) < 0 {
+		return
+	}
+	// The next copy was made with this count, so compare before taking it back
+	if m.settings.RepeatReopen != "keep" {
+		m.unspawnRepeat(id)
+	}
+	i = m.findTaskIndex(id)
+	m.tasks[i].CompletionCount = max(m.tasks[i].CompletionCount-1, 0)
+}
+
+// toggleAllInContext completes every task listed in the current context,
+// subcontexts included, or reopens them all when they are already done.
+// Parents follow subtask_completion as when completed one at a time. It
+// reports whether tasks were completed, and how many changed.
+func (m *Model) toggleAllInContext() (bool, int) {
+	listed := make(map[int]bool)
+	complete := false
+	for _, task := range m.getTasksUnderContext(m.currentContext) {
+		// Templates are canned tasks, never done
+		if task.Template {
+			continue
+		}
+		listed[task.ID] = true
+		complete = complete || !task.Checked
+	}
+
+	// Repeats add tasks as they go, so the batch is taken by ID up front.
+	// Subtasks go first, so under require a parent can follow its own.
+	var subtasks, others []int
+	for _, i := range m.batchOrder(func(task Task) bool {
+		return listed[task.ID] && task.Checked != complete
+	}) {
+		if m.tasks[i].ParentID != 0 {
+			subtasks = append(subtasks, m.tasks[i].ID)
+		} else {
+			others = append(others, m.tasks[i].ID)
+		}
+	}
+
+	count, held := 0, 0
+	for _, id := range append(subtasks, others...) {
+		i := m.findTaskIndex(id)
+		if i < 0 || m.tasks[i].Checked == complete {
+			continue // checked off with its parent already
+		}
+		if complete && m.settleSubtasks(id) > 0 {
+			held++
+			continue
+		}
+		m.setTaskChecked(id, complete)
+		count++
+	}
+
+	switch {
+	case !complete:
+		m.setStatus(fmt.Sprintf("Reopened %d task(s)", count))
+	case held > 0:
+		m.setStatus(fmt.Sprintf("Completed %d task(s); %d with open subtasks left", count, held))
+	default:
+		m.setStatus(fmt.Sprintf("Completed %d task(s)", count))
+	}
+	return complete, count
+}
+
+// defaultDue returns the due date context_defaults gives new tasks in
+// context, or "" when it sets no due_in_days
+func (m *Model) defaultDue(context string) string {
+	defaults, ok := m.settings.ContextDefaults[context]
+	if !ok || defaults.DueIn == nil {
+		return ""
+	}
+	return time.Now().AddDate(0, 0, *defaults.DueIn).Format(dueDateLayout)
+}
+
+// addTask adds a task to the current context, or where inbox rules route
+// it, and reports whether it was added
+func (m *Model) addTask(taskText string) bool {
+	taskText, quick := m.settings.QuickAdd.resolve().parse(m.normalizeTaskText(taskText), time.Now())
+	newTask := Task{
+		ID:        m.nextID,
+		Task:      taskText,
+		Checked:   false,
+		Context:   m.currentContext,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if m.addTarget != "" {
+		newTask.Context = m.addTarget
+	}
+	if quick.Context != "" {
+		newTask.Context = m.contextNamed(quick.Context)
+	}
+
+	if defaults, ok := m.settings.ContextDefaults[newTask.Context]; ok {
+		newTask.Tags = append([]string(nil), defaults.Tags...)
+		newTask.Priority = defaults.Priority
+		newTask.DueDate = m.defaultDue(newTask.Context)
+	}
+
+	// A task routed out of the inbox takes its new context's due offset
+	routed := m.routeInboxTask(&newTask)
+	if routed && newTask.DueDate == "" {
+		newTask.DueDate = m.defaultDue(newTask.Context)
+	}
+	// A subtask stays with its parent, wherever the text or inbox rules point
+	if m.addParent.ID != 0 {
+		newTask.ParentID, newTask.Context = m.addParent.ID, m.addParent.Context
+	}
+	routed = routed || newTask.Context != m.currentContext
+
+	// Quick-add words win over defaults and inbox rules
+	for _, tag := range quick.Tags {
+		if indexOf(newTask.Tags, tag) < 0 {
+			newTask.Tags = append(newTask.Tags, tag)
+		}
+	}
+	if quick.Priority != "" {
+		newTask.Priority = quick.Priority
+	}
+	if quick.DueDate != "" {
+		newTask.DueDate = quick.DueDate
+	}
+
+	duplicate := m.settings.Duplicates != "" && m.settings.Duplicates != "allow" && m.hasOpenTask(newTask.Context, taskText)
+	if duplicate && m.settings.Duplicates == "block" {
+		m.errorMessage = fmt.Sprintf("Already in %s: %s", newTask.Context, firstLine(taskText))
+		return false
+	}
+
+	// Keep the new task visible under active filters: either give it the
+	// filtered category, or drop the filters altogether
+	if m.settings.AddFiltered == "clear" {
+		m.dueOnly = false
+		m.categoryFilter = ""
+	} else {
+		newTask.Category = m.categoryFilter
+		// A due date can't be guessed, so the due-only filter is lifted
+		m.dueOnly = false
+	}
+	m.extrasOnly = false
+
+	// New tasks go to the end, or ahead of the first task in their context
+	insertAt := len(m.tasks)
+	if m.settings.AddPosition == "top" {
+		for i, task := range m.tasks {
+			if task.Context == newTask.Context {
+				insertAt = i
+				break
+			}
+		}
+	}
+	newTask.Code = m.nextCode(newTask.Context)
+	m.tasks = append(m.tasks[:insertAt], append([]Task{newTask}, m.tasks[insertAt:]...)...)
+	m.nextID++
+	
+	// Move selection to new task
+	filtered := m.getFilteredTasks()
+	m.selectedIndex = max(len(filtered)-1, 0)
+	for i, task := range filtered {
+		if task.ID == newTask.ID {
+			m.selectedIndex = i
+		}
+	}
+	if routed {
+		m.updateContexts()
+		m.setStatus(fmt.Sprintf("Task added to %s", newTask.Context))
+	} else {
+		m.setStatus("Task added")
+	}
+	if duplicate {
+		m.errorMessage = "Added, but the same task is already open here"
+	}
+	m.logTask("created", newTask)
+	return true
+}
+
+// nextCode hands out the next short code for a task added to context, or ""
+// when neither it nor a parent context has a code prefix. Codes follow the
+// prefix's own counter, so they stay put when a task moves on
+func (m *Model) nextCode(context string) string {
+	prefix, ok := m.settings.CodePrefixes[context]
+	for !ok {
+		if context, ok = parentContext(context); !ok {
+			return ""
+		}
+		prefix, ok = m.settings.CodePrefixes[context]
+	}
+	if prefix == "" {
+		return ""
+	}
+	if m.settings.CodeCounters == nil {
+		m.settings.CodeCounters = make(map[string]int)
+	}
+	m.settings.CodeCounters[prefix]++
+	return fmt.Sprintf("%s-%d", prefix, m.settings.CodeCounters[prefix])
+}
+
+// findTaskByRef returns the index of the task a command line refers to, or
+// -1. A number (with or without #) is always taken as an ID; anything else
+// is matched against short codes, ignoring case. Codes always carry their
+// prefix, so the two never compete for the same reference.
+func (m *Model) findTaskByRef(ref string) int {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "#")
+	if id, err := strconv.Atoi(ref); err == nil {
+		return m.findTaskIndex(id)
+	}
+	for i, task := range m.tasks {
+		if task.Code != "" && strings.EqualFold(task.Code, ref) {
+			return i
+		}
+	}
+	return -1
+}
+
+// taskRef is how a task is named to the user: its short code if it has one,
+// else its numeric ID
+func taskRef(task Task) string {
+	if task.Code != "" {
+		return task.Code
+	}
+	return fmt.Sprintf("#%d", task.ID)
+}
+
+// hasOpenTask reports whether an open task in context has the same text,
+// ignoring case and spacing
+func (m *Model) hasOpenTask(context, text string) bool {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	}
+	want := normalize(text)
+	for _, task := range m.tasks {
+		if task.Context == context && !task.Checked && normalize(task.Task) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// routeInboxTask applies the first inbox rule whose keyword appears in a
+// task added to the inbox, reporting whether the task left the inbox
+func (m *Model) routeInboxTask(task *Task) bool {
+	if m.settings.Inbox == "" || task.Context != m.settings.Inbox {
+		return false
+	}
+
+	text := strings.ToLower(task.Task)
+	for _, rule := range m.settings.InboxRules {
+		if rule.Keyword == "" || !strings.Contains(text, strings.ToLower(rule.Keyword)) {
+			continue
+		}
+		for _, tag := range rule.Tags {
+			if indexOf(task.Tags, tag) < 0 {
+				task.Tags = append(task.Tags, tag)
+			}
+		}
+		if rule.Priority != "" {
+			task.Priority = rule.Priority
+		}
+		if rule.Context != "" {
+			task.Context = rule.Context
+		}
+		return task.Context != m.settings.Inbox
+	}
+	return false
+}
+
+// firstLine returns the first line of text, marking that more follows
+func firstLine(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[:i] + " ⏎"
+	}
+	return text
+}
+
+// attachToCurrentTask stores a file path on the selected task, warning when
+// the file can't be found
+func (m *Model) attachToCurrentTask(path string) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	task := m.getCurrentTask()
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Attachments = append(m.tasks[i].Attachments, path)
+			m.logEdit("attachments", m.tasks[i])
+			break
+		}
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		m.errorMessage = fmt.Sprintf("Attached, but %s does not exist", path)
+		return
+	}
+	m.setStatus("Attached " + filepath.Base(path))
+}
+
+func (m *Model) setNotesForCurrentTask(notes string) {
+	task := m.getCurrentTask()
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Notes = notes
+			m.logEdit("notes", m.tasks[i])
+			break
+		}
+	}
+}
+
+func (m *Model) setEstimateForCurrentTask(points int) {
+	task := m.getCurrentTask()
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Estimate = points
+			m.logEdit("estimate", m.tasks[i])
+			break
+		}
+	}
+}
+
+// parsePercent reads a percent-done value, either absolute or as +N/-N
+// relative to current, clamped to 0-100. Empty clears it.
+func parsePercent(input string, current int) (int, bool) {
+	if input == "" {
+		return 0, true
+	}
+	relative := strings.HasPrefix(input, "+") || strings.HasPrefix(input, "-")
+	n, err := strconv.Atoi(strings.TrimSuffix(input, "%"))
+	if err != nil {
+		return 0, false
+	}
+	if relative {
+		n += current
+	} else if n < 0 || n > 100 {
+		return 0, false
+	}
+	return min(max(n, 0), 100), true
+}
+
+// setPercentForCurrentTask records partial progress. Reaching 100 completes
+// the task and dropping below it reopens a completed one.
+func (m *Model) setPercentForCurrentTask(percent int) {
+	task := m.getCurrentTask()
+	i := m.findTaskIndex(task.ID)
+	if i < 0 {
+		return
+	}
+	m.tasks[i].Percent = percent
+	if percent == 100 && !task.Checked || percent > 0 && percent < 100 && task.Checked {
+		if m.toggleCurrentTask() {
+			m.setStatus("Task completed ✓")
+		}
+	}
+
+	// A completion refused under subtask_completion=require leaves the progress as it was
+	i = m.findTaskIndex(task.ID)
+	if m.tasks[i].Percent == 100 && !m.tasks[i].Checked {
+		m.tasks[i].Percent = task.Percent
+		return
+	}
+	m.logEdit("percent", m.tasks[i])
+}
+
+// percentBar renders partial progress as a five-cell bar
+func percentBar(percent int) string {
+	filled := percent / 20
+	return strings.Repeat("▰", filled) + strings.Repeat("▱", 5-filled) + fmt.Sprintf(" %d%%", percent)
+}
+
+// setScheduleForCurrentTask makes the selected task repeat on a schedule. The
+// task itself counts as this period's copy; an "after" schedule has no
+// periods and makes its next copy when the task is completed.
+func (m *Model) setScheduleForCurrentTask(schedule string) {
+	task := m.getCurrentTask()
+	period, _ := schedulePeriod(schedule, time.Now(), m.firstWeekday())
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Schedule = schedule
+			m.tasks[i].Generated = period
+			m.logEdit("schedule", m.tasks[i])
+			break
+		}
+	}
+}
+
+func (m *Model) setRefForCurrentTask(ref string) {
+	task := m.getCurrentTask()
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Ref = ref
+			m.logEdit("ref", m.tasks[i])
+			break
+		}
+	}
+}
+
+func (m *Model) editCurrentTask(newText string) {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	newText = m.normalizeTaskText(newText)
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			m.tasks[i].Task = newText
+			m.logEdit("task", m.tasks[i])
+			break
+		}
+	}
+}
+
+// spaceRuns matches two or more spaces or tabs after other text, so the
+// indentation of multi-line tasks is left alone
+var spaceRuns = regexp.MustCompile(`([^ \t\n])[ \t]{2,}`)
+
+// normalizeTaskText tidies task text as set by collapse_spaces and
+// capitalize; with both off the text is kept as typed
+func (m *Model) normalizeTaskText(text string) string {
+	if m.settings.CollapseSpaces {
+		text = spaceRuns.ReplaceAllString(text, "$1 ")
+	}
+	if m.settings.Capitalize {
+		if r, size := utf8.DecodeRuneInString(text); unicode.IsLower(r) {
+			text = string(unicode.ToUpper(r)) + text[size:]
+		}
+	}
+	return text
+}
+
+func (m *Model) deleteCurrentTask() {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	// Subtasks go along with their parent
+	var kept []Task
+	for _, task := range m.tasks {
+		if task.ID != currentTask.ID && task.ParentID != currentTask.ID {
+			kept = append(kept, task)
+		} else {
+			m.logTask("deleted", task)
+		}
+	}
+	m.tasks = kept
+
+	// Adjust selection
+	newTasks := m.getFilteredTasks()
+	if m.selectedIndex >= len(newTasks) && len(newTasks) > 0 {
+		m.selectedIndex = len(newTasks) - 1
+	}
+	if len(newTasks) == 0 {
+		m.selectedIndex = 0
+		if m.settings.EmptiedContext == "next" && m.viewMode == NormalView && m.nextContextWithTasks() {
+			m.setStatus(fmt.Sprintf("Task deleted, on to '%s'", m.currentContext))
+			return
+		}
+	}
+	m.setStatus("Task deleted")
+}
+
+// nextContextWithTasks moves on to the next visible context that has tasks
+// listed, wrapping around, and reports whether there was one
+func (m *Model) nextContextWithTasks() bool {
+	if m.contextLocked {
+		return false
+	}
+	contexts := m.visibleContexts()
+	start := indexOf(contexts, m.currentContext)
+	for step := 1; step < len(contexts); step++ {
+		context := contexts[(start+step+len(contexts))%len(contexts)]
+		if len(m.getTasksUnderContext(context)) > 0 {
+			m.lastContext, m.currentContext = m.currentContext, context
+			m.contextChosen = true
+			m.selectedIndex = 0
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Model) isTemplateContext(context string) bool {
+	for _, ctx := range m.settings.Templates {
+		if ctx == context {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleTemplateContext marks or unmarks the current context as a template
+func (m *Model) toggleTemplateContext() {
+	m.dirty = true
+	if m.isTemplateContext(m.currentContext) {
+		var templates []string
+		for _, ctx := range m.settings.Templates {
+			if ctx != m.currentContext {
+				templates = append(templates, ctx)
+			}
+		}
+		m.settings.Templates = templates
+		m.setStatus(fmt.Sprintf("'%s' is no longer a template", m.currentContext))
+		return
+	}
+	m.settings.Templates = append(m.settings.Templates, m.currentContext)
+	m.setStatus(fmt.Sprintf("'%s' is now a template", m.currentContext))
+}
+
+// toggleTemplateTask marks or unmarks the selected task as a template
+func (m *Model) toggleTemplateTask() {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	i := m.findTaskIndex(currentTask.ID)
+	m.tasks[i].Template = !m.tasks[i].Template
+	m.logEdit("template", m.tasks[i])
+	if m.tasks[i].Template {
+		m.setStatus("Task is now a template; press I to use it")
+	} else {
+		m.setStatus("Task is no longer a template")
+	}
+}
+
+// instantiateTemplate adds a real task copied from a template task's text,
+// tags and priority, right after the template
+func (m *Model) instantiateTemplate(template Task) {
+	task := Task{
+		ID:        m.nextID,
+		Task:      template.Task,
+		Context:   template.Context,
+		Priority:  template.Priority,
+		Tags:      append([]string(nil), template.Tags...),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Code:      m.nextCode(template.Context),
+	}
+	m.nextID++
+
+	insertAt := m.findTaskIndex(template.ID) + 1
+	m.tasks = append(m.tasks[:insertAt], append([]Task{task}, m.tasks[insertAt:]...)...)
+	m.logTask("created", task)
+	m.selectTask(task.ID)
+	m.setStatus("Added from template: " + firstLine(task.Task))
+}
+
+// spawnTemplate copies every task of the current template context into target
+// as fresh, unchecked tasks
+func (m *Model) spawnTemplate(target string) {
+	if m.isTemplateContext(target) {
+		m.errorMessage = "Cannot copy a template into another template"
+		return
+	}
+
+	m.cloneTasks(m.currentContext, target, 0)
+	source := m.currentContext
+	m.updateContexts()
+	m.lastContext, m.currentContext = source, target
+	m.contextChosen = true
+	m.selectedIndex = 0
+	m.setStatus(fmt.Sprintf("Copied template '%s' into '%s'", source, target))
+}
+
+// cloneTasks copies the tasks of source into target as fresh open tasks with
+// new IDs, keeping subtasks under their copied parents and moving due and
+// start dates by shift days. It returns how many were copied.
+func (m *Model) cloneTasks(source, target string, shift int) int {
+	tasks := m.getTasksForContext(source)
+	ids := make(map[int]int, len(tasks))
+	for _, task := range tasks {
+		ids[task.ID] = m.nextID
+		m.nextID++
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	for _, task := range tasks {
+		task.ID = ids[task.ID]
+		task.ParentID = ids[task.ParentID]
+		task.Context = target
+		task.Checked = false
+		task.CompletedAt = ""
+		task.Template = false
+		task.Today = false
+		task.Percent = 0
+		task.Source = 0
+		task.CompletionCount = 0
+		task.Tags = append([]string(nil), task.Tags...)
+		task.Attachments = append([]string(nil), task.Attachments...)
+		task.CreatedAt = now
+		task.DueDate = shiftDueDate(task.DueDate, shift)
+		task.StartDate = shiftDueDate(task.StartDate, shift)
+		task.Code = m.nextCode(target)
+		m.tasks = append(m.tasks, task)
+		m.logTask("created", task)
+	}
+	return len(tasks)
+}
+
+// cloneContext copies the current context's tasks into target, new or
+// existing, and switches to it
+func (m *Model) cloneContext(target string, shift int) {
+	source := m.currentContext
+	count := m.cloneTasks(source, target, shift)
+	m.updateContexts()
+	m.lastContext, m.currentContext = source, target
+	m.contextChosen = true
+	m.selectedIndex = 0
+	status := fmt.Sprintf("Cloned %d task(s) from '%s' into '%s'", count, source, target)
+	if shift != 0 {
+		status += fmt.Sprintf(", dates moved %+d days", shift)
+	}
+	m.setStatus(status)
+}
+
+// parseCloneTarget splits the clone dialog's input into the target context
+// and an optional trailing day shift such as +7 or -3
+func parseCloneTarget(input string) (string, int, error) {
+	fields := strings.Fields(input)
+	shift := 0
+	if n := len(fields); n > 1 && strings.ContainsAny(fields[n-1][:1], "+-") {
+		days, err := strconv.Atoi(strings.TrimSuffix(fields[n-1], "d"))
+		if err != nil {
+			return "", 0, fmt.Errorf("Invalid day shift %q (use e.g. +7 or -3)", fields[n-1])
+		}
+		shift = days
+		fields = fields[:n-1]
+	}
+	if len(fields) == 0 {
+		return "", 0, errors.New("Enter a context to clone into")
+	}
+	return strings.Join(fields, " "), shift, nil
+}
+
+func (m *Model) addContext(contextName string) {
+	// Check if context already exists, in any casing unless context_case is exact
+	if existing, ok := m.existingContext(contextName); ok {
+		switch {
+		case existing == contextName:
+			m.errorMessage = "Context already exists"
+		case m.settings.ContextCase == "refuse":
+			m.errorMessage = fmt.Sprintf("Context '%s' already exists", existing)
+		default:
+			m.lastContext, m.currentContext = m.currentContext, existing
+			m.contextChosen = true
+			m.selectedIndex = 0
+			m.setStatus(fmt.Sprintf("Switched to existing context '%s'", existing))
+		}
+		return
+	}
+
+	m.contexts = append(m.contexts, contextName)
+	m.lastContext, m.currentContext = m.currentContext, contextName
+	m.contextChosen = true
+	m.selectedIndex = 0
+	m.setStatus(fmt.Sprintf("Created context '%s'", contextName))
+}
+
+// renameOrMergeContext renames the current context, or handles a name that is
+// already taken as configured by rename_collision. It reports whether it is
+// now asking for confirmation.
+func (m *Model) renameOrMergeContext(newName string) bool {
+	// Changing only the case of the current name is a plain rename
+	existing, ok := m.existingContext(newName)
+	if !ok || existing == m.currentContext {
+		m.saveStateForUndo()
+		m.renameContext(newName)
+		return false
+	}
+	if existing != newName && m.settings.ContextCase == "refuse" {
+		m.errorMessage = fmt.Sprintf("Context '%s' already exists", existing)
+		return false
+	}
+	newName = existing
+
+	switch m.settings.RenameCollision {
+	case "refuse":
+		m.errorMessage = "Context name already exists"
+	case "merge":
+		m.saveStateForUndo()
+		m.mergeContext(m.currentContext, newName)
+	default:
+		m.mergeTarget = newName
+		m.showInputDialog(MergeConfirmInput, fmt.Sprintf("'%s' already exists. Merge '%s' into it? (y/n):", newName, m.currentContext))
+		return true
+	}
+	return false
+}
+
+func (m *Model) renameContext(newName string) {
+	if newName == m.currentContext {
+		return
+	}
+
+	// Check if new name already exists
+	if existing, ok := m.existingContext(newName); ok && existing != m.currentContext {
+		m.errorMessage = "Context name already exists"
+		return
+	}
+
+	oldName := m.currentContext
+
+	// Update context in contexts list
+	for i, ctx := range m.contexts {
+		if ctx == oldName {
+			m.contexts[i] = newName
+			break
+		}
+	}
+
+	// Update context in all tasks; subcontexts move along
+	for i := range m.tasks {
+		if m.tasks[i].Context == oldName {
+			m.tasks[i].Context = newName
+			m.logEdit("context", m.tasks[i])
+		} else if isSubcontext(m.tasks[i].Context, oldName) {
+			m.tasks[i].Context = newName + strings.TrimPrefix(m.tasks[i].Context, oldName)
+			m.logEdit("context", m.tasks[i])
+		}
+	}
+	for i, ctx := range m.contexts {
+		if isSubcontext(ctx, oldName) {
+			m.contexts[i] = newName + strings.TrimPrefix(ctx, oldName)
+		}
+	}
+
+	// Carry the context's settings over to the new name, and its subcontexts' too
+	renamed := func(ctx string) string {
+		if ctx == oldName || isSubcontext(ctx, oldName) {
+			return newName + strings.TrimPrefix(ctx, oldName)
+		}
+		return ctx
+	}
+	for _, list := range [][]string{m.settings.Templates, m.settings.CollapsedContexts, m.settings.Archived, m.settings.FoldedScratchpads} {
+		for i := range list {
+			list[i] = renamed(list[i])
+		}
+	}
+	pads := make(map[string]string, len(m.settings.Scratchpads))
+	for ctx, pad := range m.settings.Scratchpads {
+		pads[renamed(ctx)] = pad
+	}
+	if len(pads) > 0 {
+		m.settings.Scratchpads = pads
+	}
+
+	m.currentContext = newName
+	m.setStatus(fmt.Sprintf("Renamed '%s' to '%s'", oldName, newName))
+}
+
+// mergeContext moves every task of one context into another and drops the
+// emptied context
+func (m *Model) mergeContext(from, into string) {
+	moved := 0
+	for i := range m.tasks {
+		if m.tasks[i].Context == from {
+			m.tasks[i].Context = into
+			m.logEdit("context", m.tasks[i])
+			moved++
+		}
+	}
+
+	if i := indexOf(m.contexts, from); i >= 0 {
+		m.contexts = append(m.contexts[:i], m.contexts[i+1:]...)
+	}
+
+	// The scratchpads are joined, the target's first
+	if pad := m.settings.Scratchpads[from]; pad != "" {
+		if existing := m.settings.Scratchpads[into]; existing != "" {
+			pad = existing + "\n\n" + pad
+		}
+		m.setScratchpad(into, pad)
+	}
+	m.setScratchpad(from, "")
+
+	m.currentContext = into
+	m.contextChosen = true
+	m.selectedIndex = 0
+	m.setStatus(fmt.Sprintf("Merged %d task(s) from '%s' into '%s'", moved, from, into))
+}
+
+func (m *Model) deleteContext() {
+	deleted := func(ctx string) bool {
+		return ctx == m.currentContext || isSubcontext(ctx, m.currentContext)
+	}
+	if len(m.contexts) <= 1+len(m.subcontextsOf(m.currentContext)) {
+		m.errorMessage = "Cannot delete the only context"
+		return
+	}
+
+	// Remove all tasks in this context and its subcontexts, which would
+	// otherwise bring it straight back as their parent
+	var newTasks []Task
+	for _, task := range m.tasks {
+		if !deleted(task.Context) {
+			newTasks = append(newTasks, task)
+		} else {
+			m.logTask("deleted", task)
+		}
+	}
+	m.tasks = newTasks
+
+	// Remove the contexts from the list, along with their settings
+	var newContexts []string
+	for _, ctx := range m.contexts {
+		if !deleted(ctx) {
+			newContexts = append(newContexts, ctx)
+			continue
+		}
+		m.setScratchpad(ctx, "")
+	}
+	m.contexts = newContexts
+	m.settings.Templates = removeMatching(m.settings.Templates, deleted)
+	m.settings.CollapsedContexts = removeMatching(m.settings.CollapsedContexts, deleted)
+	m.settings.Archived = removeMatching(m.settings.Archived, deleted)
+
+	// Switch to first remaining context
+	m.currentContext = ""
+	m.updateContexts()
+	m.selectedIndex = 0
+}
+
+// removeMatching returns list without the items match picks
+func removeMatching(list []string, match func(string) bool) []string {
+	var kept []string
+	for _, item := range list {
+		if !match(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// setScratchpad sets a context's scratchpad; empty text removes it
+func (m *Model) setScratchpad(context, text string) {
+	if text == "" {
+		delete(m.settings.Scratchpads, context)
+		if i := indexOf(m.settings.FoldedScratchpads, context); i >= 0 {
+			m.settings.FoldedScratchpads = append(m.settings.FoldedScratchpads[:i], m.settings.FoldedScratchpads[i+1:]...)
+		}
+		return
+	}
+	if m.settings.Scratchpads == nil {
+		m.settings.Scratchpads = make(map[string]string)
+	}
+	m.settings.Scratchpads[context] = text
+}
+
+// renderScratchpad renders the current context's scratchpad above its tasks:
+// in full, or its first line when folded
+func (m Model) renderScratchpad() string {
+	pad := m.settings.Scratchpads[m.currentContext]
+	if indexOf(m.settings.FoldedScratchpads, m.currentContext) >= 0 {
+		line := "📌 " + firstLine(pad) + " (^ to unfold)"
+		if width := m.detailWidth(); width > 0 {
+			line = truncateWidth(line, width)
+		}
+		return helpStyle.Render(line)
+	}
+	style := scratchpadStyle
+	if width := m.detailWidth(); width > 0 {
+		style = style.Width(width - style.GetHorizontalBorderSize())
+	}
+	return style.Render(pad)
+}
+
+// toggleCurrentTaskPriority steps the priority through none, low, medium and
+// high, wrapping around; step is 1 to raise it or -1 to lower it
+func (m *Model) toggleCurrentTaskPriority(step int) {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			priorities := []string{"", "low", "medium", "high"}
+			currentIdx := 0
+			for j, p := range priorities {
+				if p == m.tasks[i].Priority {
+					currentIdx = j
+					break
+				}
+			}
+			nextIdx := (currentIdx + step + len(priorities)) % len(priorities)
+			m.tasks[i].Priority = priorities[nextIdx]
+			m.logEdit("priority", m.tasks[i])
+			break
+		}
+	}
+}
+
+// quickPriorities maps the SetPriority keys to the priority they set
+var quickPriorities = map[string]string{"1": "high", "2": "medium", "3": "low", "0": ""}
+
+// setCurrentTaskPriority sets the selected task's priority outright
+func (m *Model) setCurrentTaskPriority(priority string) {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			m.tasks[i].Priority = priority
+			m.logEdit("priority", m.tasks[i])
+			break
+		}
+	}
+	if priority == "" {
+		priority = "none"
+	}
+	m.setStatus("Priority: " + priority)
+}
+
+func (m *Model) addTagToCurrentTask(tag string) {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			// Check if tag already exists
+			for _, existingTag := range m.tasks[i].Tags {
+				if existingTag == tag {
+					return
+				}
+			}
+
+			// A key holds one value, so key:value replaces the old value
+			if key, value := splitTag(tag); value != "" {
+				for j, existingTag := range m.tasks[i].Tags {
+					if existingKey, existingValue := splitTag(existingTag); existingValue != "" && existingKey == key {
+						m.tasks[i].Tags[j] = tag
+						m.logEdit("tags", m.tasks[i])
+						return
+					}
+				}
+			}
+			m.tasks[i].Tags = append(m.tasks[i].Tags, tag)
+			m.logEdit("tags", m.tasks[i])
+			break
+		}
+	}
+}
+
+// removeTagsFromCurrentTask gives the task the tags in their dialog order,
+// leaving out the checked ones
+func (m *Model) removeTagsFromCurrentTask() {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			var newTags []string
+			for j, tag := range m.tagOrder {
+				if !m.removeTagChecks[j] {
+					newTags = append(newTags, tag)
+				}
+			}
+			m.tasks[i].Tags = newTags
+			m.logEdit("tags", m.tasks[i])
+			break
+		}
+	}
+}
+
+func (m *Model) setDueDateForCurrentTask(dateStr string) {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	if dateStr == "" {
+		return
+	}
+	due, ok := parseDueInput(dateStr)
+	if !ok {
+		m.errorMessage = "Invalid date format. Use YYYY-MM-DD [HH:MM]"
+		return
+	}
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			m.tasks[i].DueDate = due
+			m.tasks[i].StartDate = ""
+			m.logEdit("due_date", m.tasks[i])
+			break
+		}
+	}
+}
+
+// setDateRangeForCurrentTask makes the selected task span from start to the
+// due date in dateStr
+func (m *Model) setDateRangeForCurrentTask(start, dateStr string) {
+	currentTask, ok := m.currentTask()
+	if !ok {
+		return
+	}
+
+	due, ok := parseDueInput(dateStr)
+	if !ok || due == "" {
+		m.errorMessage = "Invalid date format. Use YYYY-MM-DD [HH:MM]"
+		return
+	}
+	if due[:len(dueDateLayout)] < start {
+		m.errorMessage = "The range ends before it starts"
+		return
+	}
+	for i := range m.tasks {
+		if m.tasks[i].ID == currentTask.ID {
+			m.tasks[i].StartDate = start
+			m.tasks[i].DueDate = due
+			m.logEdit("due_date", m.tasks[i])
+			break
+		}
+	}
+}
+
+// parseDueInput validates a typed due date and returns it in stored form;
+// "clear" gives an empty due date
+func parseDueInput(dateStr string) (string, bool) {
+	if strings.ToLower(dateStr) == "clear" {
+		return "", true
+	}
+	if due, hasTime, err := parseDueDate(dateStr); err == nil && due.Year() > 1900 && due.Year() < 3000 {
+		return formatDueDate(due, hasTime), true
+	}
+	return "", false
+}
+
+// inBatchScope reports whether an open task falls in a tag:NAME or
+// context:NAME scope
+func inBatchScope(task Task, field, name string) bool {
+	if task.Checked {
+		return false
+	}
+	if field == "tag" {
+		return hasTag(task.Tags, name)
+	}
+	return task.Context == name
+}
+
+// batchScopeCount checks a tag:NAME or context:NAME scope and counts the
+// open tasks in it
+func (m *Model) batchScopeCount(scope string) (int, error) {
+	field, name, _ := strings.Cut(scope, ":")
+	if (field != "tag" && field != "context") || name == "" {
+		return 0, fmt.Errorf("Use tag:NAME or context:NAME")
+	}
+	count := 0
+	for _, task := range m.tasks {
+		if inBatchScope(task, field, name) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// setDueDateForScope sets the same due date on every open task in the scope
+func (m *Model) setDueDateForScope(scope, dateStr string) {
+	due, ok := parseDueInput(dateStr)
+	if !ok {
+		m.errorMessage = "Invalid date format. Use YYYY-MM-DD [HH:MM]"
+		return
+	}
+	field, name, _ := strings.Cut(scope, ":")
+	count := 0
+	for i := range m.tasks {
+		if inBatchScope(m.tasks[i], field, name) {
+			m.tasks[i].DueDate = due
+			m.tasks[i].StartDate = ""
+			m.logEdit("due_date", m.tasks[i])
+			count++
+		}
+	}
+	if due == "" {
+		m.setStatus(fmt.Sprintf("Cleared the due date of %d task(s)", count))
+	} else {
+		m.setStatus(fmt.Sprintf("Set %d task(s) due %s", count, due))
+	}
+}
+
+func (m *Model) searchTasks(query string) {
+	if _, err := parseSearchQuery(query); err != nil {
+		m.errorMessage = err.Error()
+		return
+	}
+
+	refine := m.refining()
+	results := m.matchTasks(query)
+	if refine {
+		results = m.narrowTasks(m.searchMatches(), query)
+	}
+	if len(results) == 0 {
+		m.errorMessage = fmt.Sprintf("No tasks matching '%s'", query)
+		return
+	}
+
+	if m.viewMode != SearchView && !m.searchAgain {
+		m.prevContext = m.currentContext
+		m.prevIndex = m.selectedIndex
+	}
+	if refine {
+		m.searchRefines = append(m.searchRefines, m.searchQuery)
+	} else {
+		m.searchRefines = nil
+	}
+	m.searchQuery = query
+	m.searchResults = results
+	m.viewMode = SearchView
+	m.recentView, m.todayView = false, false
+	m.selectedIndex = 0
+}
+
+// showRecent lists the most recently added tasks across all contexts,
+// reusing the search results view
+func (m *Model) showRecent() {
+	if m.viewMode != SearchView {
+		m.prevContext = m.currentContext
+		m.prevIndex = m.selectedIndex
+	}
+	m.searchQuery, m.searchRefines = "", nil
+	m.searchResults = m.recentTasks()
+	m.viewMode = SearchView
+	m.recentView, m.todayView = true, false
+	m.selectedIndex = 0
+}
+
+// showToday lists the tasks picked for today across all contexts
+func (m *Model) showToday() {
+	if m.viewMode != SearchView {
+		m.prevContext = m.currentContext
+		m.prevIndex = m.selectedIndex
+	}
+	m.searchQuery, m.searchRefines = "", nil
+	m.searchResults = m.todayTasks()
+	m.viewMode = SearchView
+	m.recentView, m.todayView = false, true
+	m.selectedIndex = 0
+}
+
+// todayTasks returns the tasks flagged for today, open ones first
+func (m *Model) todayTasks() []Task {
+	var open, done []Task
+	for _, task := range m.tasks {
+		if !task.Today {
+			continue
+		}
+		if task.Checked {
+			done = append(done, task)
+		} else {
+			open = append(open, task)
+		}
+	}
+	return append(open, done...)
+}
+
+// toggleTodayFlag picks the selected task for today, or unpicks it
+func (m *Model) toggleTodayFlag() {
+	task := m.getCurrentTask()
+	m.settings.PlannedOn = time.Now().Format(dueDateLayout)
+	for i := range m.tasks {
+		if m.tasks[i].ID == task.ID {
+			m.tasks[i].Today = !m.tasks[i].Today
+			m.logEdit("today", m.tasks[i])
+			if m.tasks[i].Today {
+				m.setStatus("Picked for today")
+			} else {
+				m.setStatus("No longer picked for today")
+			}
+			break
+		}
+	}
+
+	// Unpicking from the today list drops the task from it
+	if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining && remaining > 0 {
+		m.selectedIndex = remaining - 1
+	}
+}
+
+// clearTodayFlags unpicks every task once the day the flags were set for is over
+func (m *Model) clearTodayFlags(now time.Time) {
+	today := now.Format(dueDateLayout)
+	if m.settings.PlannedOn == today {
+		return
+	}
+	for i := range m.tasks {
+		if m.tasks[i].Today {
+			m.tasks[i].Today = false
+			m.dirty = true
+		}
+	}
+	m.settings.PlannedOn = today
+}
+
+// recentTasks returns tasks newest first by CreatedAt, limited by
+// recent_limit and recent_hours; tasks without a timestamp are left out
+func (m *Model) recentTasks() []Task {
+	var since time.Time
+	if m.settings.RecentHours > 0 {
+		since = time.Now().Add(-time.Duration(m.settings.RecentHours) * time.Hour)
+	}
+
+	type stamped struct {
+		task Task
+		at   time.Time
+	}
+	var recent []stamped
+	for _, task := range m.tasks {
+		at, err := time.Parse(time.RFC3339, task.CreatedAt)
+		if err != nil || at.Before(since) {
+			continue
+		}
+		recent = append(recent, stamped{task, at})
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].at.After(recent[j].at)
+	})
+
+	limit := m.settings.RecentLimit
+	if limit <= 0 {
+		limit = 20
+	}
+	tasks := make([]Task, 0, min(limit, len(recent)))
+	for i := 0; i < len(recent) && i < limit; i++ {
+		tasks = append(tasks, recent[i].task)
+	}
+	return tasks
+}
+
+// searchFilter is a parsed search query. Words without a field prefix form
+// a phrase matched against the task text, as in a plain search.
+type searchFilter struct {
+	phrase   string
+	terms    []string // text: values, each matched separately
+	tags     []string
+	priority []string
+	contexts []string
+	category []string
+	due      []string
+	status   string
+}
+
+// parseSearchQuery splits a query like "tag:urgent due:today report" into
+// field filters and free text
+func parseSearchQuery(query string) (searchFilter, error) {
+	var filter searchFilter
+	var words []string
+	for _, token := range strings.Fields(query) {
+		field, value, ok := strings.Cut(token, ":")
+		value = strings.ToLower(value)
+		if !ok || value == "" {
+			words = append(words, token)
+			continue
+		}
+
+		switch strings.ToLower(field) {
+		case "text":
+			filter.terms = append(filter.terms, value)
+		case "tag":
+			filter.tags = append(filter.tags, value)
+		case "priority":
+			if _, ok := priorityRank[value]; !ok && value != "none" {
+				return filter, fmt.Errorf("Invalid priority %q (use high, medium, low or none)", value)
+			}
+			filter.priority = append(filter.priority, value)
+		case "context":
+			filter.contexts = append(filter.contexts, value)
+		case "category":
+			filter.category = append(filter.category, value)
+		case "due":
+			switch value {
+			case "today", "tomorrow", "overdue", "now", "week", "none":
+			default:
+				if _, _, err := parseDueDate(value); err != nil {
+					return filter, fmt.Errorf("Invalid due date %q (use YYYY-MM-DD, today, tomorrow, week, overdue, now or none)", value)
+				}
+			}
+			filter.due = append(filter.due, value)
+		case "is":
+			if value != "done" && value != "open" && value != "stale" {
+				return filter, fmt.Errorf("Invalid status %q (use done, open or stale)", value)
+			}
+			filter.status = value
+		default:
+			// Not a known field, e.g. a time like 10:30
+			words = append(words, token)
+		}
+	}
+	filter.phrase = strings.Join(words, " ")
+	return filter, nil
+}
+
+// anyEqualFold reports whether value equals one of the wanted values, ignoring case
+func anyEqualFold(wanted []string, value string) bool {
+	for _, w := range wanted {
+		if strings.EqualFold(w, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTag reports whether tags contain want, ignoring case. A bare key also
+// matches any key:value tag with that key.
+func hasTag(tags []string, want string) bool {
+	for _, tag := range tags {
+		key, _ := splitTag(tag)
+		if strings.EqualFold(tag, want) || strings.EqualFold(key, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDue checks a task against one due: filter value
+func (m *Model) matchesDue(task Task, value string) bool {
+	if value == "none" {
+		return task.DueDate == ""
+	} else if value == "overdue" {
+		return m.isOverdue(task)
+	} else if value == "now" {
+		// Open and overdue or due today: what needs attention
+		return m.isOverdue(task) || (!task.Checked && m.matchesDue(task, "today"))
+	}
+
+	// A task with a date range is due on every day it covers
+	if _, _, err := parseDueDate(task.DueDate); err != nil {
+		return false
+	}
+	start, end, _ := dueSpan(task)
+	now := time.Now()
+	day := value
+	switch value {
+	case "today":
+		day = now.Format(dueDateLayout)
+	case "tomorrow":
+		day = now.AddDate(0, 0, 1).Format(dueDateLayout)
+	case "week":
+		return end >= now.Format(dueDateLayout) && start <= now.AddDate(0, 0, 6).Format(dueDateLayout)
+	}
+	return start <= day && day <= end
+}
+
+// matches reports whether a task passes every part of the filter
+func (m *Model) matches(filter searchFilter, task Task) bool {
+	text := strings.ToLower(task.Task)
+	if !strings.Contains(text, strings.ToLower(filter.phrase)) {
+		return false
+	}
+	for _, term := range filter.terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	for _, tag := range filter.tags {
+		if !hasTag(task.Tags, tag) {
+			return false
+		}
+	}
+	if len(filter.priority) > 0 {
+		priority := task.Priority
+		if priority == "" {
+			priority = "none"
+		}
+		if !anyEqualFold(filter.priority, priority) {
+			return false
+		}
+	}
+	if len(filter.contexts) > 0 && !anyEqualFold(filter.contexts, task.Context) {
+		return false
+	}
+	if len(filter.category) > 0 && !anyEqualFold(filter.category, task.Category) {
+		return false
+	}
+	for _, due := range filter.due {
+		if !m.matchesDue(task, due) {
+			return false
+		}
+	}
+	if filter.status == "stale" {
+		if _, ok := m.staleAge(task); !ok {
+			return false
+		}
+	} else if filter.status != "" && task.Checked != (filter.status == "done") {
+		return false
+	}
+	return true
+}
+
+// matchTasks returns every task matching the query. Plain words must appear
+// in the task text, ignoring case; field:value tokens filter on the field.
+func (m *Model) matchTasks(query string) []Task {
+	return m.narrowTasks(m.tasks, query)
+}
+
+// searchForNothing handles an empty search as set by empty_search
+func (m *Model) searchForNothing() {
+	switch m.settings.EmptySearch {
+	case "clear":
+		if len(m.activeFilters()) == 0 {
+			return
+		}
+		m.dueOnly = false
+		m.categoryFilter = ""
+		m.extrasOnly = false
+		m.selectedIndex = 0
+		m.setStatus("Filters cleared")
+	case "all":
+		// The empty query matches every task
+		m.searchTasks("")
+	}
+}
+
+// refining reports whether the open search dialog searches within the
+// current results, per search_again
+func (m *Model) refining() bool {
+	return m.searchAgain && m.settings.SearchAgain == "refine" && m.searchQuery != ""
+}
+
+// searchMatches returns the tasks matching the search query and every
+// earlier query it refines
+func (m *Model) searchMatches() []Task {
+	results := m.matchTasks(m.searchQuery)
+	for _, query := range m.searchRefines {
+		results = m.narrowTasks(results, query)
+	}
+	return results
+}
+
+// narrowTasks keeps the tasks that also match query
+func (m *Model) narrowTasks(tasks []Task, query string) []Task {
+	filter, err := parseSearchQuery(query)
+	if err != nil {
+		return nil
+	}
+	var kept []Task
+	for _, task := range tasks {
+		if m.matches(filter, task) {
+			kept = append(kept, task)
+		}
+	}
+	return kept
+}
+
+func (m *Model) exitSearchMode() {
+	m.viewMode = NormalView
+	m.currentContext = m.prevContext
+	m.selectedIndex = m.prevIndex
+	m.searchResults = nil
+	m.searchQuery = ""
+	m.searchRefines = nil
+	m.recentView, m.todayView = false, false
+}
+
+func (m *Model) updateContexts() {
+	// Keep contexts that exist without tasks (e.g. freshly created ones)
+	contextMap := make(map[string]bool)
+	for _, ctx := range m.contexts {
+		contextMap[ctx] = true
+	}
+	for _, task := range m.tasks {
+		contextMap[task.Context] = true
+	}
+	for context := range contextMap {
+		for parent, ok := parentContext(context); ok; parent, ok = parentContext(parent) {
+			contextMap[parent] = true
+		}
+	}
+
+	m.contexts = make([]string, 0, len(contextMap))
+	for context := range contextMap {
+		m.contexts = append(m.contexts, context)
+	}
+	m.sortContexts()
+
+	// There is always at least one context to work in
+	if len(m.contexts) == 0 {
+		m.contexts = []string{defaultContext}
+	}
+
+	// Set current context if not set or if current doesn't exist
+	if m.currentContext == "" || m.findContextIndex(m.currentContext) < 0 {
+		m.currentContext = m.contexts[0]
+		if contexts := m.navigableContexts(); len(contexts) > 0 {
+			m.currentContext = contexts[0]
+		}
+	}
+}
+
+// sortContexts orders the context list by the configured context_sort mode,
+// falling back to alphabetical order for ties
+func (m *Model) sortContexts() {
+	sort.Slice(m.contexts, func(i, j int) bool {
+		return compareContextPaths(m.contexts[i], m.contexts[j]) < 0
+	})
+
+	switch m.settings.ContextSort {
+	case "activity":
+		// Most recently created or completed task first
+		latest := make(map[string]string)
+		for _, task := range m.tasks {
+			for _, stamp := range []string{task.CreatedAt, task.CompletedAt} {
+				if stamp > latest[task.Context] {
+					latest[task.Context] = stamp
+				}
+			}
+		}
+		sort.SliceStable(m.contexts, func(i, j int) bool {
+			return latest[m.contexts[i]] > latest[m.contexts[j]]
+		})
+	case "overdue":
+		overdue := make(map[string]int)
+		for _, task := range m.tasks {
+			if m.isOverdue(task) {
+				overdue[task.Context]++
+			}
+		}
+		sort.SliceStable(m.contexts, func(i, j int) bool {
+			return overdue[m.contexts[i]] > overdue[m.contexts[j]]
+		})
+	}
+}
+
+// undoState is a snapshot taken before a change, restored by undo
+type undoState struct {
+	tasks    []Task
+	contexts []string
+	archived []string
+	// Scratchpads live in the settings but are edited like tasks
+	scratchpads       map[string]string
+	foldedScratchpads []string
+}
+
+func (m *Model) saveStateForUndo() {
+	// Deep copy current tasks and contexts
+	stateCopy := undoState{
+		tasks:    make([]Task, len(m.tasks)),
+		contexts: make([]string, len(m.contexts)),
+	}
+	copy(stateCopy.tasks, m.tasks)
+	copy(stateCopy.contexts, m.contexts)
+	stateCopy.archived = append([]string(nil), m.settings.Archived...)
+	stateCopy.scratchpads = make(map[string]string, len(m.settings.Scratchpads))
+	for context, pad := range m.settings.Scratchpads {
+		stateCopy.scratchpads[context] = pad
+	}
+	stateCopy.foldedScratchpads = append([]string(nil), m.settings.FoldedScratchpads...)
+	
+	m.history = append(m.history, stateCopy)
+	m.dirty = true
+	
+	// Limit history size
+	if len(m.history) > m.maxHistory {
+		m.history = m.history[1:]
+
+		// Let the user know once that undo reach is now capped
+		if !m.historyEvicted {
+			m.historyEvicted = true
+			m.errorMessage = fmt.Sprintf("Undo history full: only the last %d changes can be undone", m.maxHistory)
+		}
+	}
+}
+
+// dropUndoState takes back the snapshot just saved for a change that didn't
+// happen, and the dirty flag with it
+func (m *Model) dropUndoState(dirty bool) {
+	if len(m.history) > 0 {
+		m.history = m.history[:len(m.history)-1]
+	}
+	m.dirty = dirty
+}
+
+func (m *Model) undo() {
+	if len(m.history) == 0 {
+		m.errorMessage = "Nothing to undo"
+		return
+	}
+
+	// Restore previous state
+	state := m.history[len(m.history)-1]
+	m.tasks, m.contexts = state.tasks, state.contexts
+	m.settings.Archived = state.archived
+	m.settings.Scratchpads, m.settings.FoldedScratchpads = state.scratchpads, state.foldedScratchpads
+	m.history = m.history[:len(m.history)-1]
+	m.dirty = true
+	
+	// Update contexts and ensure current context is valid
+	m.updateContexts()
+	
+	// Reset selection
+	m.selectedIndex = 0
+	m.setStatus("Undid last change")
+}
+
+// schedules lists the repeat schedules a task can have
+var schedules = []string{"daily", "weekdays", "weekly", "monthly"}
+
+// schedulePeriod returns the start date of the period containing now, or
+// false when the schedule has no period there (weekends for weekdays).
+// Weekly periods start on firstWeekday.
+func schedulePeriod(schedule string, now time.Time, firstWeekday time.Weekday) (string, bool) {
+	day := periodStart(schedule, now, firstWeekday)
+	switch schedule {
+	case "daily", "weekly", "monthly":
+	case "weekdays":
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return day.Format(dueDateLayout), true
+}
+
+// periodStart returns midnight on the first day of the schedule's period
+// containing t: the day itself for daily and weekdays
+func periodStart(schedule string, t time.Time, firstWeekday time.Weekday) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch schedule {
+	case "weekly":
+		day = day.AddDate(0, 0, -(int(day.Weekday())-int(firstWeekday)+7)%7)
+	case "monthly":
+		day = day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// scheduledDates moves the due and start dates of a copy of a scheduled
+// task forward by whole periods, into the period containing now, so a copy
+// isn't overdue the moment it appears. Dates already in or past that period stay.
+func scheduledDates(task Task, now time.Time, firstWeekday time.Weekday) Task {
+	anchor, _, err := parseDueDate(task.DueDate)
+	if err != nil {
+		if anchor, _, err = parseDueDate(task.StartDate); err != nil {
+			return task
+		}
+	}
+	from, to := periodStart(task.Schedule, anchor, firstWeekday), periodStart(task.Schedule, now, firstWeekday)
+	if !from.Before(to) {
+		return task
+	}
+	months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+	days := int(to.Sub(from).Hours()/24 + 0.5)
+	shift := func(date string) string {
+		t, hasTime, err := parseDueDate(date)
+		if err != nil {
+			return date
+		}
+		if task.Schedule == "monthly" {
+			return formatDueDate(t.AddDate(0, months, 0), hasTime)
+		}
+		return formatDueDate(t.AddDate(0, 0, days), hasTime)
+	}
+	task.DueDate, task.StartDate = shift(task.DueDate), shift(task.StartDate)
+	return task
+}
+
+// generateScheduled adds a fresh copy of every scheduled task that has none
+// for the current period yet, and reports how many were added. Copies don't
+// repeat themselves; the original keeps the schedule, and its dates move
+// forward into the current period on the copy.
+func (m *Model) generateScheduled(now time.Time) int {
+	added := 0
+	for _, i := range m.batchOrder(func(task Task) bool { return task.Schedule != "" }) {
+		period, ok := schedulePeriod(m.tasks[i].Schedule, now, m.firstWeekday())
+		if !ok || m.tasks[i].Generated >= period {
+			continue
+		}
+		m.tasks[i].Generated = period
+
+		instance := scheduledDates(m.tasks[i], now, m.firstWeekday())
+		instance.ID = m.nextID
+		instance.Checked = false
+		instance.CompletedAt = ""
+		instance.Percent = 0
+		instance.Today = false
+		instance.CreatedAt = now.Format(time.RFC3339)
+		instance.Tags = append([]string(nil), instance.Tags...)
+		instance.Attachments = append([]string(nil), instance.Attachments...)
+		instance.ParentID, instance.Collapsed = 0, false
+		instance.Schedule, instance.Generated = "", ""
+		instance.Source, instance.CompletionCount = m.tasks[i].ID, 0
+		instance.Code = m.nextCode(instance.Context)
+		m.tasks = append(m.tasks, instance)
+		m.logTask("created", instance)
+		m.nextID++
+		added++
+	}
+	if added > 0 {
+		m.dirty = true
+	}
+	return added
+}
+
+// repeatAfter returns how often a task with an "after" schedule repeats once
+// completed ("weekly" for "after weekly"), or "" for any other task
+func repeatAfter(task Task) string {
+	after, ok := strings.CutPrefix(task.Schedule, "after ")
+	if !ok {
+		return ""
+	}
+	return after
+}
+
+// repeatInstance returns the next copy of a task with an "after" schedule,
+// due one period after its due date (or today when it has none). The copy
+// takes the schedule over from the task. The caller sets the id.
+func repeatInstance(task Task, now time.Time) Task {
+	due, hasTime, err := parseDueDate(task.DueDate)
+	if err != nil {
+		due, hasTime = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()), false
+	}
+	switch repeatAfter(task) {
+	case "daily":
+		due = due.AddDate(0, 0, 1)
+	case "weekdays":
+		due = due.AddDate(0, 0, 1)
+		for due.Weekday() == time.Saturday || due.Weekday() == time.Sunday {
+			due = due.AddDate(0, 0, 1)
+		}
+	case "weekly":
+		due = due.AddDate(0, 0, 7)
+	case "monthly":
+		due = due.AddDate(0, 1, 0)
+	}
+
+	instance := task
+	instance.Checked = false
+	instance.CompletedAt = ""
+	if start, _, err := parseDueDate(task.StartDate); err == nil && task.DueDate != "" {
+		// A range keeps its length
+		old, _, _ := parseDueDate(task.DueDate)
+		instance.StartDate = start.AddDate(0, 0, int(due.Sub(old).Hours()/24+0.5)).Format(dueDateLayout)
+	}
+	instance.DueDate = formatDueDate(due, hasTime)
+	instance.Tags = append([]string(nil), task.Tags...)
+	instance.Attachments = append([]string(nil), task.Attachments...)
+	instance.ParentID, instance.Collapsed = 0, false
+	instance.Today = false
+	instance.Source, instance.Percent = task.ID, 0
+	return instance
+}
+
+// spawnRepeat adds the next copy of a just-completed task with an "after"
+// schedule and hands the schedule on to it, so completing the task again
+// adds nothing more
+func (m *Model) spawnRepeat(id int) {
+	i := m.findTaskIndex(id)
+	if i < 0 || repeatAfter(m.tasks[i]) == "" {
+		return
+	}
+	now := time.Now()
+	instance := repeatInstance(m.tasks[i], now)
+	instance.ID = m.nextID
+	instance.Code = m.nextCode(instance.Context)
+	instance.CreatedAt = now.Format(time.RFC3339)
+	m.nextID++
+	m.tasks[i].Schedule = ""
+	m.tasks = append(m.tasks, instance)
+	m.logTask("created", instance)
+}
+
+// handedOn returns the index of the copy a completed task handed its "after"
+// schedule on to, or -1
+func (m *Model) handedOn(id int) int {
+	for i, task := range m.tasks {
+		if task.Source == id && repeatAfter(task) != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// unspawnRepeat removes the copy a reopened task handed its "after" schedule
+// on to and takes the schedule back, as long as nobody has touched the copy
+// since; an edited copy stays and keeps the schedule
+func (m *Model) unspawnRepeat(id int) {
+	i, j := m.findTaskIndex(id), m.handedOn(id)
+	if i < 0 || j < 0 || len(m.subtasksOf(m.tasks[j].ID)) > 0 {
+		return
+	}
+
+	task := m.tasks[i]
+	task.Schedule = m.tasks[j].Schedule
+	expected := repeatInstance(task, time.Now())
+	expected.ID, expected.CreatedAt, expected.Code = m.tasks[j].ID, m.tasks[j].CreatedAt, m.tasks[j].Code
+	want, _ := json.Marshal(expected)
+	got, _ := json.Marshal(m.tasks[j])
+	if string(want) != string(got) {
+		return
+	}
+	m.tasks[i].Schedule = task.Schedule
+	m.logTask("deleted", m.tasks[j])
+	m.tasks = append(m.tasks[:j], m.tasks[j+1:]...)
+	m.setStatus("Removed the next repeat")
+}
+
+// Due dates are stored as a date with an optional time of day
+const (
+	dueDateLayout     = "2006-01-02"
+	dueDateTimeLayout = "2006-01-02 15:04"
+)
+
+// parseDueDate parses a stored due date and reports whether it carries a time
+func parseDueDate(s string) (time.Time, bool, error) {
+	if due, err := time.ParseInLocation(dueDateTimeLayout, s, time.Local); err == nil {
+		return due, true, nil
+	}
+	due, err := time.ParseInLocation(dueDateLayout, s, time.Local)
+	return due, false, err
+}
+
+// shiftDueDate moves a stored date, with or without a time, by days;
+// anything unparsable is kept as it is
+func shiftDueDate(date string, days int) string {
+	due, hasTime, err := parseDueDate(date)
+	if err != nil || days == 0 {
+		return date
+	}
+	return formatDueDate(due.AddDate(0, 0, days), hasTime)
+}
+
+// formatDueDate renders a due date the way it is stored, with the time only when set
+func formatDueDate(due time.Time, hasTime bool) string {
+	if hasTime {
+		return due.Format(dueDateTimeLayout)
+	}
+	return due.Format(dueDateLayout)
+}
+
+// dueLabel formats a task's due date for display, relative to today when
+// relative_dates is on
+func (m *Model) dueLabel(task Task) string {
+	label := task.DueDate
+	if due, hasTime, err := parseDueDate(task.DueDate); m.settings.RelativeDue && err == nil {
+		label = relativeDate(due, hasTime, time.Now())
+	}
+	if task.StartDate == "" {
+		return label
+	}
+	start, _, err := parseDueDate(task.StartDate)
+	if !m.settings.RelativeDue || err != nil {
+		return task.StartDate + " → " + label
+	}
+	return relativeDate(start, false, time.Now()) + " → " + label
+}
+
+// dueSpan returns the first and last day a task is due, as YYYY-MM-DD; they
+// are the same day unless the task has a start date
+func dueSpan(task Task) (start, end string, ok bool) {
+	if len(task.DueDate) < len(dueDateLayout) {
+		return "", "", false
+	}
+	end = task.DueDate[:len(dueDateLayout)]
+	start = end
+	if task.StartDate != "" && task.StartDate < end {
+		start = task.StartDate
+	}
+	return start, end, true
+}
+
+// relativeDate describes due as a day offset from now, e.g. "today 14:00",
+// "tomorrow", "in 3d" or "2d ago"
+func relativeDate(due time.Time, hasTime bool, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	day := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.Local)
+	days := int(math.Round(day.Sub(today).Hours() / 24))
+
+	var label string
+	switch {
+	case days == 0:
+		label = "today"
+	case days == 1:
+		label = "tomorrow"
+	case days == -1:
+		label = "yesterday"
+	case days > 1:
+		label = fmt.Sprintf("in %dd", days)
+	default:
+		label = fmt.Sprintf("%dd ago", -days)
+	}
+	if hasTime {
+		label += due.Format(" 15:04")
+	}
+	return label
+}
+
+// padDateField zero-pads a numeric dialog field so "6" becomes "06"
+func padDateField(v string) string {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil && len(v) < 2 {
+		return fmt.Sprintf("%02d", n)
+	}
+	return v
+}
+
+// expandYear reads a one- or two-digit year field as 20YY, so 26 means 2026;
+// four digits, or anything else, are left as typed
+func expandYear(v string) string {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil && len(v) <= 2 && n >= 0 {
+		return strconv.Itoa(2000 + n)
+	}
+	return v
+}
+
+// dueDeadline returns the moment a task becomes overdue. Date-only tasks are
+// due at the configured due_time, or at the end of the day.
+func (m *Model) dueDeadline(task Task) (time.Time, bool) {
+	due, hasTime, err := parseDueDate(task.DueDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if hasTime {
+		return due, true
+	}
+	if at, err := time.Parse("15:04", m.settings.DueTime); err == nil {
+		return due.Add(time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute), true
+	}
+	return due.AddDate(0, 0, 1).Add(-time.Nanosecond), true
+}
+
+// isOverdue reports whether an open task is past its deadline
+func (m *Model) isOverdue(task Task) bool {
+	if task.Checked || task.Template {
+		return false
+	}
+	deadline, ok := m.dueDeadline(task)
+	return ok && time.Now().After(deadline)
+}
+
+// staleAge returns the age in days of an open task left longer than
+// stale_days, and whether it is that stale
+func (m *Model) staleAge(task Task) (int, bool) {
+	days, ok := taskAgeDays(task)
+	if !ok || task.Checked || task.Template || m.settings.StaleDays <= 0 || days < m.settings.StaleDays {
+		return 0, false
+	}
+	return days, true
+}
+
+// taskAgeDays reports how many days ago the task was created, if known
+func taskAgeDays(task Task) (int, bool) {
+	created, err := time.Parse(time.RFC3339, task.CreatedAt)
+	if err != nil {
+		return 0, false
+	}
+	return int(time.Since(created).Hours() / 24), true
+}
+
+// completedOn counts the tasks completed on the same calendar day as day
+func completedOn(tasks []Task, day time.Time) int {
+	count := 0
+	for _, task := range tasks {
+		if !task.Checked {
+			continue
+		}
+		done, err := time.Parse(time.RFC3339, task.CompletedAt)
+		if err != nil {
+			continue
+		}
+		done = done.In(day.Location())
+		if done.YearDay() == day.YearDay() && done.Year() == day.Year() {
+			count++
+		}
+	}
+	return count
+}
+
+// priorityRank orders priorities from most to least urgent
+var priorityRank = map[string]int{"high": 0, "medium": 1, "low": 2, "": 3}
+
+// sortKeys returns the configured sort keys, or the default order
+func (m *Model) sortKeys() []string {
+	if len(m.settings.SortKeys) > 0 {
+		return m.settings.SortKeys
+	}
+	return defaultSortKeys
+}
+
+// viewSortKeys returns the sort keys for a view: normal, kanban or search
+// (which covers the recent and today lists too). view_sort takes precedence;
+// otherwise normal uses sort_keys, kanban follows kanban_sort and search
+// keeps the order results come in. No keys means manual order.
+func (m *Model) viewSortKeys(view string) []string {
+	if keys, ok := m.settings.ViewSort[view]; ok {
+		return keys
+	}
+	switch view {
+	case "normal":
+		return m.settings.SortKeys
+	case "kanban":
+		switch m.settings.KanbanSort {
+		case "manual":
+		case "weight":
+			return weightSortKeys
+		default:
+			return defaultSortKeys
+		}
+	}
+	return nil
+}
+
+// defaultSortKeys orders open tasks before completed ones, then by priority,
+// then by due date with undated tasks last
+var defaultSortKeys = []string{"status", "priority", "due"}
+
+// weightSortKeys puts the weightiest open tasks first: by priority, then
+// biggest estimate, then due date
+var weightSortKeys = []string{"status", "priority", "size", "due"}
+
+// sortKeyCompare compares two tasks on a single sort key. Empty values
+// (no due date, no category, no estimate) sort last.
+var sortKeyCompare = map[string]func(a, b Task) int{
+	"status": func(a, b Task) int {
+		if a.Checked == b.Checked {
+			return 0
+		} else if a.Checked {
+			return 1
+		}
+		return -1
+	},
+	"priority": func(a, b Task) int {
+		return priorityRank[a.Priority] - priorityRank[b.Priority]
+	},
+	"due": func(a, b Task) int {
+		return compareEmptyLast(a.DueDate, b.DueDate)
+	},
+	"title": func(a, b Task) int {
+		return strings.Compare(strings.ToLower(a.Task), strings.ToLower(b.Task))
+	},
+	"created": func(a, b Task) int {
+		return compareEmptyLast(a.CreatedAt, b.CreatedAt)
+	},
+	"category": func(a, b Task) int {
+		return compareEmptyLast(a.Category, b.Category)
+	},
+	"size": func(a, b Task) int {
+		return b.Estimate - a.Estimate
+	},
+	"estimate": func(a, b Task) int {
+		if a.Estimate == 0 || b.Estimate == 0 {
+			return b.Estimate - a.Estimate
+		}
+		return a.Estimate - b.Estimate
+	},
+	"id": func(a, b Task) int {
+		return a.ID - b.ID
+	},
+}
+
+func compareEmptyLast(a, b string) int {
+	if a == b {
+		return 0
+	} else if a == "" {
+		return 1
+	} else if b == "" {
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareTasks orders tasks by each key in turn, ignoring unknown keys
+func compareTasks(a, b Task, keys []string) int {
+	for _, k := range keys {
+		if cmp, ok := sortKeyCompare[k]; ok {
+			if c := cmp(a, b); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// batchKeys is the order batch operations visit tasks in: highest priority
+// first, then lowest ID. It doesn't depend on the list order, so a batch
+// gives the same result however the tasks were arranged.
+var batchKeys = []string{"priority", "id"}
+
+// batchOrder returns the positions in m.tasks of the tasks matching keep,
+// in batchKeys order
+func (m *Model) batchOrder(keep func(Task) bool) []int {
+	var positions []int
+	for i, task := range m.tasks {
+		if keep(task) {
+			positions = append(positions, i)
+		}
+	}
+	sort.SliceStable(positions, func(a, b int) bool {
+		return compareTasks(m.tasks[positions[a]], m.tasks[positions[b]], batchKeys) < 0
+	})
+	return positions
+}
+
+// sortTasks orders tasks by compareTasks, keeping storage order for ties
+func sortTasks(tasks []Task, keys []string) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return compareTasks(tasks[i], tasks[j], keys) < 0
+	})
+}
+
+// sortInDirection sorts by keys, reversed when the sort direction is flipped;
+// ties keep the manual order either way
+func (m *Model) sortInDirection(tasks []Task, keys []string) {
+	if !m.sortDescending {
+		sortTasks(tasks, keys)
+		return
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return compareTasks(tasks[i], tasks[j], keys) > 0
+	})
+}
+
+// sortArrow shows the sort direction for the header, or "" in manual order
+func (m *Model) sortArrow(view string) string {
+	if len(m.viewSortKeys(view)) == 0 {
+		return ""
+	}
+	if m.sortDescending {
+		return " ↓"
+	}
+	return " ↑"
+}
+
+// urlPattern matches http(s) links inside task text
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// ringBell sounds the terminal bell
+func ringBell() tea.Msg {
+	fmt.Fprint(os.Stdout, "\a")
+	return nil
+}
+
+// queueHook schedules the hook for event, if one is configured, to run once
+// the current key has been handled
+func (m *Model) queueHook(event string, task Task) {
+	if m.settings.Hooks[event] != "" {
+		m.pendingHooks = append(m.pendingHooks, hookEvent{event, task})
+	}
+}
+
+// hookEvent is a task event waiting for its hook to run
+type hookEvent struct {
+	event string
+	task  Task
+}
+
+// runHooks starts the queued hook commands in the background, in order
+func (m Model) runHooks() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, h := range m.pendingHooks {
+		command := m.settings.Hooks[h.event]
+		cmds = append(cmds, func() tea.Msg {
+			return hookDoneMsg{h.event, h.run(command)}
+		})
+	}
+	return tea.Sequence(cmds...)
+}
+
+// runHooksNow runs the queued hook commands in order and waits for them,
+// for command-line runs that exit without starting the UI
+func (m *Model) runHooksNow() error {
+	defer func() { m.pendingHooks = nil }()
+	for _, h := range m.pendingHooks {
+		if err := h.run(m.settings.Hooks[h.event]); err != nil {
+			return fmt.Errorf("hook %s failed: %v", h.event, err)
+		}
+	}
+	return nil
+}
+
+// run runs the hook command with the task described in its environment
+func (h hookEvent) run(command string) error {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), hookEnv(h.event, h.task)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = fmt.Errorf("%v: %s", err, firstLine(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+// hookEnv describes the task to a hook command:
+//
+//	TUIDO_EVENT          task-added, task-completed or task-deleted
+//	TUIDO_TASK_ID        numeric task ID
+//	TUIDO_TASK_CODE      short code, if any
+//	TUIDO_TASK_TEXT      the task text
+//	TUIDO_TASK_CONTEXT   its context
+//	TUIDO_TASK_PRIORITY  high, medium, low or empty
+//	TUIDO_TASK_TAGS      comma-separated tags
+//	TUIDO_TASK_DUE       due date, if any
+//	TUIDO_TASK_JSON      the whole task as stored in config.json
+func hookEnv(event string, task Task) []string {
+	data, _ := json.Marshal(task)
+	return []string{
+		"TUIDO_EVENT=" + event,
+		"TUIDO_TASK_ID=" + strconv.Itoa(task.ID),
+		"TUIDO_TASK_CODE=" + task.Code,
+		"TUIDO_TASK_TEXT=" + task.Task,
+		"TUIDO_TASK_CONTEXT=" + task.Context,
+		"TUIDO_TASK_PRIORITY=" + task.Priority,
+		"TUIDO_TASK_TAGS=" + strings.Join(task.Tags, ","),
+		"TUIDO_TASK_DUE=" + task.DueDate,
+		"TUIDO_TASK_JSON=" + string(data),
+	}
+}
+
+// auditEntry is one line of audit.log
+type auditEntry struct {
+	Time    string `json:"time"`
+	Action  string `json:"action"`
+	Field   string `json:"field,omitempty"` // the task field an "edited" line changed
+	ID      int    `json:"id"`
+	Task    string `json:"task"`
+	Context string `json:"context"`
+}
+
+// hookEvents maps the audit log actions that have a hook to its event
+var hookEvents = map[string]string{
+	"created":   "task-added",
+	"completed": "task-completed",
+	"deleted":   "task-deleted",
+	"merged":    "task-added",
+}
+
+// logTask appends a task change to audit.log when audit_log is on, and
+// queues the hook for it if there is one. The log is only ever appended to;
+// undo doesn't take entries back.
+func (m *Model) logTask(action string, task Task) {
+	if event, ok := hookEvents[action]; ok {
+		m.queueHook(event, task)
+	}
+	m.writeAudit(auditEntry{Action: action, ID: task.ID, Task: task.Task, Context: task.Context})
+}
+
+// logEdit appends an "edited" line naming the field of task that changed
+func (m *Model) logEdit(field string, task Task) {
+	m.writeAudit(auditEntry{Action: "edited", Field: field, ID: task.ID, Task: task.Task, Context: task.Context})
+}
+
+// writeAudit stamps entry with the time and appends it to audit.log
+func (m *Model) writeAudit(entry auditEntry) {
+	if !m.settings.AuditLog {
+		return
+	}
+	entry.Time = time.Now().Format(time.RFC3339)
+	line, err := json.Marshal(entry)
+	if err == nil {
+		var f *os.File
+		f, err = os.OpenFile(filepath.Join(m.configPath, "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		m.errorMessage = fmt.Sprintf("Could not write audit log: %v", err)
+	}
+}
+
+// shellCommand builds a command that runs a user-supplied command line through the shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// openWithOS hands target to the platform's default handler without waiting for it
+func openWithOS(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// slugify turns a task title into a branch name: lowercase, words joined by
+// hyphens, punctuation dropped
+func slugify(title string) string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(title)) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+				return r
+			}
+			return -1
+		}, word)
+		if word = strings.Trim(word, "-"); word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// copyToClipboard puts text on the system clipboard, through clipboard_cmd when set
+func (m *Model) copyToClipboard(text string) error {
+	if m.settings.ClipboardCmd == "" {
+		if clipboard.Unsupported {
+			return fmt.Errorf("no clipboard available; set clipboard_cmd")
+		}
+		return clipboard.WriteAll(text)
+	}
+
+	cmd := shellCommand(m.settings.ClipboardCmd)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// readClipboard returns the system clipboard text, through paste_cmd when set
+func (m *Model) readClipboard() (string, error) {
+	if m.settings.PasteCmd == "" {
+		if clipboard.Unsupported {
+			return "", fmt.Errorf("no clipboard available; set paste_cmd")
+		}
+		return clipboard.ReadAll()
+	}
+
+	out, err := shellCommand(m.settings.PasteCmd).Output()
+	return string(out), err
+}
+
+// Configuration and persistence
+
+func (m *Model) loadConfig() {
+	// Ensure config directory exists
+	os.MkdirAll(m.configPath, 0755)
+	
+	configFile := findConfigFile(m.configPath)
+
+	// Save in the requested format from now on; the next save converts the file
+	m.configFile = configFile
+	if m.configFormat != "" {
+		m.configFile = filepath.Join(m.configPath, "config."+m.configFormat)
+	}
+	
+	// Only a missing file means a first run; one that doesn't parse is left
+	// alone rather than saved over with an empty list
+	config, err := readConfigFile(configFile)
+	if os.IsNotExist(err) {
+		m.createDefaultConfig()
+		return
+	}
+	if err != nil {
+		m.configFile = configFile
+		m.configError = err.Error()
+		m.errorMessage = fmt.Sprintf("Could not read %s: %v; saving is off until it is fixed", filepath.Base(configFile), err)
+		return
+	}
+	m.configError = ""
+
+	// Pull the latest copy before using it, if a sync command is configured
+	if config.SyncPullCmd != "" {
+		if err := m.runSyncCommand(config.SyncPullCmd); err != nil {
+			m.errorMessage = fmt.Sprintf("Sync pull failed: %v", err)
+		} else if pulled, err := readConfigFile(configFile); err == nil {
+			config = pulled
+		}
+	}
+
+	m.tasks = config.Tasks
+	m.nextID = config.NextID
+	m.settings = config.Settings
+	if m.configFile != configFile {
+		m.convertedFrom = configFile
+		m.dirty = true
+	}
+	
+	m.repairTaskIDs()
+}
+
+// repairTaskIDs gives tasks with a duplicate ID a fresh one and makes sure
+// nextID is above every ID in use, so lookups by ID always find the right task.
+// Subtasks of a renumbered parent follow it when it is the one in their context.
+func (m *Model) repairTaskIDs() {
+	maxID := 0
+	for _, task := range m.tasks {
+		maxID = max(maxID, task.ID)
+	}
+	if m.nextID <= maxID {
+		m.nextID = maxID + 1
+	}
+
+	seen := make(map[int]string) // ID to the context of the task keeping it
+	renumbered := make(map[int][]Task)
+	fixed := 0
+	for i := range m.tasks {
+		if _, ok := seen[m.tasks[i].ID]; ok {
+			old := m.tasks[i].ID
+			m.tasks[i].ID = m.nextID
+			m.nextID++
+			renumbered[old] = append(renumbered[old], m.tasks[i])
+			fixed++
+			continue
+		}
+		seen[m.tasks[i].ID] = m.tasks[i].Context
+	}
+	for i := range m.tasks {
+		parent := m.tasks[i].ParentID
+		if len(renumbered[parent]) == 0 || seen[parent] == m.tasks[i].Context {
+			continue
+		}
+		for _, task := range renumbered[parent] {
+			if task.Context == m.tasks[i].Context && task.ID != m.tasks[i].ID {
+				m.tasks[i].ParentID = task.ID
+				break
+			}
+		}
+	}
+
+	if fixed > 0 {
+		m.setStatus(fmt.Sprintf("Repaired %d duplicate task ID(s)", fixed))
+	}
+}
+
+// configCodec turns a Config into file contents and back
+type configCodec interface {
+	Marshal(config Config) ([]byte, error)
+	Unmarshal(data []byte, config *Config) error
+}
+
+// configCodecs maps the config file extensions we understand to their codec;
+// JSON is the default, TOML and YAML are there for editing by hand
+var configCodecs = map[string]configCodec{
+	"json": jsonCodec{},
+	"toml": tomlCodec{},
+	"yaml": yamlCodec{},
+	"yml":  yamlCodec{},
+}
+
+// configFormats lists the extensions in the order they are looked for
+var configFormats = []string{"json", "toml", "yaml", "yml"}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(config Config) ([]byte, error) {
+	return json.MarshalIndent(config, "", "  ")
+}
+
+func (jsonCodec) Unmarshal(data []byte, config *Config) error {
+	return json.Unmarshal(data, config)
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) Marshal(config Config) ([]byte, error) {
+	tree, err := configTree(config)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(tree)
+	return buf.Bytes(), err
+}
+
+func (tomlCodec) Unmarshal(data []byte, config *Config) error {
+	var tree map[string]interface{}
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return fromConfigTree(tree, config)
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(config Config) ([]byte, error) {
+	tree, err := configTree(config)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+func (yamlCodec) Unmarshal(data []byte, config *Config) error {
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return fromConfigTree(tree, config)
+}
+
+// configTree converts a Config to plain maps and slices by way of JSON, so
+// TOML and YAML files use the same key names and omit the same empty fields
+func configTree(config Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree map[string]interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return plainTree(tree).(map[string]interface{}), nil
+}
+
+// plainTree turns JSON numbers into ints or floats and drops nulls, which
+// TOML has no way to write
+func plainTree(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = plainTree(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = plainTree(v[i])
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// fromConfigTree fills a Config from decoded TOML or YAML by way of JSON
+func fromConfigTree(tree map[string]interface{}, config *Config) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, config)
+}
+
+// configCodecFor picks the codec for a config file from its extension
+func configCodecFor(path string) (configCodec, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	codec, ok := configCodecs[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	return codec, nil
+}
+
+// findConfigFile returns the config file in dir. If there are several in
+// different formats the most recently written one wins, so converting to a
+// new format sticks; with none yet it is config.json.
+func findConfigFile(dir string) string {
+	path := filepath.Join(dir, "config.json")
+	var newest time.Time
+	for _, format := range configFormats {
+		candidate := filepath.Join(dir, "config."+format)
+		if info, err := os.Stat(candidate); err == nil && info.ModTime().After(newest) {
+			path, newest = candidate, info.ModTime()
+		}
+	}
+	return path
+}
+
+// readConfigFile reads and parses a config file in the format its extension names
+func readConfigFile(path string) (Config, error) {
+	var config Config
+	codec, err := configCodecFor(path)
+	if err != nil {
+		return config, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = codec.Unmarshal(data, &config)
+	return config, err
+}
+
+// runSyncCommand runs a user-configured sync command from the config directory
+func (m *Model) runSyncCommand(command string) error {
+	cmd := shellCommand(command)
+	cmd.Dir = m.configPath
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// editConfig saves, then hands the terminal to $VISUAL or $EDITOR (vi
+// without either) on the config file; the UI comes back when it exits. A
+// file that doesn't parse is opened as it is, to be fixed.
+func (m *Model) editConfig() tea.Cmd {
+	if m.configError == "" {
+		m.saveConfig()
+		if m.errorMessage != "" {
+			return nil
+		}
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		args = []string{"vi"}
+	}
+	// The path is its own argument, so no character in it needs quoting
+	cmd := exec.Command(args[0], append(args[1:], m.configFile)...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return configEditedMsg{err}
+	})
+}
+
+// reloadConfig picks up the config file after it was edited by hand. A file
+// that no longer parses is left alone, along with the tasks in memory, and
+// saving is off until it is fixed so the edit isn't saved over.
+func (m *Model) reloadConfig() {
+	if _, err := readConfigFile(findConfigFile(m.configPath)); err != nil {
+		m.configError = err.Error()
+		m.errorMessage = fmt.Sprintf("Config not reloaded: %v; saving is off until %s fixes it", err, m.keyMap.EditConfig.Help().Key)
+		return
+	}
+	// Banners already dismissed stay dismissed
+	dueReminder, reviewReminder := m.dueReminder, m.reviewReminder
+	m.replayTutorial = false
+	m.configFormat = ""
+	m.load()
+	if dueReminder == "" {
+		m.dueReminder = ""
+	}
+	if reviewReminder == "" {
+		m.reviewReminder = ""
+	}
+	m.history = nil
+	m.dirty = false
+	if remaining := len(m.getFilteredTasks()); m.selectedIndex >= remaining {
+		m.selectedIndex = max(remaining-1, 0)
+	}
+	m.setStatus("Reloaded " + filepath.Base(m.configFile))
+}
+
+// validateConfigFile checks a config file without changing it and returns
+// every problem found. A non-nil error means the file could not be parsed at all.
+func validateConfigFile(path string) ([]string, error) {
+	codec, err := configCodecFor(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := codec.Unmarshal(data, &config); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("invalid JSON at byte %d: %v", syntaxErr.Offset, err)
+		}
+		return nil, err
+	}
+
+	var problems []string
+	seen := make(map[int]bool)
+	contexts := make(map[string]bool)
+	maxID := 0
+	for i, task := range config.Tasks {
+		where := fmt.Sprintf("task %d (id %d)", i+1, task.ID)
+		if seen[task.ID] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate id", where))
+		}
+		seen[task.ID] = true
+		maxID = max(maxID, task.ID)
+
+		if strings.TrimSpace(task.Task) == "" {
+			problems = append(problems, fmt.Sprintf("%s: empty task text", where))
+		}
+		if strings.TrimSpace(task.Context) == "" {
+			problems = append(problems, fmt.Sprintf("%s: no context", where))
+		}
+		contexts[task.Context] = true
+		if _, ok := priorityRank[task.Priority]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown priority %q", where, task.Priority))
+		}
+		if task.DueDate != "" {
+			if _, _, err := parseDueDate(task.DueDate); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid due date %q", where, task.DueDate))
+			}
+		}
+		if task.StartDate != "" {
+			if _, err := time.ParseInLocation(dueDateLayout, task.StartDate, time.Local); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid start date %q", where, task.StartDate))
+			} else if start, _, _ := dueSpan(task); task.DueDate == "" || start != task.StartDate {
+				problems = append(problems, fmt.Sprintf("%s: start date %q without a later due date", where, task.StartDate))
+			}
+		}
+		if _, err := time.Parse(time.RFC3339, task.CreatedAt); task.CreatedAt != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid created_at %q", where, task.CreatedAt))
+		}
+		if _, err := time.Parse(time.RFC3339, task.CompletedAt); task.CompletedAt != "" && err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid completed_at %q", where, task.CompletedAt))
+		}
+	}
+
+	if config.NextID != 0 && config.NextID <= maxID {
+		problems = append(problems, fmt.Sprintf("next_id %d is not above the highest task id %d", config.NextID, maxID))
+	}
+	for i, task := range config.Tasks {
+		if task.ParentID != 0 && !seen[task.ParentID] {
+			problems = append(problems, fmt.Sprintf("task %d (id %d): parent_id %d names no task", i+1, task.ID, task.ParentID))
+		}
+	}
+
+	// Per-context settings should point at contexts that exist
+	for _, ctx := range config.Templates {
+		if !contexts[ctx] {
+			problems = append(problems, fmt.Sprintf("template_contexts: no tasks in context %q", ctx))
+		}
+	}
+	var unknown []string
+	for ctx := range config.WIPLimits {
+		if !contexts[ctx] {
+			unknown = append(unknown, fmt.Sprintf("wip_limits: no tasks in context %q", ctx))
+		}
+	}
+	for ctx := range config.DailyGoals {
+		if !contexts[ctx] {
+			unknown = append(unknown, fmt.Sprintf("daily_goals: no tasks in context %q", ctx))
+		}
+	}
+	for ctx := range config.TaskLimits {
+		if !contexts[ctx] {
+			unknown = append(unknown, fmt.Sprintf("task_limits: no tasks in context %q", ctx))
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range config.SortKeys {
+		if _, ok := sortKeyCompare[k]; !ok {
+			unknown = append(unknown, fmt.Sprintf("sort_keys: unknown key %q", k))
+		}
+	}
+	defaults := DefaultKeyMap()
+	for _, k := range config.ToggleKeys {
+		if k == "enter" {
+			unknown = append(unknown, `toggle_keys: "enter" confirms dialogs; set enter_action to toggle instead`)
+		}
+		for _, row := range defaults.FullHelp() {
+			for _, b := range row {
+				if b.Help().Desc != "toggle" && indexOf(b.Keys(), k) >= 0 {
+					unknown = append(unknown, fmt.Sprintf("toggle_keys: %q also means %q", k, b.Help().Desc))
+				}
+			}
+		}
+	}
+	for _, kind := range config.StatsInclude {
+		if indexOf(statsLists, kind) < 0 {
+			unknown = append(unknown, fmt.Sprintf("stats_include: unknown list %q (use %s)", kind, strings.Join(statsLists, ", ")))
+		}
+	}
+	// Settings that take one of a few words; empty means the default
+	enums := []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"kanban_sort", config.KanbanSort, []string{"priority", "weight", "manual"}},
+		{"week_start", strings.ToLower(config.WeekStart), []string{"monday", "sunday"}},
+		{"on_complete", config.OnComplete, []string{"strike", "bottom", "hide", "collapse"}},
+		{"context_sort", config.ContextSort, []string{"alpha", "activity", "overdue"}},
+		{"add_filtered", config.AddFiltered, []string{"apply", "clear"}},
+		{"stats_weight", config.StatsWeight, []string{"count", "priority", "estimate"}},
+		{"enter_action", config.EnterAction, []string{"details", "toggle", "edit"}},
+		{"idle_action", config.IdleAction, []string{"lock", "quit"}},
+		{"add_from_search", config.AddFromSearch, []string{"ask", "inbox", "current"}},
+		{"add_position", config.AddPosition, []string{"bottom", "top"}},
+		{"title_progress", config.TitleProgress, []string{"off", "context", "overall"}},
+		{"rename_collision", config.RenameCollision, []string{"ask", "merge", "refuse"}},
+		{"context_case", config.ContextCase, []string{"merge", "refuse", "exact"}},
+		{"subtask_completion", config.SubtaskCompletion, []string{"cascade", "require", "independent"}},
+		{"stats_sort", config.StatsSort, []string{"list", "completion", "open"}},
+		{"duplicates", config.Duplicates, []string{"allow", "warn", "block"}},
+		{"emptied_context", config.EmptiedContext, []string{"stay", "next"}},
+		{"repeat_reopen", config.RepeatReopen, []string{"remove", "keep"}},
+		{"search_again", config.SearchAgain, []string{"replace", "refine"}},
+		{"empty_search", config.EmptySearch, []string{"back", "clear", "all"}},
+		{"glyphs.preset", config.Glyphs.Preset, []string{"unicode", "ascii"}},
+		{"priority_marks.preset", config.PriorityMarks.Preset, []string{"marks", "numbers", "block", "text"}},
+		{"theme.background", config.Theme.Background, []string{"auto", "light", "dark"}},
+		{"theme.selection", config.Theme.Selection, []string{"background", "cursor", "both"}},
+	}
+	for _, e := range enums {
+		if e.value != "" && indexOf(e.allowed, e.value) < 0 {
+			unknown = append(unknown, fmt.Sprintf("%s: unknown value %q (use %s)", e.name, e.value, strings.Join(e.allowed, ", ")))
+		}
+	}
+	views := make([]string, 0, len(config.ViewSort))
+	for view := range config.ViewSort {
+		views = append(views, view)
+	}
+	sort.Strings(views)
+	for _, view := range views {
+		if view != "normal" && view != "kanban" && view != "search" {
+			unknown = append(unknown, fmt.Sprintf("view_sort: unknown view %q", view))
+		}
+		for _, k := range config.ViewSort[view] {
+			if _, ok := sortKeyCompare[k]; !ok {
+				unknown = append(unknown, fmt.Sprintf("view_sort.%s: unknown key %q", view, k))
+			}
+		}
+	}
+	views = views[:0]
+	for view := range config.ViewComplete {
+		views = append(views, view)
+	}
+	sort.Strings(views)
+	for _, view := range views {
+		if indexOf([]string{"normal", "search", "focus", "kanban"}, view) < 0 {
+			unknown = append(unknown, fmt.Sprintf("view_complete: unknown view %q", view))
+		}
+		if value := config.ViewComplete[view]; indexOf([]string{"check", "done", "off"}, value) < 0 {
+			unknown = append(unknown, fmt.Sprintf("view_complete.%s: unknown value %q (use check, done, off)", view, value))
+		}
+	}
+
+	return append(problems, unknown...), nil
+}
+
+// createDefaultConfig starts a first run with an empty list and the tutorial
+func (m *Model) createDefaultConfig() {
+	m.tasks = nil
+	m.nextID = 1
+	m.settings.TutorialStep = 1
+}
+
+func (m *Model) saveConfig() {
+	if m.configError != "" {
+		m.errorMessage = fmt.Sprintf("Not saved: the config file does not parse (%s)", m.configError)
+		return
+	}
+	configFile := m.configFile
+	if configFile == "" {
+		configFile = filepath.Join(m.configPath, "config.json")
+	}
+	codec, err := configCodecFor(configFile)
+	if err != nil {
+		m.errorMessage = fmt.Sprintf("Could not save: %v", err)
+		return
+	}
+	
+	m.pruneMarks()
+	config := Config{
+		Tasks:    m.tasks,
+		NextID:   m.nextID,
+		Settings: m.settings,
+	}
+
+	data, err := codec.Marshal(config)
+	if err != nil {
+		return
+	}
+
+	if err := ioutil.WriteFile(configFile, data, 0644); err != nil {
+		m.errorMessage = fmt.Sprintf("Could not save: %v", err)
+		return
+	}
+	m.dirty = false
+
+	// Set the old format aside, so findConfigFile can't pick it up again
+	if m.convertedFrom != "" {
+		if err := os.Rename(m.convertedFrom, m.convertedFrom+".bak"); err != nil && !os.IsNotExist(err) {
+			m.errorMessage = fmt.Sprintf("Converted, but could not set %s aside: %v", filepath.Base(m.convertedFrom), err)
+		}
+		m.convertedFrom = ""
+	}
+
+	// Push the saved file if a sync command is configured
+	if m.settings.SyncPushCmd != "" {
+		if err := m.runSyncCommand(m.settings.SyncPushCmd); err != nil {
+			m.errorMessage = fmt.Sprintf("Sync push failed: %v", err)
 		}
 	}
-	return inputStyle.Render(content.String())
 }
 
-// renderKanbanView renders the kanban board
-func (m Model) renderKanbanView() string {
-	var content strings.Builder
-	
-	content.WriteString(titleStyle.Render("Kanban View (ESC to return)") + "\n\n")
+// Import
 
-	if len(m.contexts) == 0 {
-		content.WriteString("No contexts available.\n")
-		return baseStyle.Render(content.String())
+// todoistPriorities maps Todoist's PRIORITY column (1 = p1, the most urgent) onto ours
+var todoistPriorities = map[string]string{"1": "high", "2": "medium", "3": "low"}
+
+// todoistDateLayouts are the due date formats understood from Todoist's DATE column
+var todoistDateLayouts = []string{dueDateLayout, dueDateTimeLayout, "Jan 2 2006", "2 Jan 2006", "Jan 2 2006 15:04", "2 Jan 2006 15:04"}
+
+// parseTodoistDate turns a Todoist due string into a DueDate, if it is a
+// plain date rather than a recurrence or other natural language
+func parseTodoistDate(s string, now time.Time) (string, bool) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "today":
+		return now.Format(dueDateLayout), true
+	case "tomorrow":
+		return now.AddDate(0, 0, 1).Format(dueDateLayout), true
+	}
+	for _, layout := range todoistDateLayouts {
+		if due, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return formatDueDate(due, strings.Contains(layout, "15:04")), true
+		}
 	}
+	return "", false
+}
 
-	// Calculate column width
-	colWidth := (m.windowWidth - 4) / len(m.contexts)
-	if colWidth < 20 {
-		colWidth = 20
+// importTodoist adds the tasks of a Todoist project CSV export to a context
+// named after the file. Sections become tags; descriptions, comments,
+// assignees, nesting and due dates that can't be mapped end up in notes.
+func (m *Model) importTodoist(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
 	}
+	defer f.Close()
 
-	// Render columns
-	var columns []string
-	for _, context := range m.contexts {
-		var column strings.Builder
-		
-		// Column header
-		header := contextStyle.Render(context)
-		column.WriteString(header + "\n")
-		column.WriteString(strings.Repeat("─", colWidth) + "\n")
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, fmt.Errorf("empty file")
+	}
 
-		// Tasks in this context
-		tasks := m.getTasksForContext(context)
-		for _, task := range tasks {
-			taskText := task.Task
-			if len(taskText) > colWidth-4 {
-				taskText = taskText[:colWidth-7] + "..."
-			}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["CONTENT"]; !ok {
+		return 0, fmt.Errorf("no CONTENT column; is this a Todoist CSV export?")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
 
-			tags := ""
-			if len(task.Tags) > 0 {
-				tags = " > " + strings.Join(task.Tags, ", ")
+	context := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	now := time.Now()
+	section := ""
+	var parents []string // task text by indent level, for nesting notes
+	var imported []Task
+
+	for _, record := range records[1:] {
+		content := field(record, "CONTENT")
+		switch strings.ToLower(field(record, "TYPE")) {
+		case "section":
+			section = content
+			continue
+		case "note":
+			// Comments belong to the task above them
+			if len(imported) > 0 && content != "" {
+				last := &imported[len(imported)-1]
+				last.Notes = strings.TrimSpace(last.Notes + "\n" + content)
 			}
+			continue
+		case "", "task":
+		default:
+			continue
+		}
+		if content == "" {
+			continue
+		}
 
-			dueDate := ""
-			if task.DueDate != "" {
-				dueDate = fmt.Sprintf(" [Due: %s]", task.DueDate)
-			}
+		task := Task{
+			Task:      content,
+			Context:   context,
+			Priority:  todoistPriorities[field(record, "PRIORITY")],
+			CreatedAt: now.Format(time.RFC3339),
+		}
+		if section != "" {
+			task.Tags = []string{section}
+		}
 
-			if task.Checked {
-				column.WriteString(completedTaskStyle.Render(fmt.Sprintf("✓ %s%s%s", taskText, tags, dueDate)) + "\n")
+		var notes []string
+		if description := field(record, "DESCRIPTION"); description != "" {
+			notes = append(notes, description)
+		}
+		if date := field(record, "DATE"); date != "" {
+			if due, ok := parseTodoistDate(date, now); ok {
+				task.DueDate = due
 			} else {
-				column.WriteString(taskStyle.Render(fmt.Sprintf("• %s%s%s", taskText, tags, dueDate)) + "\n")
+				notes = append(notes, "Todoist due: "+date)
 			}
 		}
+		if indent, err := strconv.Atoi(field(record, "INDENT")); err == nil && indent > 0 {
+			if indent > 1 && indent-2 < len(parents) {
+				notes = append(notes, "Subtask of: "+parents[indent-2])
+			}
+			parents = append(parents[:min(indent-1, len(parents))], content)
+		}
+		if responsible := field(record, "RESPONSIBLE"); responsible != "" {
+			notes = append(notes, "Assigned to: "+responsible)
+		}
+		task.Notes = strings.Join(notes, "\n")
 
-		columns = append(columns, column.String())
+		imported = append(imported, task)
 	}
 
-	// Combine columns side by side (simplified - in real implementation you'd use lipgloss.JoinHorizontal)
-	for i, col := range columns {
-		if i > 0 {
-			content.WriteString(" | ")
-		}
-		content.WriteString(col)
+	for _, task := range imported {
+		task.ID = m.nextID
+		task.Code = m.nextCode(task.Context)
+		m.nextID++
+		m.tasks = append(m.tasks, task)
 	}
-
-	return baseStyle.Render(content.String())
+	m.updateContexts()
+	return len(imported), nil
 }
 
-// renderStatsView renders the statistics view
-func (m Model) renderStatsView() string {
-	var content strings.Builder
-	
-	content.WriteString(titleStyle.Render("Statistics (ESC to return)") + "\n\n")
-
-	// Overall stats
-	total := len(m.tasks)
-	completed := 0
-	for _, task := range m.tasks {
-		if task.Checked {
-			completed++
-		}
-	}
-
-	completionRate := 0.0
-	if total > 0 {
-		completionRate = float64(completed) / float64(total) * 100
+// markdownItem matches a checklist line: indent, check mark and text
+var markdownItem = regexp.MustCompile(`^(\s*)[-*+] \[([ xX])\] (.+)$`)
+
+// markdownHeading matches a heading line: its level and title
+var markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*$`)
+
+// importMarkdown adds the tasks of a Markdown checklist. Headings become
+// contexts, nested ones joined into subcontexts; tasks before any heading go
+// to a context named after the file. Indented items become subtasks of the
+// item above, deeper levels flattened into one. Everything the Markdown
+// export writes is read back: the "(priority)", "due DATE", "#tag" and
+// "@context" endings, items wrapped onto indented lines, and exports grouped
+// by tag, whose "## #tag" sections list a task once per tag.
+func (m *Model) importMarkdown(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
 	}
 
-	content.WriteString(fmt.Sprintf("Total Tasks: %d\n", total))
-	content.WriteString(fmt.Sprintf("Completed: %d (%.1f%%)\n\n", completed, completionRate))
+	fallback := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var headings []string // titles of the open headings, outermost first
+	var levels []int      // and their levels
+	tagSection := false   // under a "## #tag" heading of an export grouped by tag
+	seen := make(map[string]bool)
+	now := time.Now().Format(time.RFC3339)
+	parent := 0 // id of the last top-level item, for subtasks
+	count := 0
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		if match := markdownHeading.FindStringSubmatch(lines[i]); match != nil {
+			// A heading closes those at its level or deeper, whatever level the file starts at
+			level := len(match[1])
+			for len(levels) > 0 && levels[len(levels)-1] >= level {
+				headings, levels = headings[:len(headings)-1], levels[:len(levels)-1]
+			}
+			headings, levels = append(headings, match[2]), append(levels, level)
+			tagSection = strings.HasPrefix(match[2], "#")
+			parent = 0
+			continue
+		}
+		match := markdownItem.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
 
-	// Context stats
-	content.WriteString("Context Statistics:\n")
-	for _, context := range m.contexts {
-		tasks := m.getTasksForContext(context)
-		ctxTotal := len(tasks)
-		ctxCompleted := 0
-		for _, task := range tasks {
-			if task.Checked {
-				ctxCompleted++
+		// A wrapped item goes on over the indented lines after it
+		item := match[3]
+		for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && strings.TrimLeft(lines[i+1], " \t") != lines[i+1] && !markdownItem.MatchString(lines[i+1]) {
+			i++
+			item += " " + strings.TrimSpace(lines[i])
+		}
+		if tagSection {
+			if seen[match[2]+item] {
+				continue
 			}
+			seen[match[2]+item] = true
 		}
 
-		ctxRate := 0.0
-		if ctxTotal > 0 {
-			ctxRate = float64(ctxCompleted) / float64(ctxTotal) * 100
+		context := fallback
+		if len(headings) > 0 && !tagSection {
+			context = strings.Join(headings, contextSeparator)
+		}
+		task := Task{ID: m.nextID, Context: context, Checked: match[2] != " ", CreatedAt: now}
+		var named string
+		task.Task, task.Priority, task.DueDate, named, task.Tags = parseMarkdownItem(item)
+		if named != "" {
+			task.Context = named
+		}
+		if task.Checked {
+			task.CompletedAt = now
+		}
+		task.Code = m.nextCode(task.Context)
+		if indent := len(strings.ReplaceAll(match[1], "\t", "    ")); indent > 0 && parent != 0 {
+			task.ParentID = parent
+		} else {
+			parent = task.ID
 		}
 
-		content.WriteString(fmt.Sprintf("  %s: %d/%d (%.1f%%)\n", 
-			contextStyle.Render(context), ctxCompleted, ctxTotal, ctxRate))
+		m.nextID++
+		m.tasks = append(m.tasks, task)
+		count++
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("no - [ ] checklist items found")
 	}
+	m.updateContexts()
+	return count, nil
+}
 
-	return baseStyle.Render(content.String())
+// parseMarkdownItem splits an exported checklist item into its text and the
+// priority, due date, tags and context written after it
+func parseMarkdownItem(item string) (text, priority, due, context string, tags []string) {
+	item = strings.TrimSpace(item)
+	if i := strings.LastIndex(item, " @["); i > 0 && strings.HasSuffix(item, "]") {
+		item, context = item[:i], item[i+3:len(item)-1]
+	}
+	fields := strings.Fields(item)
+	if n := len(fields); context == "" && n > 1 && strings.HasPrefix(fields[n-1], "@") && len(fields[n-1]) > 1 {
+		context, fields = fields[n-1][1:], fields[:n-1]
+	}
+	for len(fields) > 1 && strings.HasPrefix(fields[len(fields)-1], "#") && len(fields[len(fields)-1]) > 1 {
+		tags = append([]string{fields[len(fields)-1][1:]}, tags...)
+		fields = fields[:len(fields)-1]
+	}
+	if n := len(fields); n > 3 && fields[n-3] == "due" {
+		if d, ok := parseDueInput(fields[n-2] + " " + fields[n-1]); ok && d != "" {
+			due, fields = d, fields[:n-3]
+		}
+	}
+	if n := len(fields); due == "" && n > 2 && fields[n-2] == "due" {
+		if d, ok := parseDueInput(fields[n-1]); ok && d != "" {
+			due, fields = d, fields[:n-2]
+		}
+	}
+	if n := len(fields); n > 1 {
+		if p := strings.Trim(fields[n-1], "()"); p != fields[n-1] && p != "" {
+			if _, ok := priorityRank[p]; ok {
+				priority, fields = p, fields[:n-1]
+			}
+		}
+	}
+	return strings.Join(fields, " "), priority, due, context, tags
 }
 
-// Helper methods
+// mergeConflict is a task both configs have under one ID, with different content
+type mergeConflict struct {
+	ours, theirs Task
+}
 
-func (m *Model) showInputDialog(mode InputMode, prompt string) {
-	m.viewMode = InputView
-	m.inputMode = mode
-	m.inputPrompt = prompt
-	m.textInput.SetValue("")
-	m.textInput.Focus()
+// mergeResult tallies what merging another config brings in
+type mergeResult struct {
+	added     int // tasks only the other config had, plus conflicts kept as both
+	same      int // tasks both configs have unchanged
+	conflicts []mergeConflict
+	merged    []Task // tasks added from the other config
+	replaced  []Task // tasks here replaced by their copy, with prefer theirs
 }
 
-func (m *Model) showDateInputDialog() {
-	m.viewMode = DateInputView
-	m.dateInputIndex = 0
-	now := time.Now()
-	m.dateInputs[0].SetValue(fmt.Sprintf("%02d", now.Day()))
-	m.dateInputs[1].SetValue(fmt.Sprintf("%02d", now.Month()))
-	m.dateInputs[2].SetValue(fmt.Sprintf("%d", now.Year()))
-	for i := range m.dateInputs {
-		m.dateInputs[i].Focus()
-	}
+// sameTask reports whether a task here and one from another config are the
+// same task. IDs are handed out separately on each machine, so an ID alone
+// doesn't say so; the time the task was created has to match too.
+func sameTask(ours, theirs Task) bool {
+	return ours.ID == theirs.ID && ours.CreatedAt != "" && ours.CreatedAt == theirs.CreatedAt
 }
 
-func (m *Model) showRemoveTagDialog() {
-	task := m.getCurrentTask()
-	if len(task.Tags) == 0 {
-		m.errorMessage = "No tags to remove"
-		return
+// mergeConfig merges the tasks of another tuido config into this one. A task
+// with the same ID and creation time as one here is the same task: identical
+// ones are skipped, and one changed on both sides is a conflict settled by
+// prefer: both (default) adds their copy under a fresh ID, ours keeps ours,
+// theirs takes theirs. Every other task is added, under a fresh ID if its own
+// is taken, and its subtasks follow it. Contexts come with the tasks, along
+// with their scratchpads, template and archived marks and code counters.
+func (m *Model) mergeConfig(path, prefer string) (mergeResult, error) {
+	var result mergeResult
+	other, err := readConfigFile(path)
+	if err != nil {
+		return result, err
 	}
-	m.viewMode = RemoveTagView
-	m.removeTagIndex = 0
-	m.removeTagChecks = make([]bool, len(task.Tags))
-}
+	m.mergeContextSettings(other.Settings)
 
-func (m *Model) getFilteredTasks() []Task {
-	if m.viewMode == SearchView {
-		return m.searchResults
+	// Fresh IDs start past everything either side has handed out
+	m.nextID = max(m.nextID, other.NextID)
+	for _, task := range other.Tasks {
+		m.nextID = max(m.nextID, task.ID+1)
 	}
-	return m.getTasksForContext(m.currentContext)
-}
 
-func (m *Model) getTasksForContext(context string) []Task {
-	var filtered []Task
-	for _, task := range m.tasks {
-		if task.Context == context {
-			filtered = append(filtered, task)
+	renumbered := make(map[int]int)
+	var incoming []Task
+	for _, task := range other.Tasks {
+		i := m.findTaskIndex(task.ID)
+		if i < 0 {
+			incoming = append(incoming, task)
+			continue
+		}
+		ours, _ := json.Marshal(m.tasks[i])
+		theirs, _ := json.Marshal(task)
+		if bytes.Equal(ours, theirs) {
+			result.same++
+			continue
+		}
+		if !sameTask(m.tasks[i], task) {
+			// An unrelated task that happens to share the ID
+			renumbered[task.ID] = m.nextID
+			task.ID = m.nextID
+			m.nextID++
+			incoming = append(incoming, task)
+			continue
 		}
-	}
-	return filtered
-}
 
-func (m *Model) getCurrentTask() Task {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 || m.selectedIndex >= len(tasks) {
-		return Task{}
+		result.conflicts = append(result.conflicts, mergeConflict{ours: m.tasks[i], theirs: task})
+		switch prefer {
+		case "ours":
+		case "theirs":
+			m.tasks[i] = task
+			result.replaced = append(result.replaced, task)
+		default:
+			renumbered[task.ID] = m.nextID
+			task.ID = m.nextID
+			m.nextID++
+			incoming = append(incoming, task)
+		}
 	}
-	return tasks[m.selectedIndex]
-}
 
-func (m *Model) moveUp() {
-	tasks := m.getFilteredTasks()
-	if len(tasks) > 0 {
-		m.selectedIndex = (m.selectedIndex - 1 + len(tasks)) % len(tasks)
+	for _, task := range incoming {
+		if id, ok := renumbered[task.ParentID]; ok {
+			task.ParentID = id
+		}
+		if id, ok := renumbered[task.Source]; ok {
+			task.Source = id
+		}
+		// A short code already used here is given the next free one instead
+		if task.Code != "" && m.findTaskByRef(task.Code) >= 0 {
+			task.Code = m.nextCode(task.Context)
+		}
+		m.tasks = append(m.tasks, task)
+		result.added++
+		result.merged = append(result.merged, task)
 	}
+	m.updateContexts()
+	return result, nil
 }
 
-func (m *Model) moveDown() {
-	tasks := m.getFilteredTasks()
-	if len(tasks) > 0 {
-		m.selectedIndex = (m.selectedIndex + 1) % len(tasks)
+// mergeContextSettings brings in the per-context settings of another config:
+// scratchpads we don't have (or theirs below ours when both differ), template
+// and archived contexts, code prefixes we lack, and the higher code counter
+func (m *Model) mergeContextSettings(other Settings) {
+	for context, note := range other.Scratchpads {
+		ours := m.settings.Scratchpads[context]
+		if note == "" || strings.Contains(ours, note) {
+			continue
+		}
+		if m.settings.Scratchpads == nil {
+			m.settings.Scratchpads = make(map[string]string)
+		}
+		if ours != "" {
+			note = ours + "\n\n" + note
+		}
+		m.settings.Scratchpads[context] = note
 	}
-}
-
-func (m *Model) moveTaskUp() {
-	tasks := m.getFilteredTasks()
-	if m.selectedIndex > 0 {
-		taskToMove := tasks[m.selectedIndex]
-		for i := range m.tasks {
-			if m.tasks[i].ID == taskToMove.ID {
-				m.tasks[i], m.tasks[i-1] = m.tasks[i-1], m.tasks[i]
-				break
+	for _, context := range other.Templates {
+		if !m.isTemplateContext(context) {
+			m.settings.Templates = append(m.settings.Templates, context)
+		}
+	}
+	for _, context := range other.Archived {
+		if !m.isArchived(context) {
+			m.settings.Archived = append(m.settings.Archived, context)
+		}
+	}
+	for context, prefix := range other.CodePrefixes {
+		if _, ok := m.settings.CodePrefixes[context]; !ok {
+			if m.settings.CodePrefixes == nil {
+				m.settings.CodePrefixes = make(map[string]string)
 			}
+			m.settings.CodePrefixes[context] = prefix
 		}
-		m.selectedIndex--
 	}
-}
-
-func (m *Model) moveTaskDown() {
-	tasks := m.getFilteredTasks()
-	if m.selectedIndex < len(tasks)-1 {
-		taskToMove := tasks[m.selectedIndex]
-		for i := range m.tasks {
-			if m.tasks[i].ID == taskToMove.ID {
-				m.tasks[i], m.tasks[i+1] = m.tasks[i+1], m.tasks[i]
-				break
+	for prefix, n := range other.CodeCounters {
+		if n > m.settings.CodeCounters[prefix] {
+			if m.settings.CodeCounters == nil {
+				m.settings.CodeCounters = make(map[string]int)
 			}
+			m.settings.CodeCounters[prefix] = n
 		}
-		m.selectedIndex++
 	}
 }
 
-func (m *Model) nextContext() {
-	if len(m.contexts) > 0 {
-		currentIdx := m.findContextIndex(m.currentContext)
-		nextIdx := (currentIdx + 1) % len(m.contexts)
-		m.currentContext = m.contexts[nextIdx]
-		m.selectedIndex = 0
-	}
-}
+// Export
 
-func (m *Model) previousContext() {
-	if len(m.contexts) > 0 {
-		currentIdx := m.findContextIndex(m.currentContext)
-		prevIdx := (currentIdx - 1 + len(m.contexts)) % len(m.contexts)
-		m.currentContext = m.contexts[prevIdx]
-		m.selectedIndex = 0
-	}
-}
+// listTasks prints tasks for scripting, either as plain lines or as JSON lines
+func (m *Model) listTasks(w io.Writer, context string, all, jsonl bool) error {
+	enc := json.NewEncoder(w)
+	for _, task := range m.tasks {
+		if context != "" && task.Context != context {
+			continue
+		}
+		if task.Checked && !all {
+			continue
+		}
 
-func (m *Model) findContextIndex(context string) int {
-	for i, ctx := range m.contexts {
-		if ctx == context {
-			return i
+		if jsonl {
+			if err := enc.Encode(task); err != nil {
+				return err
+			}
+			continue
+		}
+
+		checkbox := m.glyphs.Unchecked
+		if task.Checked {
+			checkbox = m.glyphs.Checked
+		}
+		id := strconv.Itoa(task.ID)
+		if task.Code != "" {
+			id += " " + task.Code
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s (%s)\n", checkbox, id, firstLine(task.Task), task.Context); err != nil {
+			return err
 		}
 	}
-	return 0
+	return nil
 }
 
-func (m *Model) toggleCurrentTask() {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		return
+// addTaskTo adds a task from the command line to context, or to the inbox,
+// returning the new task
+func (m *Model) addTaskTo(context, text string) (Task, error) {
+	if context == "" {
+		context = m.settings.Inbox
 	}
-
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			m.tasks[i].Checked = !m.tasks[i].Checked
-			break
+	if context != "" {
+		context = m.contextNamed(context)
+		if m.findContextIndex(context) < 0 {
+			m.contexts = append(m.contexts, context)
 		}
+		m.currentContext = context
 	}
-}
-
-func (m *Model) addTask(taskText string) {
-	newTask := Task{
-		ID:      m.nextID,
-		Task:    taskText,
-		Checked: false,
-		Context: m.currentContext,
+	if !m.addTask(text) {
+		return Task{}, errors.New(m.errorMessage)
 	}
-	m.tasks = append(m.tasks, newTask)
-	m.nextID++
-	
-	// Move selection to new task
-	filtered := m.getFilteredTasks()
-	m.selectedIndex = len(filtered) - 1
+	return m.tasks[m.findTaskIndex(m.nextID-1)], nil
 }
 
-func (m *Model) editCurrentTask(newText string) {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		return
+// completeTaskByRef checks off the task a command line names by ID or short
+// code, the same way space does in its context's list
+func (m *Model) completeTaskByRef(ref string) (Task, error) {
+	i := m.findTaskByRef(ref)
+	if i < 0 {
+		return Task{}, fmt.Errorf("no task %s", ref)
 	}
-
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			m.tasks[i].Task = newText
-			break
-		}
+	task := m.tasks[i]
+	if task.Checked {
+		return task, fmt.Errorf("%s is already done", taskRef(task))
 	}
-}
 
-func (m *Model) deleteCurrentTask() {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		return
+	m.currentContext = task.Context
+	m.dueOnly = false
+	m.categoryFilter = ""
+	m.extrasOnly = false
+	m.showCompleted = true
+	if !m.lists(task.ID) {
+		return task, fmt.Errorf("%s can't be completed from its list", taskRef(task))
 	}
-
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			m.tasks = append(m.tasks[:i], m.tasks[i+1:]...)
-			break
-		}
+	m.selectTask(task.ID)
+	if !m.toggleCurrentTask() {
+		return task, errors.New(m.errorMessage)
 	}
+	return m.tasks[m.findTaskIndex(task.ID)], nil
+}
 
-	// Adjust selection
-	newTasks := m.getFilteredTasks()
-	if m.selectedIndex >= len(newTasks) && len(newTasks) > 0 {
-		m.selectedIndex = len(newTasks) - 1
+// printAgenda writes the overdue and due-today tasks grouped by context, or
+// as a JSON object with "overdue" and "today" lists
+func (m *Model) printAgenda(w io.Writer, asJSON bool) error {
+	overdue, today := m.agenda()
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Overdue []Task `json:"overdue"`
+			Today   []Task `json:"today"`
+		}{append([]Task{}, overdue...), append([]Task{}, today...)})
 	}
-}
 
-func (m *Model) addContext(contextName string) {
-	// Check if context already exists
-	for _, ctx := range m.contexts {
-		if ctx == contextName {
-			m.errorMessage = "Context already exists"
-			return
+	var b strings.Builder
+	for _, section := range []struct {
+		title string
+		tasks []Task
+	}{{"Overdue", overdue}, {"Due today", today}} {
+		if len(section.tasks) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(section.title + "\n")
+		context := ""
+		for i, task := range section.tasks {
+			if i == 0 || task.Context != context {
+				context = task.Context
+				b.WriteString(fmt.Sprintf("  %s\n", context))
+			}
+			b.WriteString(fmt.Sprintf("    %s %d %s [%s]\n", m.glyphs.Unchecked, task.ID, firstLine(task.Task), task.DueDate))
 		}
 	}
-
-	m.contexts = append(m.contexts, contextName)
-	m.currentContext = contextName
-	m.selectedIndex = 0
+	if b.Len() == 0 {
+		b.WriteString("Nothing overdue or due today\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
 }
 
-func (m *Model) renameContext(newName string) {
-	if newName == m.currentContext {
-		return
+// writeReport writes the stats as a Markdown report: overall and per-context
+// completion, a priority breakdown and the completions of the last week
+func (m *Model) writeReport(w io.Writer) error {
+	var b strings.Builder
+	now := time.Now()
+	b.WriteString("# tuido report\n\n")
+	b.WriteString(fmt.Sprintf("Generated %s\n\n", now.Format("2006-01-02 15:04")))
+
+	overall := m.completionOf(m.tasks)
+	b.WriteString("## Overall\n\n")
+	b.WriteString(fmt.Sprintf("- Total tasks: %d\n", overall.total))
+	b.WriteString(fmt.Sprintf("- Completed: %d (%.1f%%)\n", overall.done, overall.rate()))
+	if basis, ok := m.statsWeightBasis(); ok {
+		b.WriteString(fmt.Sprintf("- Completed by %s: %.1f%%\n", basis, m.weightedCompletion(m.tasks)))
 	}
 
-	// Check if new name already exists
-	for _, ctx := range m.contexts {
-		if ctx == newName {
-			m.errorMessage = "Context name already exists"
-			return
+	b.WriteString("\n## By priority\n\n| Priority | Done | Total | % |\n|---|---|---|---|\n")
+	for _, priority := range []string{"high", "medium", "low", ""} {
+		var tasks []Task
+		for _, task := range m.tasks {
+			if task.Priority == priority {
+				tasks = append(tasks, task)
+			}
+		}
+		stats := m.completionOf(tasks)
+		if stats.total == 0 {
+			continue
 		}
+		name := priority
+		if name == "" {
+			name = "none"
+		}
+		b.WriteString(fmt.Sprintf("| %s | %d | %d | %.1f%% |\n", name, stats.done, stats.total, stats.rate()))
 	}
 
-	oldName := m.currentContext
-
-	// Update context in contexts list
-	for i, ctx := range m.contexts {
-		if ctx == oldName {
-			m.contexts[i] = newName
-			break
+	b.WriteString("\n## By context\n\n| Context | Done | Total | % |\n|---|---|---|---|\n")
+	for _, context := range m.contexts {
+		if !m.countsInStats(context) {
+			continue
 		}
+		stats := m.completionOf(m.getTasksForContext(context))
+		b.WriteString(fmt.Sprintf("| %s | %d | %d | %.1f%% |\n", context, stats.done, stats.total, stats.rate()))
 	}
 
-	// Update context in all tasks
-	for i := range m.tasks {
-		if m.tasks[i].Context == oldName {
-			m.tasks[i].Context = newName
-		}
+	// Velocity: completions per day over the last seven days
+	b.WriteString("\n## Last 7 days\n\n")
+	week := 0
+	for i := 6; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i)
+		count := completedOn(m.tasks, day)
+		week += count
+		b.WriteString(fmt.Sprintf("- %s: %d\n", day.Format("Mon 2006-01-02"), count))
 	}
+	b.WriteString(fmt.Sprintf("\nCompleted %d tasks, %.1f per day.\n", week, float64(week)/7))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
 
-	m.currentContext = newName
+// saveReport writes the stats report to path
+func (m *Model) saveReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.writeReport(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func (m *Model) deleteContext() {
-	if len(m.contexts) <= 1 {
-		m.errorMessage = "Cannot delete the only context"
-		return
+// exportICS writes every task with a due date as a VTODO entry
+func (m *Model) exportICS(path string) (int, error) {
+	var b strings.Builder
+	writeLine := func(line string) {
+		b.WriteString(foldICSLine(line) + "\r\n")
 	}
 
-	// Remove all tasks in this context
-	var newTasks []Task
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//tuido//tuido//EN")
+
+	count := 0
 	for _, task := range m.tasks {
-		if task.Context != m.currentContext {
-			newTasks = append(newTasks, task)
+		due, hasTime, err := parseDueDate(task.DueDate)
+		if err != nil {
+			continue
 		}
-	}
-	m.tasks = newTasks
 
-	// Remove context from list
-	var newContexts []string
-	for _, ctx := range m.contexts {
-		if ctx != m.currentContext {
-			newContexts = append(newContexts, ctx)
+		writeLine("BEGIN:VTODO")
+		writeLine(fmt.Sprintf("UID:tuido-%d@tuido", task.ID))
+		writeLine("DTSTAMP:" + stamp)
+		writeLine("SUMMARY:" + escapeICSText(task.Task))
+		if hasTime {
+			writeLine("DUE:" + due.Format("20060102T150405"))
+		} else {
+			writeLine("DUE;VALUE=DATE:" + due.Format("20060102"))
+		}
+		if priority, ok := icsPriority[task.Priority]; ok {
+			writeLine(fmt.Sprintf("PRIORITY:%d", priority))
+		}
+		if len(task.Tags) > 0 {
+			categories := make([]string, len(task.Tags))
+			for i, tag := range task.Tags {
+				categories[i] = escapeICSText(tag)
+			}
+			writeLine("CATEGORIES:" + strings.Join(categories, ","))
 		}
+		if task.Checked {
+			writeLine("STATUS:COMPLETED")
+		} else {
+			writeLine("STATUS:NEEDS-ACTION")
+		}
+		writeLine("END:VTODO")
+		count++
 	}
-	m.contexts = newContexts
 
-	// Switch to first remaining context
-	if len(m.contexts) > 0 {
-		m.currentContext = m.contexts[0]
-		m.selectedIndex = 0
-	}
+	writeLine("END:VCALENDAR")
+	return count, ioutil.WriteFile(path, []byte(b.String()), 0644)
 }
 
-func (m *Model) toggleCurrentTaskPriority() {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		return
-	}
+// icsPriority maps task priorities onto iCalendar's 1 (highest) to 9 (lowest) scale
+var icsPriority = map[string]int{"high": 1, "medium": 5, "low": 9}
 
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			priorities := []string{"", "low", "medium", "high"}
-			currentIdx := 0
-			for j, p := range priorities {
-				if p == m.tasks[i].Priority {
-					currentIdx = j
-					break
-				}
-			}
-			nextIdx := (currentIdx + 1) % len(priorities)
-			m.tasks[i].Priority = priorities[nextIdx]
-			break
+// escapeICSText escapes characters that are special in iCalendar text values
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// foldICSLine splits content lines longer than 75 octets as RFC 5545 requires
+func foldICSLine(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
 		}
+		b.WriteRune(r)
+		width += size
 	}
+	return b.String()
 }
 
-func (m *Model) addTagToCurrentTask(tag string) {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		return
+// exportFileName names an export of context, e.g. work-projecta-2026-01-31.md;
+// an empty context stands for all tasks
+func exportFileName(context, ext string) string {
+	name := "all"
+	if context != "" {
+		name = slugify(strings.ReplaceAll(context, contextSeparator, " "))
 	}
+	return fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), ext)
+}
 
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			// Check if tag already exists
-			for _, existingTag := range m.tasks[i].Tags {
-				if existingTag == tag {
-					return
-				}
+// exportTasks writes tasks to path as Markdown, CSV or JSON, going by the
+// file extension, and returns how many were written. --only-open and
+// --only-done narrow every export, including those made with O.
+func (m *Model) exportTasks(path string, tasks []Task) (int, error) {
+	if m.exportOnly != "" {
+		var kept []Task
+		for _, task := range tasks {
+			if task.Checked == (m.exportOnly == "done") {
+				kept = append(kept, task)
 			}
-			m.tasks[i].Tags = append(m.tasks[i].Tags, tag)
-			break
 		}
+		tasks = kept
+	}
+
+	var b strings.Builder
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		writeMarkdownTasks(&b, tasks, m.exportWrap, m.exportGroup == "tag")
+	case ".csv":
+		if err := writeCSVTasks(&b, tasks); err != nil {
+			return 0, err
+		}
+	case ".json":
+		data, err := json.MarshalIndent(tasks, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		b.Write(append(data, '\n'))
+	default:
+		return 0, fmt.Errorf("unknown export format %q (use .md, .csv or .json)", filepath.Ext(path))
 	}
+	return len(tasks), ioutil.WriteFile(path, []byte(b.String()), 0644)
 }
 
-func (m *Model) removeTagsFromCurrentTask() {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
+// markdownIndent lines continuation lines up with the text of a checklist item
+const markdownIndent = "      "
+
+// writeMarkdownTasks writes tasks as a checklist under a heading per context,
+// or per tag when byTag is set, soft-wrapping items longer than wrap columns
+// when wrap is above zero
+func writeMarkdownTasks(b *strings.Builder, tasks []Task, wrap int, byTag bool) {
+	if byTag {
+		writeMarkdownByTag(b, tasks, wrap)
 		return
 	}
+	// Tasks of a context can be spread through the list, so they are gathered
+	// under one heading each, in the order the contexts first show up
+	var contexts []string
+	groups := make(map[string][]Task)
+	for _, task := range tasks {
+		if _, ok := groups[task.Context]; !ok {
+			contexts = append(contexts, task.Context)
+		}
+		groups[task.Context] = append(groups[task.Context], task)
+	}
+	for i, context := range contexts {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("## " + context + "\n\n")
+		for _, task := range groups[context] {
+			b.WriteString(markdownChecklistItem(task, wrap, false) + "\n")
+		}
+	}
+}
 
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			var newTags []string
-			for j, tag := range m.tasks[i].Tags {
-				if !m.removeTagChecks[j] {
-					newTags = append(newTags, tag)
-				}
+// writeMarkdownByTag writes a section per tag, in alphabetical order, with
+// every task carrying that tag, so a task shows up once for each of its tags.
+// Untagged tasks come last.
+func writeMarkdownByTag(b *strings.Builder, tasks []Task, wrap int) {
+	sections := make(map[string][]Task)
+	var tags []string
+	var untagged []Task
+	for _, task := range tasks {
+		if len(task.Tags) == 0 {
+			untagged = append(untagged, task)
+		}
+		for _, tag := range task.Tags {
+			if _, ok := sections[tag]; !ok {
+				tags = append(tags, tag)
 			}
-			m.tasks[i].Tags = newTags
-			break
+			sections[tag] = append(sections[tag], task)
 		}
 	}
-}
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i]) < strings.ToLower(tags[j]) })
 
-func (m *Model) setDueDateForCurrentTask(dateStr string) {
-	tasks := m.getFilteredTasks()
-	if len(tasks) == 0 {
-		return
+	write := func(heading string, tasks []Task) {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("## " + heading + "\n\n")
+		for _, task := range tasks {
+			b.WriteString(markdownChecklistItem(task, wrap, true) + "\n")
+		}
+	}
+	for _, tag := range tags {
+		write("#"+tag, sections[tag])
+	}
+	if len(untagged) > 0 {
+		write("Untagged", untagged)
 	}
+}
 
-	currentTask := tasks[m.selectedIndex]
-	for i := range m.tasks {
-		if m.tasks[i].ID == currentTask.ID {
-			if strings.ToLower(dateStr) == "clear" {
-				m.tasks[i].DueDate = ""
-			} else if dateStr != "" {
-				// Basic date validation (YYYY-MM-DD format)
-				parts := strings.Split(dateStr, "-")
-				if len(parts) == 3 {
-					if year, err := strconv.Atoi(parts[0]); err == nil && year > 1900 && year < 3000 {
-						if month, err := strconv.Atoi(parts[1]); err == nil && month >= 1 && month <= 12 {
-							if day, err := strconv.Atoi(parts[2]); err == nil && day >= 1 && day <= 31 {
-								m.tasks[i].DueDate = dateStr
-								return
-							}
-						}
-					}
-				}
-				m.errorMessage = "Invalid date format. Use YYYY-MM-DD"
-			}
-			break
+// markdownChecklistItem renders a task as a checklist item, naming its context with
+// withContext for exports not grouped by context
+func markdownChecklistItem(task Task, wrap int, withContext bool) string {
+	check := " "
+	if task.Checked {
+		check = "x"
+	}
+	line := fmt.Sprintf("- [%s] %s", check, firstLine(task.Task))
+	if task.Priority != "" {
+		line += " (" + task.Priority + ")"
+	}
+	if task.DueDate != "" {
+		line += " due " + task.DueDate
+	}
+	for _, tag := range task.Tags {
+		line += " #" + tag
+	}
+	if withContext {
+		// A name with spaces is bracketed so the import can tell where it starts
+		if strings.ContainsAny(task.Context, " \t") {
+			line += " @[" + task.Context + "]"
+		} else {
+			line += " @" + task.Context
 		}
 	}
+	if wrap > 0 {
+		line = strings.Join(wrapWords(line, wrap, markdownIndent), "\n")
+	}
+	return line
 }
 
-func (m *Model) searchTasks(query string) {
-	var results []Task
-	query = strings.ToLower(query)
-	
-	for _, task := range m.tasks {
-		if strings.Contains(strings.ToLower(task.Task), query) {
-			results = append(results, task)
+// wrapWords breaks text into lines of at most width columns at spaces,
+// starting every line after the first with indent. A word too long for a
+// line is left whole on its own.
+func wrapWords(text string, width int, indent string) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case runewidth.StringWidth(line)+1+runewidth.StringWidth(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = indent + word
 		}
 	}
+	return append(lines, line)
+}
 
-	if len(results) == 0 {
-		m.errorMessage = fmt.Sprintf("No tasks matching '%s'", query)
-		return
+// writeCSVTasks writes tasks as CSV with a header row
+func writeCSVTasks(b *strings.Builder, tasks []Task) error {
+	w := csv.NewWriter(b)
+	w.Write([]string{"id", "task", "done", "context", "priority", "tags", "due", "created", "completed"})
+	for _, task := range tasks {
+		w.Write([]string{
+			strconv.Itoa(task.ID), task.Task, strconv.FormatBool(task.Checked), task.Context,
+			task.Priority, strings.Join(task.Tags, ","), task.DueDate, task.CreatedAt, task.CompletedAt,
+		})
 	}
+	w.Flush()
+	return w.Error()
+}
 
-	m.prevContext = m.currentContext
-	m.prevIndex = m.selectedIndex
-	m.searchResults = results
-	m.viewMode = SearchView
-	m.selectedIndex = 0
+// KeyMap methods to implement help.KeyMap interface
+func (k KeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Nav, k.Toggle, k.Add, k.Edit, k.Delete, k.Quit}
 }
 
-func (m *Model) exitSearchMode() {
-	m.viewMode = NormalView
-	m.currentContext = m.prevContext
-	m.selectedIndex = m.prevIndex
-	m.searchResults = nil
+func (k KeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Nav},
+		{k.Toggle, k.FollowUp, k.ToggleAll, k.Add, k.AddSubtask, k.Fold, k.FoldCompleted, k.Edit, k.Delete, k.Move},
+		{k.AddContext, k.RenameContext, k.DeleteContext, k.LastContext, k.FileTo, k.SetMark, k.JumpMark, k.MarkTemplate, k.TaskTemplate, k.SpawnTemplate, k.CloneContext, k.Someday, k.Park, k.DoneList, k.SweepDone, k.Archive, k.Restore, k.LockContext, k.FoldContext, k.Scratchpad, k.FoldScratchpad},
+		{k.TogglePriority, k.LowerPriority, k.SetPriority, k.AddTag, k.RemoveTag, k.SetDueDate, k.ClearDueDate, k.BatchDueDate, k.SetCategory, k.SetEstimate, k.SetPercent, k.SetSchedule},
+		{k.Search, k.Recent, k.FlagToday, k.TodayView, k.DueFilter, k.ExtrasFilter, k.NextDue, k.NextTagged, k.MarkReviewed, k.CategoryFilter, k.KanbanView, k.StatsView, k.ShowIDs, k.GroupPriority, k.SortDirection, k.RelativeDates, k.Details, k.Focus, k.Notes, k.OpenURL, k.Attach, k.Copy, k.CopyBranch, k.SetRef, k.Paste},
+		{k.Undo, k.ExportContext, k.Keys, k.Messages, k.EditConfig, k.Back, k.Quit, k.QuitNoSave},
+	}
 }
 
-func (m *Model) updateContexts() {
-	contextMap := make(map[string]bool)
-	for _, task := range m.tasks {
-		contextMap[task.Context] = true
+// ReferenceHelp returns every binding for the keybinding reference: the
+// full help with navigation spelled out, plus keys used inside dialogs and views
+func (k KeyMap) ReferenceHelp() [][]key.Binding {
+	rows := [][]key.Binding{{k.Up, k.Down, k.Left, k.Right, k.Enter}}
+	rows = append(rows, k.FullHelp()[1:]...)
+	return append(rows, []key.Binding{k.MultiLine, k.Expand, k.Commit, k.Calendar, k.DateRange, k.SelectAll, k.TagUp, k.TagDown, k.Report, k.StatsOrder, k.KanbanCompact, k.Snooze})
+}
+
+// Main function
+func main() {
+	capture := flag.Bool("capture", false, "open straight into add task, save on enter and exit")
+	multi := flag.Bool("multi", false, "with --capture, keep capturing until an empty entry")
+	exportICS := flag.String("export-ics", "", "write tasks with due dates to an iCalendar `file` and exit")
+	list := flag.Bool("list", false, "print open tasks and exit")
+	jsonl := flag.Bool("jsonl", false, "with --list, print one JSON object per task")
+	listContext := flag.String("context", "", "with --list or --export, only tasks in this `context`; with --capture or --add, add to it instead of the inbox")
+	listAll := flag.Bool("all", false, "with --list, include completed tasks")
+	validate := flag.Bool("validate", false, "check the config file for problems and exit")
+	onlyOpen := flag.Bool("only-open", false, "export only open tasks, with --export or O")
+	onlyDone := flag.Bool("only-done", false, "export only completed tasks, with --export or O")
+	groupBy := flag.String("group-by", "context", "head Markdown exports by context or tag (`field`); a task with several tags shows under each")
+	wrap := flag.Int("wrap", 0, "wrap Markdown export lines at `column` (0 = no wrapping)")
+	tutorial := flag.Bool("tutorial", false, "show the first-run tutorial again")
+	format := flag.String("format", "", "store tasks and settings as json, toml or yaml (`format`), converting the existing config file")
+	importTodoist := flag.String("import-todoist", "", "add the tasks of a Todoist project CSV export `file` and exit")
+	importMD := flag.String("import-md", "", "add the tasks of a Markdown checklist `file` and exit")
+	report := flag.String("report", "", "write a Markdown stats report to `file` (- for stdout) and exit")
+	export := flag.String("export", "", "write tasks as md, csv or json (`format`) to a file named after --context next to the config, as O does, and exit")
+	agenda := flag.Bool("agenda", false, "print overdue and due-today tasks by context and exit")
+	agendaJSON := flag.Bool("json", false, "with --agenda, print a JSON object")
+	add := flag.String("add", "", "add a task with this `text` to --context, or the inbox, and exit")
+	done := flag.String("done", "", "check off the task with this numeric ID, or else short `code`, and exit")
+	merge := flag.String("merge", "", "merge the tasks of another tuido config `file` by ID and creation time and exit; only reports what would change unless --write")
+	write := flag.Bool("write", false, "with --merge, save the merged tasks")
+	prefer := flag.String("prefer", "both", "with --merge, settle a task changed on both sides: keep both, ours or theirs (`side`)")
+	flag.Parse()
+
+	if *onlyOpen && *onlyDone {
+		fmt.Fprintln(os.Stderr, "Use either --only-open or --only-done")
+		os.Exit(2)
 	}
 
-	m.contexts = make([]string, 0, len(contextMap))
-	for context := range contextMap {
-		m.contexts = append(m.contexts, context)
+	if *groupBy != "context" && *groupBy != "tag" {
+		fmt.Fprintf(os.Stderr, "Unknown --group-by %q; use context or tag\n", *groupBy)
+		os.Exit(2)
 	}
-	sort.Strings(m.contexts)
 
-	// Set current context if not set or if current doesn't exist
-	if m.currentContext == "" || !contextMap[m.currentContext] {
-		if len(m.contexts) > 0 {
-			m.currentContext = m.contexts[0]
-		} else {
-			m.currentContext = "Work" // Default context
-			m.contexts = []string{"Work"}
-		}
+	if *prefer != "both" && *prefer != "ours" && *prefer != "theirs" {
+		fmt.Fprintf(os.Stderr, "Unknown --prefer %q; use both, ours or theirs\n", *prefer)
+		os.Exit(2)
 	}
-}
 
-func (m *Model) saveStateForUndo() {
-	// Deep copy current tasks
-	stateCopy := make([]Task, len(m.tasks))
-	copy(stateCopy, m.tasks)
-	
-	m.history = append(m.history, stateCopy)
-	
-	// Limit history size
-	if len(m.history) > m.maxHistory {
-		m.history = m.history[1:]
+	if *format != "" {
+		if _, ok := configCodecs[*format]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown format %q; use json, toml or yaml\n", *format)
+			os.Exit(2)
+		}
 	}
-}
 
-func (m *Model) undo() {
-	if len(m.history) == 0 {
-		m.errorMessage = "Nothing to undo"
+	if *validate {
+		configFile := findConfigFile(defaultConfigPath())
+		problems, err := validateConfigFile(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", configFile, err)
+			os.Exit(2)
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", configFile, problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", configFile)
 		return
 	}
 
-	// Restore previous state
-	m.tasks = m.history[len(m.history)-1]
-	m.history = m.history[:len(m.history)-1]
-	
-	// Update contexts and ensure current context is valid
-	m.updateContexts()
-	
-	// Reset selection
-	m.selectedIndex = 0
-}
-
-// Configuration and persistence
+	// Headless runs and quick capture need the tasks straight away; the full
+	// UI loads them in the background behind a spinner
+	m := newModel()
+	m.configFormat = *format
+	m.replayTutorial = *tutorial
+	m.exportWrap = *wrap
+	m.exportGroup = *groupBy
+	if *onlyOpen {
+		m.exportOnly = "open"
+	} else if *onlyDone {
+		m.exportOnly = "done"
+	}
+	if *exportICS != "" || *list || *agenda || *report != "" || *export != "" || *importTodoist != "" || *importMD != "" || *add != "" || *done != "" || *merge != "" || *capture {
+		m.load()
+		if m.configError != "" {
+			fmt.Fprintln(os.Stderr, m.errorMessage)
+			os.Exit(1)
+		}
+	} else {
+		m.loading = true
+	}
 
-func (m *Model) loadConfig() {
-	// Ensure config directory exists
-	os.MkdirAll(m.configPath, 0755)
-	
-	configFile := filepath.Join(m.configPath, "config.json")
-	
-	// Try to load existing config
-	data, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		// Create default config
-		m.createDefaultConfig()
+	if *exportICS != "" {
+		count, err := m.exportICS(*exportICS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Exported %d tasks to %s\n", count, *exportICS)
 		return
 	}
 
-	var config struct {
-		Tasks  []Task `json:"tasks"`
-		NextID int    `json:"next_id"`
+	// A misspelt context would otherwise list or export nothing without a word
+	if *listContext != "" && (*export != "" || *list) && m.findContextIndex(*listContext) < 0 {
+		fmt.Fprintf(os.Stderr, "Unknown context %q\n", *listContext)
+		os.Exit(1)
 	}
 
-	if err := json.Unmarshal(data, &config); err != nil {
-		m.createDefaultConfig()
+	if *export != "" {
+		tasks := m.tasks
+		if *listContext != "" {
+			tasks = m.getTasksForContext(*listContext)
+		}
+		path := filepath.Join(m.configPath, exportFileName(*listContext, *export))
+		count, err := m.exportTasks(path, tasks)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Exported %d tasks to %s\n", count, path)
 		return
 	}
 
-	m.tasks = config.Tasks
-	m.nextID = config.NextID
-	
-	// Ensure we have a valid next ID
-	if m.nextID == 0 {
-		maxID := 0
-		for _, task := range m.tasks {
-			if task.ID > maxID {
-				maxID = task.ID
-			}
+	if *importTodoist != "" {
+		count, err := m.importTodoist(*importTodoist)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			os.Exit(1)
 		}
-		m.nextID = maxID + 1
+		m.saveConfig()
+		if m.errorMessage != "" {
+			fmt.Fprintln(os.Stderr, m.errorMessage)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d tasks from %s\n", count, *importTodoist)
+		return
 	}
-}
 
-func (m *Model) createDefaultConfig() {
-	m.tasks = []Task{
-		{ID: 1, Task: "Welcome to your todo app!", Checked: false, Context: "Work"},
-		{ID: 2, Task: "Press 'a' to add a new task", Checked: false, Context: "Work"},
-		{ID: 3, Task: "Press space to toggle completion", Checked: true, Context: "Personal"},
-		{ID: 4, Task: "Use arrow keys to navigate", Checked: false, Context: "Personal"},
+	if *importMD != "" {
+		count, err := m.importMarkdown(*importMD)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			os.Exit(1)
+		}
+		m.saveConfig()
+		if m.errorMessage != "" {
+			fmt.Fprintln(os.Stderr, m.errorMessage)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d tasks from %s\n", count, *importMD)
+		return
 	}
-	m.nextID = 5
-}
 
-func (m *Model) saveConfig() {
-	configFile := filepath.Join(m.configPath, "config.json")
-	
-	config := struct {
-		Tasks  []Task `json:"tasks"`
-		NextID int    `json:"next_id"`
-	}{
-		Tasks:  m.tasks,
-		NextID: m.nextID,
+	if *merge != "" {
+		result, err := m.mergeConfig(*merge, *prefer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Merge failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, c := range result.conflicts {
+			fmt.Printf("Conflict on #%d: ours %q in %s, theirs %q in %s\n", c.ours.ID, firstLine(c.ours.Task), c.ours.Context, firstLine(c.theirs.Task), c.theirs.Context)
+		}
+		settled := map[string]string{"both": "kept both", "ours": "kept ours", "theirs": "took theirs"}[*prefer]
+		summary := fmt.Sprintf("%d new task(s), %d already here, %d conflict(s) (%s)", result.added, result.same, len(result.conflicts), settled)
+		if !*write {
+			fmt.Printf("Dry run: %s. Rerun with --write to merge, --prefer to settle conflicts otherwise\n", summary)
+			return
+		}
+		m.saveConfig()
+		if m.errorMessage != "" {
+			fmt.Fprintln(os.Stderr, m.errorMessage)
+			os.Exit(1)
+		}
+		for _, task := range result.merged {
+			m.logTask("merged", task)
+		}
+		for _, task := range result.replaced {
+			m.logTask("replaced", task)
+		}
+		if err := m.runHooksNow(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		fmt.Printf("Merged %s: %s\n", *merge, summary)
+		return
 	}
 
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
+	if *report != "" {
+		var err error
+		if *report == "-" {
+			err = m.writeReport(os.Stdout)
+		} else {
+			err = m.saveReport(*report)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Report failed: %v\n", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	ioutil.WriteFile(configFile, data, 0644)
-}
-
-// KeyMap methods to implement help.KeyMap interface
-func (k KeyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Nav, k.Toggle, k.Add, k.Edit, k.Delete, k.Quit}
-}
+	if *add != "" || *done != "" {
+		var task Task
+		var err error
+		if *add != "" {
+			task, err = m.addTaskTo(*listContext, *add)
+		} else {
+			task, err = m.completeTaskByRef(*done)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if m.errorMessage != "" {
+			fmt.Fprintln(os.Stderr, m.errorMessage)
+			m.errorMessage = ""
+		}
+		m.saveConfig()
+		if m.errorMessage != "" {
+			fmt.Fprintln(os.Stderr, m.errorMessage)
+			os.Exit(1)
+		}
+		if err := m.runHooksNow(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if *add != "" {
+			fmt.Printf("Added %s to %s\n", taskRef(task), task.Context)
+		} else {
+			fmt.Printf("Completed %s %s\n", taskRef(task), firstLine(task.Task))
+		}
+		return
+	}
 
-func (k KeyMap) FullHelp() [][]key.Binding {
-	return [][]key.Binding{
-		{k.Nav},
-		{k.Toggle, k.Add, k.Edit, k.Delete, k.Move},
-		{k.AddContext, k.RenameContext, k.DeleteContext},
-		{k.TogglePriority, k.AddTag, k.RemoveTag, k.SetDueDate, k.ClearDueDate},
-		{k.Search, k.KanbanView, k.StatsView},
-		{k.Undo, k.Back, k.Quit},
+	if *list {
+		if err := m.listTasks(os.Stdout, *listContext, *listAll, *jsonl); err != nil {
+			fmt.Fprintf(os.Stderr, "List failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *agenda {
+		if err := m.printAgenda(os.Stdout, *agendaJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "Agenda failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *capture {
+		m.startCapture(*multi, *listContext)
 	}
-}
 
-// Main function
-func main() {
-	p := tea.NewProgram(Initialize(), tea.WithAltScreen())
+	p := tea.NewProgram(m, tea.WithAltScreen())
 	
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	if err != nil {
 		fmt.Printf("Error running program: %v", err)
 		os.Exit(1)
 	}
+
+	// Errors from the final save happen after the UI is gone
+	if fm, ok := final.(Model); ok && fm.errorMessage != "" {
+		fmt.Fprintln(os.Stderr, fm.errorMessage)
+	}
 }
\ No newline at end of file
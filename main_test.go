@@ -0,0 +1,1368 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// newTestModel returns a model holding tasks, on the first context, with a
+// config directory that is never read or written
+func newTestModel(t *testing.T, tasks ...Task) Model {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	m := newModel()
+	m.tasks = tasks
+	m.nextID = len(tasks) + 1
+	m.updateContexts()
+	m.currentContext = m.contexts[0]
+	return m
+}
+
+func TestContextWrapAtEnds(t *testing.T) {
+	tests := []struct {
+		name     string
+		wrap     bool
+		forward  bool
+		fromLast bool
+		wantLast bool // ends up on the last context rather than the first
+	}{
+		{"next from last wraps", true, true, true, false},
+		{"next from last stops", false, true, true, true},
+		{"previous from first wraps", true, false, false, true},
+		{"previous from first stops", false, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t,
+				Task{ID: 1, Task: "a", Context: "Alpha"},
+				Task{ID: 2, Task: "b", Context: "Beta"},
+				Task{ID: 3, Task: "c", Context: "Gamma"},
+			)
+			m.settings.ContextWrap = &tt.wrap
+			contexts := m.visibleContexts()
+			first, last := contexts[0], contexts[len(contexts)-1]
+			m.currentContext = first
+			if tt.fromLast {
+				m.currentContext = last
+			}
+
+			if tt.forward {
+				m.nextContext()
+			} else {
+				m.previousContext()
+			}
+
+			want := first
+			if tt.wantLast {
+				want = last
+			}
+			if m.currentContext != want {
+				t.Errorf("context = %q, want %q", m.currentContext, want)
+			}
+		})
+	}
+}
+
+func TestContextWrapInMiddle(t *testing.T) {
+	for _, wrap := range []bool{true, false} {
+		m := newTestModel(t,
+			Task{ID: 1, Task: "a", Context: "Alpha"},
+			Task{ID: 2, Task: "b", Context: "Beta"},
+			Task{ID: 3, Task: "c", Context: "Gamma"},
+		)
+		m.settings.ContextWrap = &wrap
+		contexts := m.visibleContexts()
+		m.currentContext = contexts[1]
+
+		m.nextContext()
+		if m.currentContext != contexts[2] {
+			t.Errorf("wrap=%v: next = %q, want %q", wrap, m.currentContext, contexts[2])
+		}
+		m.previousContext()
+		m.previousContext()
+		if m.currentContext != contexts[0] {
+			t.Errorf("wrap=%v: previous = %q, want %q", wrap, m.currentContext, contexts[0])
+		}
+	}
+}
+
+func TestTaskWrapAtEnds(t *testing.T) {
+	tests := []struct {
+		name string
+		wrap bool
+		down bool
+		from int
+		want int
+	}{
+		{"up from first wraps", true, false, 0, 2},
+		{"up from first stops", false, false, 0, 0},
+		{"down from last wraps", true, true, 2, 0},
+		{"down from last stops", false, true, 2, 2},
+		{"up in the middle", false, false, 1, 0},
+		{"down in the middle", false, true, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t,
+				Task{ID: 1, Task: "a", Context: "Work"},
+				Task{ID: 2, Task: "b", Context: "Work"},
+				Task{ID: 3, Task: "c", Context: "Work"},
+			)
+			m.settings.TaskWrap = &tt.wrap
+			m.selectedIndex = tt.from
+
+			if tt.down {
+				m.moveDown()
+			} else {
+				m.moveUp()
+			}
+
+			if m.selectedIndex != tt.want {
+				t.Errorf("selectedIndex = %d, want %d", m.selectedIndex, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddFiltered(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantCategory string
+		wantFilter   string
+	}{
+		{"", "errand", "errand"},
+		{"apply", "errand", "errand"},
+		{"clear", "", ""},
+	}
+	for _, tt := range tests {
+		name := tt.mode
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work", Category: "errand"})
+			m.settings.AddFiltered = tt.mode
+			m.categoryFilter = "errand"
+			m.dueOnly = true
+			m.extrasOnly = true
+
+			if !m.addTask("buy milk") {
+				t.Fatalf("addTask failed: %s", m.errorMessage)
+			}
+
+			added := m.tasks[m.findTaskIndex(2)]
+			if added.Category != tt.wantCategory {
+				t.Errorf("category = %q, want %q", added.Category, tt.wantCategory)
+			}
+			if m.categoryFilter != tt.wantFilter {
+				t.Errorf("category filter = %q, want %q", m.categoryFilter, tt.wantFilter)
+			}
+			if m.dueOnly || m.extrasOnly {
+				t.Errorf("due and extras filters left on: due=%v extras=%v", m.dueOnly, m.extrasOnly)
+			}
+			if task := m.getCurrentTask(); task.ID != added.ID {
+				t.Errorf("selected task %d, want the new task %d", task.ID, added.ID)
+			}
+		})
+	}
+}
+
+func TestBatchOrder(t *testing.T) {
+	// Stored out of order on purpose: the batch order must not depend on it
+	m := newTestModel(t,
+		Task{ID: 5, Task: "e", Context: "Work", Priority: "low"},
+		Task{ID: 2, Task: "b", Context: "Work"},
+		Task{ID: 4, Task: "d", Context: "Work", Priority: "high"},
+		Task{ID: 1, Task: "a", Context: "Work", Priority: "low"},
+		Task{ID: 3, Task: "c", Context: "Work", Priority: "high", Checked: true},
+		Task{ID: 6, Task: "f", Context: "Work", Priority: "medium"},
+	)
+
+	var got []int
+	for _, i := range m.batchOrder(func(task Task) bool { return !task.Checked }) {
+		got = append(got, m.tasks[i].ID)
+	}
+
+	want := []int{4, 6, 1, 5, 2}
+	if len(got) != len(want) {
+		t.Fatalf("batch order = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("batch order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestConfigCodecRoundTrip(t *testing.T) {
+	wrap := false
+	want := Config{
+		Tasks: []Task{
+			{ID: 1, Task: "write report", Context: "Work", Priority: "high", Tags: []string{"q3", "client:acme"}, DueDate: "2026-10-20 09:30", Estimate: 3, Notes: "first line\nsecond line"},
+			{ID: 2, Task: "proofread", Context: "Work/Docs", ParentID: 1, Checked: true, CompletedAt: "2026-10-14T10:00:00Z", Percent: 100},
+			{ID: 3, Task: "water plants", Context: "Home", Schedule: "after weekly", Source: 1, CompletionCount: 4, Code: "HOM-1"},
+		},
+		NextID: 4,
+		Settings: Settings{
+			KanbanSort:  "weight",
+			WIPLimits:   map[string]int{"Work": 3},
+			WeekStart:   "sunday",
+			Templates:   []string{"Recipes"},
+			ContextWrap: &wrap,
+		},
+	}
+	for _, format := range []string{"json", "toml", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			codec := configCodecs[format]
+			data, err := codec.Marshal(want)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got Config
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip changed the config:\n got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLeavesBrokenFileAlone(t *testing.T) {
+	m := newTestModel(t)
+	os.MkdirAll(m.configPath, 0755)
+	path := filepath.Join(m.configPath, "config.toml")
+	broken := []byte("next_id = 3\n[[tasks]\nid = 1\n")
+	if err := os.WriteFile(path, broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.loadConfig()
+	if m.configError == "" {
+		t.Fatal("configError not set for a file that does not parse")
+	}
+	if m.settings.TutorialStep != 0 {
+		t.Error("a broken config started the first-run tutorial")
+	}
+	m.addTask("new task")
+	m.saveConfig()
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, broken) {
+		t.Errorf("broken config was saved over:\n%s", data)
+	}
+}
+
+func TestConvertSetsOldFileAside(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+	os.MkdirAll(m.configPath, 0755)
+	m.configFile = filepath.Join(m.configPath, "config.json")
+	m.saveConfig()
+
+	m.configFormat = "yaml"
+	m.loadConfig()
+	m.saveConfig()
+	if m.errorMessage != "" {
+		t.Fatal(m.errorMessage)
+	}
+	if got := findConfigFile(m.configPath); filepath.Base(got) != "config.yaml" {
+		t.Errorf("findConfigFile = %s after converting, want config.yaml", got)
+	}
+	if _, err := os.Stat(filepath.Join(m.configPath, "config.json.bak")); err != nil {
+		t.Errorf("old config not set aside: %v", err)
+	}
+}
+
+// writeOtherConfig saves tasks as another machine's config.json and returns its path
+func writeOtherConfig(t *testing.T, settings Settings, tasks ...Task) string {
+	t.Helper()
+	data, err := jsonCodec{}.Marshal(Config{Tasks: tasks, NextID: len(tasks) + 1, Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergeConfig(t *testing.T) {
+	const created = "2026-10-01T09:00:00Z"
+	ours := Task{ID: 1, Task: "pay rent", Context: "Home", CreatedAt: created}
+	tests := []struct {
+		name          string
+		prefer        string
+		theirs        Task
+		wantTasks     []string // task text by ID order, after the merge
+		wantAdded     int
+		wantSame      int
+		wantConflicts int
+	}{
+		{"new task", "both", Task{ID: 2, Task: "buy milk", Context: "Home", CreatedAt: created}, []string{"pay rent", "buy milk"}, 1, 0, 0},
+		{"identical task", "both", ours, []string{"pay rent"}, 0, 1, 0},
+		{"unrelated task sharing the ID", "theirs", Task{ID: 1, Task: "call mum", Context: "Home", CreatedAt: "2026-10-02T09:00:00Z"}, []string{"pay rent", "call mum"}, 1, 0, 0},
+		{"conflict kept as both", "both", Task{ID: 1, Task: "pay rent today", Context: "Home", CreatedAt: created}, []string{"pay rent", "pay rent today"}, 1, 0, 1},
+		{"conflict kept as ours", "ours", Task{ID: 1, Task: "pay rent today", Context: "Home", CreatedAt: created}, []string{"pay rent"}, 0, 0, 1},
+		{"conflict settled as theirs", "theirs", Task{ID: 1, Task: "pay rent today", Context: "Home", CreatedAt: created}, []string{"pay rent today"}, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t, ours)
+			result, err := m.mergeConfig(writeOtherConfig(t, Settings{}, tt.theirs), tt.prefer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, task := range m.tasks {
+				got = append(got, task.Task)
+			}
+			if !reflect.DeepEqual(got, tt.wantTasks) {
+				t.Errorf("tasks = %q, want %q", got, tt.wantTasks)
+			}
+			if result.added != tt.wantAdded || result.same != tt.wantSame || len(result.conflicts) != tt.wantConflicts {
+				t.Errorf("added %d, same %d, conflicts %d; want %d, %d, %d", result.added, result.same, len(result.conflicts), tt.wantAdded, tt.wantSame, tt.wantConflicts)
+			}
+			seen := make(map[int]bool)
+			for _, task := range m.tasks {
+				if seen[task.ID] {
+					t.Errorf("ID %d used twice", task.ID)
+				}
+				seen[task.ID] = true
+			}
+		})
+	}
+}
+
+func TestMergeConfigReparentsSubtasks(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "plan trip", Context: "Home", CreatedAt: "2026-10-01T09:00:00Z"},
+		Task{ID: 2, Task: "book train", Context: "Home", ParentID: 1, CreatedAt: "2026-10-01T09:01:00Z"},
+	)
+	path := writeOtherConfig(t, Settings{},
+		Task{ID: 1, Task: "move flat", Context: "Home", CreatedAt: "2026-10-03T09:00:00Z"},
+		Task{ID: 2, Task: "pack books", Context: "Home", ParentID: 1, CreatedAt: "2026-10-03T09:01:00Z"},
+	)
+	if _, err := m.mergeConfig(path, "both"); err != nil {
+		t.Fatal(err)
+	}
+
+	parents := make(map[string]string)
+	for _, task := range m.tasks {
+		if task.ParentID != 0 {
+			parents[task.Task] = m.tasks[m.findTaskIndex(task.ParentID)].Task
+		}
+	}
+	want := map[string]string{"book train": "plan trip", "pack books": "move flat"}
+	if !reflect.DeepEqual(parents, want) {
+		t.Errorf("subtask parents = %v, want %v", parents, want)
+	}
+}
+
+func TestMergeConfigContextSettings(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Home"})
+	m.settings.Scratchpads = map[string]string{"Home": "ours"}
+	m.settings.CodeCounters = map[string]int{"HOM": 5}
+	path := writeOtherConfig(t, Settings{
+		Scratchpads:  map[string]string{"Home": "theirs", "Work": "standup at 10"},
+		CodeCounters: map[string]int{"HOM": 9, "WRK": 2},
+		Templates:    []string{"Recipes"},
+		Archived:     []string{"Old"},
+	}, Task{ID: 2, Task: "b", Context: "Work"})
+	if _, err := m.mergeConfig(path, "both"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.settings.Scratchpads["Home"]; got != "ours\n\ntheirs" {
+		t.Errorf("Home scratchpad = %q", got)
+	}
+	if got := m.settings.Scratchpads["Work"]; got != "standup at 10" {
+		t.Errorf("Work scratchpad = %q", got)
+	}
+	if !reflect.DeepEqual(m.settings.CodeCounters, map[string]int{"HOM": 9, "WRK": 2}) {
+		t.Errorf("code counters = %v", m.settings.CodeCounters)
+	}
+	if !m.isTemplateContext("Recipes") || !m.isArchived("Old") {
+		t.Errorf("template or archived contexts not merged: %v, %v", m.settings.Templates, m.settings.Archived)
+	}
+}
+
+func TestSchedulePeriod(t *testing.T) {
+	thursday := time.Date(2026, 10, 15, 14, 30, 0, 0, time.Local)
+	saturday := time.Date(2026, 10, 17, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		schedule string
+		now      time.Time
+		first    time.Weekday
+		want     string
+		wantOK   bool
+	}{
+		{"daily", thursday, time.Monday, "2026-10-15", true},
+		{"weekdays", thursday, time.Monday, "2026-10-15", true},
+		{"weekdays", saturday, time.Monday, "", false},
+		{"weekly", thursday, time.Monday, "2026-10-12", true},
+		{"weekly", thursday, time.Sunday, "2026-10-11", true},
+		{"weekly", saturday, time.Sunday, "2026-10-11", true},
+		{"monthly", thursday, time.Monday, "2026-10-01", true},
+		{"hourly", thursday, time.Monday, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule+" "+tt.now.Weekday().String()+" from "+tt.first.String(), func(t *testing.T) {
+			got, ok := schedulePeriod(tt.schedule, tt.now, tt.first)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("schedulePeriod = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateScheduled(t *testing.T) {
+	now := time.Date(2026, 10, 15, 8, 0, 0, 0, time.Local)
+	tests := []struct {
+		name      string
+		source    Task
+		weekStart string
+		wantDue   string
+		wantStart string
+	}{
+		{"daily due yesterday", Task{Schedule: "daily", Generated: "2026-10-14", DueDate: "2026-10-14 17:00"}, "", "2026-10-15 17:00", ""},
+		{"weekly range", Task{Schedule: "weekly", Generated: "2026-10-05", StartDate: "2026-10-06", DueDate: "2026-10-08"}, "", "2026-10-15", "2026-10-13"},
+		{"weekly from sunday", Task{Schedule: "weekly", Generated: "2026-10-04", DueDate: "2026-10-09"}, "sunday", "2026-10-16", ""},
+		{"monthly", Task{Schedule: "monthly", Generated: "2026-08-01", DueDate: "2026-08-20"}, "", "2026-10-20", ""},
+		{"no dates", Task{Schedule: "daily", Generated: "2026-10-14"}, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := tt.source
+			source.ID, source.Task, source.Context, source.Today = 1, "stand-up", "Work", true
+			m := newTestModel(t, source)
+			m.settings.WeekStart = tt.weekStart
+
+			if n := m.generateScheduled(now); n != 1 {
+				t.Fatalf("generated %d copies, want 1", n)
+			}
+			if n := m.generateScheduled(now); n != 0 {
+				t.Errorf("generated %d more copies in the same period", n)
+			}
+			instance := m.tasks[len(m.tasks)-1]
+			if instance.DueDate != tt.wantDue || instance.StartDate != tt.wantStart {
+				t.Errorf("copy dates = %q to %q, want %q to %q", instance.StartDate, instance.DueDate, tt.wantStart, tt.wantDue)
+			}
+			if instance.Today || instance.Schedule != "" || instance.ID == source.ID {
+				t.Errorf("copy = %+v; want a fresh, unscheduled task not picked for today", instance)
+			}
+			if m.tasks[0].DueDate != source.DueDate {
+				t.Errorf("source due date moved to %q", m.tasks[0].DueDate)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsBrokenEdit(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+	os.MkdirAll(m.configPath, 0755)
+	m.configFile = filepath.Join(m.configPath, "config.json")
+	m.saveConfig()
+
+	edit := []byte(`{"tasks": [`)
+	if err := os.WriteFile(m.configFile, edit, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.reloadConfig()
+	m.saveConfig()
+	if data, _ := os.ReadFile(m.configFile); !bytes.Equal(data, edit) {
+		t.Errorf("broken edit was saved over:\n%s", data)
+	}
+
+	if err := os.WriteFile(m.configFile, []byte(`{"tasks": [{"id": 1, "task": "b", "context": "Work"}], "next_id": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.reloadConfig()
+	if m.configError != "" || m.tasks[0].Task != "b" {
+		t.Errorf("fixed edit not reloaded: error %q, tasks %+v", m.configError, m.tasks)
+	}
+}
+
+func TestMoveTaskTakesSubtasks(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "plan trip", Context: "Home"},
+		Task{ID: 2, Task: "book train", Context: "Home", ParentID: 1},
+		Task{ID: 3, Task: "pack", Context: "Home", ParentID: 1},
+	)
+	m.currentContext = "Home"
+	m.selectedIndex = 0
+	m.moveCurrentTaskToContext("Travel")
+	for _, task := range m.tasks {
+		if task.Context != "Travel" {
+			t.Errorf("%q left in %q", task.Task, task.Context)
+		}
+	}
+
+	m.currentContext = "Travel"
+	m.selectedIndex = 1
+	moved := m.getCurrentTask()
+	if moved.ParentID != 1 {
+		t.Fatalf("selected %+v, want a subtask", moved)
+	}
+	m.moveCurrentTaskToContext("Home")
+	if task := m.tasks[m.findTaskIndex(moved.ID)]; task.Context != "Home" || task.ParentID != 0 {
+		t.Errorf("subtask moved alone = %+v, want a top-level task in Home", task)
+	}
+}
+
+func TestRepairTaskIDsKeepsSubtasksWithTheirParent(t *testing.T) {
+	m := newTestModel(t)
+	m.tasks = []Task{
+		{ID: 1, Task: "plan trip", Context: "Home"},
+		{ID: 2, Task: "book train", Context: "Home", ParentID: 1},
+		{ID: 1, Task: "ship release", Context: "Work"},
+		{ID: 3, Task: "tag build", Context: "Work", ParentID: 1},
+	}
+	m.nextID = 4
+	m.repairTaskIDs()
+
+	parentOf := func(name string) string {
+		for _, task := range m.tasks {
+			if task.Task == name {
+				return m.tasks[m.findTaskIndex(task.ParentID)].Task
+			}
+		}
+		return ""
+	}
+	if got := parentOf("book train"); got != "plan trip" {
+		t.Errorf("book train's parent = %q", got)
+	}
+	if got := parentOf("tag build"); got != "ship release" {
+		t.Errorf("tag build's parent = %q", got)
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"valid", `{"tasks": [{"id": 1, "task": "a", "context": "Work"}, {"id": 2, "task": "b", "context": "Work", "parent_id": 1}], "next_id": 3}`, nil},
+		{"dangling parent", `{"tasks": [{"id": 1, "task": "a", "context": "Work", "parent_id": 7}], "next_id": 2}`,
+			[]string{"task 1 (id 1): parent_id 7 names no task"}},
+		{"unknown enum values", `{"tasks": [], "next_id": 1, "emptied_context": "last", "kanban_sort": "Weight", "priority_marks": {"preset": "stars"}}`,
+			[]string{
+				`kanban_sort: unknown value "Weight" (use priority, weight, manual)`,
+				`emptied_context: unknown value "last" (use stay, next)`,
+				`priority_marks.preset: unknown value "stars" (use marks, numbers, block, text)`,
+			}},
+		{"enter as a toggle key", `{"tasks": [], "next_id": 1, "toggle_keys": ["enter"]}`,
+			[]string{`toggle_keys: "enter" confirms dialogs; set enter_action to toggle instead`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := validateConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// queuedHooks lists the queued hook events as "event #id context"
+func queuedHooks(m *Model) []string {
+	var got []string
+	for _, h := range m.pendingHooks {
+		got = append(got, fmt.Sprintf("%s #%d %s", h.event, h.task.ID, h.task.Context))
+	}
+	return got
+}
+
+func TestHooksFollowTheAuditLog(t *testing.T) {
+	hooks := map[string]string{"task-added": "true", "task-completed": "true", "task-deleted": "true"}
+
+	t.Run("subtask added in its parent's context", func(t *testing.T) {
+		m := newTestModel(t, Task{ID: 1, Task: "plan trip", Context: "Home"})
+		m.settings.Hooks = hooks
+		m.currentContext = "Home"
+		m.addSubtask("book train @Work")
+		want := []string{"task-added #2 Home"}
+		if got := queuedHooks(&m); !reflect.DeepEqual(got, want) {
+			t.Errorf("hooks = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("cascaded subtasks complete", func(t *testing.T) {
+		m := newTestModel(t,
+			Task{ID: 1, Task: "plan trip", Context: "Home"},
+			Task{ID: 2, Task: "book train", Context: "Home", ParentID: 1},
+		)
+		m.settings.Hooks = hooks
+		m.currentContext = "Home"
+		m.selectedIndex = 0
+		m.toggleCurrentTask()
+		got := queuedHooks(&m)
+		sort.Strings(got)
+		want := []string{"task-completed #1 Home", "task-completed #2 Home"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("hooks = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("context deleted", func(t *testing.T) {
+		m := newTestModel(t,
+			Task{ID: 1, Task: "a", Context: "Home"},
+			Task{ID: 2, Task: "b", Context: "Work"},
+		)
+		m.settings.Hooks = hooks
+		m.currentContext = "Work"
+		m.deleteContext()
+		want := []string{"task-deleted #2 Work"}
+		if got := queuedHooks(&m); !reflect.DeepEqual(got, want) {
+			t.Errorf("hooks = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestDeleteContextTakesSubcontexts(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "a", Context: "Work"},
+		Task{ID: 2, Task: "b", Context: "Work/Docs"},
+		Task{ID: 3, Task: "c", Context: "Home"},
+	)
+	m.settings.Templates = []string{"Work/Docs"}
+	m.setScratchpad("Work/Docs", "style guide")
+	m.currentContext = "Work"
+	m.deleteContext()
+
+	if !reflect.DeepEqual(m.contexts, []string{"Home"}) {
+		t.Errorf("contexts = %q, want only Home", m.contexts)
+	}
+	if len(m.tasks) != 1 || len(m.settings.Templates) != 0 || len(m.settings.Scratchpads) != 0 {
+		t.Errorf("left behind: tasks %+v, templates %q, scratchpads %q", m.tasks, m.settings.Templates, m.settings.Scratchpads)
+	}
+}
+
+func TestRenameContextCarriesSubcontextSettings(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "a", Context: "Work"},
+		Task{ID: 2, Task: "b", Context: "Work/Docs"},
+		Task{ID: 3, Task: "c", Context: "Work/Old"},
+	)
+	m.settings.Templates = []string{"Work/Docs"}
+	m.settings.CollapsedContexts = []string{"Work"}
+	m.settings.Archived = []string{"Work/Old"}
+	m.setScratchpad("Work/Docs", "style guide")
+	m.currentContext = "Work"
+	m.renameContext("Job")
+
+	if !reflect.DeepEqual(m.settings.Templates, []string{"Job/Docs"}) ||
+		!reflect.DeepEqual(m.settings.CollapsedContexts, []string{"Job"}) ||
+		!reflect.DeepEqual(m.settings.Archived, []string{"Job/Old"}) ||
+		m.settings.Scratchpads["Job/Docs"] != "style guide" {
+		t.Errorf("settings not renamed: templates %q, collapsed %q, archived %q, scratchpads %q",
+			m.settings.Templates, m.settings.CollapsedContexts, m.settings.Archived, m.settings.Scratchpads)
+	}
+}
+
+func TestToggleKeysLeaveEnterToDialogs(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+	os.MkdirAll(m.configPath, 0755)
+	m.settings.ToggleKeys = []string{"enter", "ctrl+x"}
+	m.saveConfig()
+	m.load()
+
+	if !reflect.DeepEqual(m.keyMap.Toggle.Keys(), []string{"ctrl+x"}) {
+		t.Errorf("toggle keys = %q, want only ctrl+x", m.keyMap.Toggle.Keys())
+	}
+	if m.errorMessage == "" {
+		t.Error("enter in toggle_keys dropped without a word")
+	}
+}
+
+func TestContextHoursYieldToManualSwitches(t *testing.T) {
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.Local)
+	switches := []struct {
+		name string
+		do   func(m *Model)
+	}{
+		{"add a context", func(m *Model) { m.addContext("Errands") }},
+		{"switch to an existing context", func(m *Model) { m.addContext("home") }},
+		{"merge into another context", func(m *Model) { m.mergeContext("Misc", "Home") }},
+	}
+	for _, tt := range switches {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t,
+				Task{ID: 1, Task: "a", Context: "Home"},
+				Task{ID: 2, Task: "b", Context: "Work"},
+				Task{ID: 3, Task: "c", Context: "Misc"},
+			)
+			m.settings.ContextHours = []ContextHours{{From: "09:00", To: "17:00", Context: "Work"}}
+			m.followContextHours(now)
+			if m.currentContext != "Work" {
+				t.Fatalf("context = %q before switching, want the scheduled Work", m.currentContext)
+			}
+
+			tt.do(&m)
+			switched := m.currentContext
+			m.followContextHours(now.Add(time.Minute))
+			if m.currentContext != switched {
+				t.Errorf("context hours pulled %q back to %q", switched, m.currentContext)
+			}
+		})
+	}
+}
+
+func TestMarkdownExportRoundTrip(t *testing.T) {
+	tasks := []Task{
+		{ID: 1, Task: "write the quarterly report for the board, with figures from every team", Context: "Work", Priority: "high", DueDate: "2026-10-20", Tags: []string{"q3", "board"}},
+		{ID: 2, Task: "water plants", Context: "Home Jobs", Checked: true},
+		{ID: 3, Task: "call the plumber about the kitchen tap", Context: "Home Jobs", DueDate: "2026-10-16 09:30", Tags: []string{"phone"}},
+	}
+	for _, tt := range []struct {
+		name  string
+		wrap  int
+		group string
+	}{
+		{"by context", 0, "context"},
+		{"by context, wrapped", 30, "context"},
+		{"by tag", 0, "tag"},
+		{"by tag, wrapped", 30, "tag"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t, tasks...)
+			m.exportWrap, m.exportGroup = tt.wrap, tt.group
+			path := filepath.Join(t.TempDir(), "export.md")
+			if _, err := m.exportTasks(path, m.tasks); err != nil {
+				t.Fatal(err)
+			}
+
+			imported := newTestModel(t)
+			if _, err := imported.importMarkdown(path); err != nil {
+				t.Fatal(err)
+			}
+			if len(imported.tasks) != len(tasks) {
+				data, _ := os.ReadFile(path)
+				t.Fatalf("imported %d tasks, want %d, from:\n%s", len(imported.tasks), len(tasks), data)
+			}
+			for _, want := range tasks {
+				found := false
+				for _, got := range imported.tasks {
+					if got.Task == want.Task {
+						found = true
+						if got.Context != want.Context || got.Priority != want.Priority || got.DueDate != want.DueDate || got.Checked != want.Checked || !reflect.DeepEqual(got.Tags, want.Tags) {
+							t.Errorf("imported %+v, want %+v", got, want)
+						}
+					}
+				}
+				if !found {
+					t.Errorf("%q not imported", want.Task)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMarkdownItem(t *testing.T) {
+	tests := []struct {
+		item                         string
+		text, priority, due, context string
+		tags                         []string
+	}{
+		{"buy milk", "buy milk", "", "", "", nil},
+		{"buy milk (high) due 2026-10-20 #shop #food", "buy milk", "high", "2026-10-20", "", []string{"shop", "food"}},
+		{"ring Sam due 2026-10-20 09:30 @Work", "ring Sam", "", "2026-10-20 09:30", "Work", nil},
+		{"paint fence #diy @[Home Jobs]", "paint fence", "", "", "Home Jobs", []string{"diy"}},
+		{"#hashtag", "#hashtag", "", "", "", nil},
+		{"meet (soon)", "meet (soon)", "", "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.item, func(t *testing.T) {
+			text, priority, due, context, tags := parseMarkdownItem(tt.item)
+			if text != tt.text || priority != tt.priority || due != tt.due || context != tt.context || !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("parseMarkdownItem = %q, %q, %q, %q, %q; want %q, %q, %q, %q, %q",
+					text, priority, due, context, tags, tt.text, tt.priority, tt.due, tt.context, tt.tags)
+			}
+		})
+	}
+}
+
+func TestWrapWords(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"short line", 20, []string{"short line"}},
+		{"one two three four", 9, []string{"one two", "  three", "  four"}},
+		{"a supercalifragilistic word", 10, []string{"a", "  supercalifragilistic", "  word"}},
+		{"", 10, []string{""}},
+	}
+	for _, tt := range tests {
+		if got := wrapWords(tt.text, tt.width, "  "); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapWords(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestImportMarkdownHeadings(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		want      []string // contexts of the imported tasks, in order
+	}{
+		{"sibling sections", "## Work\n- [ ] a\n## Home\n- [ ] b\n", []string{"Work", "Home"}},
+		{"nested sections", "# Work\n- [ ] a\n## Docs\n- [ ] b\n# Home\n- [ ] c\n", []string{"Work", "Work/Docs", "Home"}},
+		{"before any heading", "- [ ] a\n## Work\n- [ ] b\n", []string{"notes", "Work"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notes.md")
+			if err := os.WriteFile(path, []byte(tt.doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m := newTestModel(t)
+			if _, err := m.importMarkdown(path); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, task := range m.tasks {
+				got = append(got, task.Context)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contexts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTagsKeepsStoredOrder(t *testing.T) {
+	got := renderTags([]string{"status:wip", "urgent", "area:web"}, 0, lipgloss.NewStyle())
+	if want := " > status:wip, urgent, area:web"; got != want {
+		t.Errorf("renderTags = %q, want %q", got, want)
+	}
+}
+
+func TestPercentFollowsCheckedState(t *testing.T) {
+	t.Run("reopening a task done at 100%", func(t *testing.T) {
+		m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+		m.setPercentForCurrentTask(100)
+		if task := m.tasks[0]; !task.Checked || task.Percent != 100 {
+			t.Fatalf("after 100%%: %+v", task)
+		}
+		m.toggleCurrentTask()
+		if task := m.tasks[0]; task.Checked || task.Percent != 0 {
+			t.Errorf("reopened task = checked %v at %d%%, want open at 0%%", task.Checked, task.Percent)
+		}
+	})
+
+	t.Run("100% refused under require", func(t *testing.T) {
+		m := newTestModel(t,
+			Task{ID: 1, Task: "plan trip", Context: "Home", Percent: 40},
+			Task{ID: 2, Task: "book train", Context: "Home", ParentID: 1},
+		)
+		m.settings.SubtaskCompletion = "require"
+		m.currentContext = "Home"
+		m.selectedIndex = 0
+		m.setPercentForCurrentTask(100)
+		if task := m.tasks[0]; task.Checked || task.Percent != 40 {
+			t.Errorf("parent = checked %v at %d%%, want open at 40%%", task.Checked, task.Percent)
+		}
+	})
+}
+
+func TestViewComplete(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantChecked bool
+		wantContext string
+	}{
+		{"", true, "Work"},
+		{"check", true, "Work"},
+		{"done", true, "Done"},
+		{"off", false, "Work"},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			m := newTestModel(t,
+				Task{ID: 1, Task: "parent", Context: "Work"},
+				Task{ID: 2, Task: "child", Context: "Work", ParentID: 1},
+			)
+			if tt.mode != "" {
+				m.settings.ViewComplete = map[string]string{"normal": tt.mode}
+			}
+			completed := m.toggleTaskIn("normal", 1)
+			if completed != tt.wantChecked || m.tasks[0].Checked != tt.wantChecked {
+				t.Errorf("completed = %v, checked = %v, want %v", completed, m.tasks[0].Checked, tt.wantChecked)
+			}
+			for _, task := range m.tasks {
+				if task.Context != tt.wantContext {
+					t.Errorf("%q in %q, want %q", task.Task, task.Context, tt.wantContext)
+				}
+			}
+			if tt.mode == "off" && m.errorMessage == "" {
+				t.Error("refused toggle gave no message")
+			}
+		})
+	}
+}
+
+func TestKanbanCompletesSelectedCard(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "a", Context: "Home"},
+		Task{ID: 2, Task: "b", Context: "Work"},
+		Task{ID: 3, Task: "c", Context: "Work/Reports"},
+	)
+	columns, _ := m.kanbanColumns()
+	if !reflect.DeepEqual(columns, []string{"Home", "Work"}) {
+		t.Fatalf("columns = %q, want Home and Work", columns)
+	}
+
+	m.kanbanColumn, m.kanbanRow = 1, 5
+	m.clampKanbanSelection()
+	task, ok := m.kanbanSelected()
+	if !ok || task.ID != 3 {
+		t.Fatalf("selected %v (%v), want the subcontext card c", task.Task, ok)
+	}
+	if !m.toggleTaskIn("kanban", task.ID) || !m.tasks[2].Checked {
+		t.Error("card not completed from the board")
+	}
+}
+
+func TestRefusedToggleLeavesNoUndoStep(t *testing.T) {
+	tests := []struct {
+		name   string
+		toggle func(m *Model)
+	}{
+		{"single task", func(m *Model) { m.toggleUndoable("normal", 1) }},
+		{"view_complete off", func(m *Model) {
+			m.settings.ViewComplete = map[string]string{"normal": "off"}
+			m.toggleUndoable("normal", 2)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t,
+				Task{ID: 1, Task: "parent", Context: "Work"},
+				Task{ID: 2, Task: "child", Context: "Work", ParentID: 1},
+			)
+			m.settings.SubtaskCompletion = "require"
+			tt.toggle(&m)
+			if len(m.history) != 0 || m.dirty {
+				t.Errorf("history = %d, dirty = %v after a refused toggle", len(m.history), m.dirty)
+			}
+		})
+	}
+
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+	if !m.toggleUndoable("normal", 1) || len(m.history) != 1 || !m.dirty {
+		t.Errorf("history = %d, dirty = %v after completing", len(m.history), m.dirty)
+	}
+}
+
+func TestUndoMarksDirty(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+	m.toggleUndoable("normal", 1)
+	m.dirty = false // saved since
+	m.undo()
+	if m.tasks[0].Checked || !m.dirty {
+		t.Errorf("checked = %v, dirty = %v after undo, want the change back and unsaved", m.tasks[0].Checked, m.dirty)
+	}
+}
+
+func TestAfterScheduleHandsOn(t *testing.T) {
+	tests := []struct {
+		name       string
+		reopen     string
+		edit       bool
+		wantCopy   bool
+		wantSource string // schedule the reopened task ends up with
+	}{
+		{"untouched copy removed", "", false, false, "after weekly"},
+		{"edited copy stays", "", true, true, ""},
+		{"keep leaves the copy", "keep", false, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t, Task{ID: 1, Task: "water plants", Context: "Home", DueDate: "2026-10-15", Schedule: "after weekly"})
+			m.settings.RepeatReopen = tt.reopen
+
+			m.setTaskChecked(1, true)
+			if len(m.tasks) != 2 || m.tasks[0].Schedule != "" || m.tasks[1].Schedule != "after weekly" || m.tasks[1].DueDate != "2026-10-22" {
+				t.Fatalf("after completing: %+v", m.tasks)
+			}
+			if m.tasks[0].CompletionCount != 1 || m.tasks[1].CompletionCount != 1 {
+				t.Errorf("counts = %d, %d, want 1 carried to the copy", m.tasks[0].CompletionCount, m.tasks[1].CompletionCount)
+			}
+			if tt.edit {
+				m.tasks[1].Notes = "use rain water"
+			}
+
+			m.setTaskChecked(1, false)
+			if hasCopy := len(m.tasks) == 2; hasCopy != tt.wantCopy {
+				t.Errorf("copy kept = %v, want %v", hasCopy, tt.wantCopy)
+			}
+			if m.tasks[0].Schedule != tt.wantSource || m.tasks[0].CompletionCount != 0 {
+				t.Errorf("reopened task schedule %q, count %d; want %q, 0", m.tasks[0].Schedule, m.tasks[0].CompletionCount, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestPeriodicCopiesCountOnSource(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "standup", Context: "Work", Schedule: "daily"})
+	m.generateScheduled(time.Date(2026, 10, 15, 9, 0, 0, 0, time.Local))
+	if len(m.tasks) != 2 || m.tasks[1].Source != 1 {
+		t.Fatalf("tasks = %+v, want one copy of the schedule", m.tasks)
+	}
+
+	m.setTaskChecked(2, true)
+	if m.tasks[0].CompletionCount != 1 || len(m.tasks) != 2 {
+		t.Errorf("source count = %d with %d tasks, want 1 and no extra copy", m.tasks[0].CompletionCount, len(m.tasks))
+	}
+	m.setTaskChecked(2, false)
+	if m.tasks[0].CompletionCount != 0 {
+		t.Errorf("source count = %d after reopening, want 0", m.tasks[0].CompletionCount)
+	}
+}
+
+func TestTutorialNamesToggleKey(t *testing.T) {
+	m := newTestModel(t, Task{ID: 1, Task: "a", Context: "Work"})
+	m.settings.TutorialStep = 2
+	m.keyMap.Toggle = toggleBinding([]string{"ctrl+x"})
+	if got := m.renderTutorial(); !bytes.Contains([]byte(got), []byte("Press ctrl+x to tick")) {
+		t.Errorf("tutorial = %q, want it to name ctrl+x", got)
+	}
+}
+
+func TestMarkdownExportGroupsContexts(t *testing.T) {
+	var b strings.Builder
+	writeMarkdownTasks(&b, []Task{
+		{ID: 1, Task: "a", Context: "Work"},
+		{ID: 2, Task: "b", Context: "Home"},
+		{ID: 3, Task: "c", Context: "Work"},
+	}, 0, false)
+	want := "## Work\n\n- [ ] a\n- [ ] c\n\n## Home\n\n- [ ] b\n"
+	if b.String() != want {
+		t.Errorf("export =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestImportsGetShortCodes(t *testing.T) {
+	imports := []struct {
+		name, file, doc string
+		run             func(m *Model, path string) (int, error)
+	}{
+		{"markdown", "Work.md", "- [ ] a\n- [ ] b\n", (*Model).importMarkdown},
+		{"todoist", "Work.csv", "TYPE,CONTENT\ntask,a\ntask,b\n", (*Model).importTodoist},
+	}
+	for _, tt := range imports {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m := newTestModel(t, Task{ID: 1, Task: "old", Context: "Work", Code: "WRK-1"})
+			m.settings.CodePrefixes = map[string]string{"Work": "WRK"}
+			m.settings.CodeCounters = map[string]int{"WRK": 1}
+			if _, err := tt.run(&m, path); err != nil {
+				t.Fatal(err)
+			}
+
+			var codes []string
+			for _, task := range m.tasks {
+				codes = append(codes, task.Code)
+			}
+			if want := []string{"WRK-1", "WRK-2", "WRK-3"}; !reflect.DeepEqual(codes, want) {
+				t.Errorf("codes = %q, want %q", codes, want)
+			}
+			// A number is an ID, anything else a code
+			if i := m.findTaskByRef("wrk-3"); i != 2 {
+				t.Errorf("wrk-3 found at %d, want 2", i)
+			}
+			if i := m.findTaskByRef("#2"); i != 1 {
+				t.Errorf("#2 found at %d, want 1", i)
+			}
+		})
+	}
+}
+
+func TestTemplatesAreNotToggled(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "weekly report", Context: "Work", Template: true},
+		Task{ID: 2, Task: "a", Context: "Work"},
+	)
+	if m.toggleUndoable("normal", 1) || m.tasks[0].Checked || len(m.history) != 0 {
+		t.Errorf("template toggled: %+v", m.tasks[0])
+	}
+	m.toggleAllInContext()
+	if m.tasks[0].Checked || !m.tasks[1].Checked {
+		t.Errorf("toggle all: template checked %v, task checked %v", m.tasks[0].Checked, m.tasks[1].Checked)
+	}
+}
+
+func TestFocusModeKeys(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "a", Context: "Work"},
+		Task{ID: 2, Task: "b", Context: "Work"},
+	)
+	m.focusMode = true
+
+	next, _ := m.updateNormalView(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	m = next.(Model)
+	if m.viewMode != NormalView || m.errorMessage == "" {
+		t.Errorf("add key in focus mode: view %v, message %q; want it refused", m.viewMode, m.errorMessage)
+	}
+
+	next, _ = m.updateNormalView(tea.KeyMsg{Type: tea.KeyDown})
+	m = next.(Model)
+	if m.selectedIndex != 1 {
+		t.Errorf("selected %d after down, want 1", m.selectedIndex)
+	}
+}
+
+func TestFilteringLeavesSearchResults(t *testing.T) {
+	m := newTestModel(t,
+		Task{ID: 1, Task: "call bank", Context: "Home"},
+		Task{ID: 2, Task: "call mum", Context: "Home"},
+	)
+	m.searchTasks("call")
+	kept := append([]Task(nil), m.searchResults...)
+
+	m.tasks[1].Task = "visit mum"
+	if got := m.getFilteredTasks(); len(got) != 1 {
+		t.Errorf("filtered %d tasks after the edit, want 1", len(got))
+	}
+	if !reflect.DeepEqual(m.searchResults, kept) {
+		t.Errorf("searchResults changed by listing: %+v", m.searchResults)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    searchFilter
+		wantErr bool
+	}{
+		{"report", searchFilter{phrase: "report"}, false},
+		{"tag:Urgent due:today weekly report", searchFilter{phrase: "weekly report", tags: []string{"urgent"}, due: []string{"today"}}, false},
+		{"priority:high priority:none context:Work", searchFilter{priority: []string{"high", "none"}, contexts: []string{"work"}}, false},
+		{"is:stale text:a text:b", searchFilter{terms: []string{"a", "b"}, status: "stale"}, false},
+		{"meet at 10:30", searchFilter{phrase: "meet at 10:30"}, false},
+		{"tag: alone", searchFilter{phrase: "tag: alone"}, false},
+		{"priority:urgent", searchFilter{}, true},
+		{"due:someday", searchFilter{}, true},
+		{"is:blocked", searchFilter{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseSearchQuery(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuickAddParse(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		prefixes QuickAdd
+		text     string
+		wantRest string
+		want     Task
+	}{
+		{"defaults", QuickAdd{}, "call bank #money !h @Home due:tomorrow", "call bank", Task{Tags: []string{"money"}, Priority: "high", Context: "Home", DueDate: "2026-10-16"}},
+		{"dated", QuickAdd{}, "file taxes due:2026-11-01", "file taxes", Task{DueDate: "2026-11-01"}},
+		{"unknown priority stays", QuickAdd{}, "fix !urgent bug", "fix !urgent bug", Task{}},
+		{"custom prefixes", QuickAdd{Tag: "+", Context: "in:", Due: "by:"}, "call +phone in:Work by:today #1", "call #1", Task{Tags: []string{"phone"}, Context: "Work", DueDate: "2026-10-15"}},
+		{"tag parsing off", QuickAdd{Tag: "off"}, "issue #12 !l", "issue #12", Task{Priority: "low"}},
+		{"only quick-add words", QuickAdd{}, "#tag !h", "#tag !h", Task{}},
+		{"first line only", QuickAdd{}, "call #phone\nnotes #not-a-tag", "call\nnotes #not-a-tag", Task{Tags: []string{"phone"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, got := tt.prefixes.resolve().parse(tt.text, now)
+			if rest != tt.wantRest || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse = %q, %+v; want %q, %+v", rest, got, tt.wantRest, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	tests := []struct {
+		input   string
+		current int
+		want    int
+		ok      bool
+	}{
+		{"", 40, 0, true},
+		{"50", 10, 50, true},
+		{"75%", 0, 75, true},
+		{"+30", 40, 70, true},
+		{"+80", 40, 100, true},
+		{"-60", 40, 0, true},
+		{"101", 0, 0, false},
+		{"half", 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parsePercent(tt.input, tt.current)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parsePercent(%q, %d) = %d, %v; want %d, %v", tt.input, tt.current, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExpandYear(t *testing.T) {
+	tests := map[string]string{
+		"26":   "2026",
+		"7":    "2007",
+		" 26 ": "2026",
+		"2031": "2031",
+		"":     "",
+		"yy":   "yy",
+	}
+	for input, want := range tests {
+		if got := expandYear(input); got != want {
+			t.Errorf("expandYear(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestParseCloneTarget(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantTo    string
+		wantShift int
+		wantErr   bool
+	}{
+		{"Trip 2027", "Trip 2027", 0, false},
+		{"Sprint 12 +14", "Sprint 12", 14, false},
+		{"Archive -3d", "Archive", -3, false},
+		{"+7", "+7", 0, false},
+		{"Sprint +two", "", 0, true},
+		{"", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			to, shift, err := parseCloneTarget(tt.input)
+			if (err != nil) != tt.wantErr || to != tt.wantTo || shift != tt.wantShift {
+				t.Errorf("parseCloneTarget = %q, %d, %v; want %q, %d, error %v", to, shift, err, tt.wantTo, tt.wantShift, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandSnippet(t *testing.T) {
+	snippets := map[string]string{";f": "follow up with", ";fu": "follow up on", ";m": "meeting"}
+	tests := []struct {
+		name   string
+		value  string
+		forced bool
+		want   string
+	}{
+		{"expands at a word start", "prep ;m", false, "prep meeting"},
+		{"longest trigger wins", ";fu", false, "follow up on"},
+		{"prefix of a longer trigger waits", ";f", false, ";f"},
+		{"tab forces it", ";f", true, "follow up with"},
+		{"not inside a word", "x;m", false, "x;m"},
+		{"no trigger", "plain", false, "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t)
+			m.settings.Snippets = snippets
+			m.inputMode = AddTaskInput
+			m.textInput.SetValue(tt.value)
+			m.textInput.SetCursor(len([]rune(tt.value)))
+			expanded := m.expandSnippet(tt.forced)
+			if got := m.textInput.Value(); got != tt.want || expanded != (tt.want != tt.value) {
+				t.Errorf("value = %q (expanded %v), want %q", got, expanded, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepeatInstance(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.Local) // a Thursday
+	tests := []struct {
+		name      string
+		task      Task
+		wantDue   string
+		wantStart string
+	}{
+		{"daily", Task{Schedule: "after daily", DueDate: "2026-10-15"}, "2026-10-16", ""},
+		{"weekdays skip the weekend", Task{Schedule: "after weekdays", DueDate: "2026-10-16"}, "2026-10-19", ""},
+		{"weekly keeps the time", Task{Schedule: "after weekly", DueDate: "2026-10-15 09:30"}, "2026-10-22 09:30", ""},
+		{"monthly", Task{Schedule: "after monthly", DueDate: "2026-10-31"}, "2026-12-01", ""},
+		{"no due date counts from today", Task{Schedule: "after daily"}, "2026-10-16", ""},
+		{"a range keeps its length", Task{Schedule: "after weekly", StartDate: "2026-10-13", DueDate: "2026-10-15"}, "2026-10-22", "2026-10-20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.ID, tt.task.Checked, tt.task.Percent = 7, true, 100
+			got := repeatInstance(tt.task, now)
+			if got.DueDate != tt.wantDue || got.StartDate != tt.wantStart {
+				t.Errorf("dates = %q to %q, want %q to %q", got.StartDate, got.DueDate, tt.wantStart, tt.wantDue)
+			}
+			if got.Checked || got.Percent != 0 || got.Source != 7 || got.Schedule != tt.task.Schedule {
+				t.Errorf("instance = %+v, want an open copy of 7 carrying its schedule", got)
+			}
+		})
+	}
+}
+
+func TestDueReminderTask(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.Local)
+	launch := now.Add(-2 * time.Hour)
+	tests := []struct {
+		name   string
+		task   Task
+		snooze time.Duration // from now; 0 leaves the reminder unsnoozed
+		seen   bool          // dismissed or followed already
+		want   bool
+	}{
+		{"deadline passed since launch", Task{DueDate: "2026-10-15 11:00"}, 0, false, true},
+		{"deadline before launch", Task{DueDate: "2026-10-15 09:00"}, 0, false, false},
+		{"deadline still ahead", Task{DueDate: "2026-10-15 13:00"}, 0, false, false},
+		{"snooze over", Task{DueDate: "2026-10-15 09:00"}, -time.Minute, false, true},
+		{"still snoozed", Task{DueDate: "2026-10-15 11:00"}, time.Minute, false, false},
+		{"already seen", Task{DueDate: "2026-10-15 11:00"}, 0, true, false},
+		{"done", Task{DueDate: "2026-10-15 11:00", Checked: true}, 0, false, false},
+		{"template", Task{DueDate: "2026-10-15 11:00", Template: true}, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.ID, tt.task.Task, tt.task.Context = 1, "a", "Work"
+			m := newTestModel(t, tt.task)
+			m.remindSince = launch
+			m.remindAt, m.reminded = make(map[int]time.Time), make(map[int]bool)
+			if tt.snooze != 0 {
+				m.remindAt[1] = now.Add(tt.snooze)
+			}
+			if tt.seen {
+				m.reminded[1] = true
+			}
+			if _, got := m.dueReminderTask(now); got != tt.want {
+				t.Errorf("due = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}